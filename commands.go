@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/scanner"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+// commands are run instead of the daemon when their name is
+// the first argument, they return the exit status
+var commands = map[string]func(args []string) int{
+	"render": renderCommand,
+	"outbox": outboxCommand,
+	"scans":  scansCommand,
+}
+
+// sampleMovies are the movies message templates are rendered
+// against by render command
+var sampleMovies = []metadata.Movie{
+	{
+		Title:     "The Shawshank Redemption",
+		Year:      "1994",
+		Thumbnail: "https://image.tmdb.org/t/p/w92/q6y0Go1tsGEsmtFryDOJo3dEmqu.jpg",
+		Synopsis:  "Framed in the 1940s for the double murder of his wife and her lover, upstanding banker Andy Dufresne begins a new life at the Shawshank prison.",
+		Genres:    []string{"Drama", "Crime"},
+		TMDbID:    "278",
+		IMDbID:    "tt0111161",
+		Trailer:   "https://www.youtube.com/watch?v=PLl99DlL6b4",
+		Ratings: []metadata.Rating{
+			{Source: "IMDb", Value: "9.3/10", Votes: 2871412},
+			{Source: "Rotten Tomatoes", Value: "89%"},
+		},
+	},
+	{
+		Title:     "Spirited Away",
+		Year:      "2001",
+		Thumbnail: "https://image.tmdb.org/t/p/w92/39wmItIWsg5sZMyRUHLkWBcuVCM.jpg",
+		Synopsis:  "A young girl, Chihiro, becomes trapped in a strange new world of spirits.",
+		Genres:    []string{"Animation", "Family", "Fantasy"},
+		TMDbID:    "129",
+		IMDbID:    "tt0245429",
+	},
+}
+
+// renderCommand renders a message template against sample data and
+// prints the resulting Slack message, so that templates can be
+// previewed before they are deployed
+func renderCommand(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	path := flags.String("config", configPath, "path to the config file holding the templates")
+	event := flags.String("event", string(notify.KindNew), "event type to render: new, removed, upgraded or digest")
+	library := flags.String("library", "", "library whose template is rendered, the global template is used when empty")
+	file := flags.String("template", "", "template file to render instead of the one in config file")
+	flags.Parse(args)
+
+	kind := notify.Kind(*event)
+	if !validKind(kind) {
+		fmt.Fprintf(os.Stderr, "unknown event type %q\n", *event)
+		return 2
+	}
+	cfg := &config.Config{}
+	if loaded, err := config.New().Load(*path); err == nil {
+		cfg = loaded
+	} else if len(*file) == 0 {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	conf = cfg
+	var tmpl *notify.Template
+	var err error
+	if len(*file) > 0 {
+		tmpl, err = notify.ParseTemplateFile(*file)
+	} else {
+		templates, err = loadTemplates(cfg)
+		tmpl = templateFor(*library, kind)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	announcement := notify.Announcement{
+		Kind:    kind,
+		Library: *library,
+		Movie:   sampleMovies[0],
+		PlexURL: cfg.PlexURL,
+		Links: notify.Links{
+			Trailer: cfg.Slack.Links.Trailer,
+			IMDb:    cfg.Slack.Links.IMDb,
+			TMDb:    cfg.Slack.Links.TMDb,
+		},
+	}
+	if kind == notify.KindDigest {
+		announcement.Movies = sampleMovies
+	}
+	payload, err := notify.Render(cfg.Slack.Layout, tmpl, announcement)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(payload, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
+
+// outboxCommand lists the messages waiting in the outbox and the dead
+// letters, the messages given up after retrying them, and replays the
+// dead letters by their key or all of them when no key is given
+func outboxCommand(args []string) int {
+	flags := flag.NewFlagSet("outbox", flag.ExitOnError)
+	path := flags.String("config", configPath, "path to the config file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: plexgoslack outbox [-config=path] list|replay [key...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	cfg, err := config.New().Load(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	loaded, _ := loadDestinations(cfg)
+	state, err := store.Open(cfg.StateFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	box, err := newOutbox(cfg, loaded, state)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	switch flags.Arg(0) {
+	case "list":
+		out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(out, "STATE\tKEY\tEVENT\tCREATED\tATTEMPTS\tERROR")
+		for _, entry := range box.Pending() {
+			fmt.Fprintf(out, "pending\t%s\t%s\t%s\t%d\t%s\n", entry.Key, entry.Message.Kind, entry.Message.Created.Format(time.RFC3339), entry.Attempts, entry.Error)
+		}
+		for _, entry := range box.Dead() {
+			fmt.Fprintf(out, "dead\t%s\t%s\t%s\t%d\t%s\n", entry.Key, entry.Message.Kind, entry.Message.Created.Format(time.RFC3339), entry.Attempts, entry.Error)
+		}
+		out.Flush()
+		return 0
+	case "replay":
+		keys := flags.Args()[1:]
+		if len(keys) == 0 {
+			for _, entry := range box.Dead() {
+				keys = append(keys, entry.Key)
+			}
+		}
+		status := 0
+		for _, key := range keys {
+			if err := box.Replay(context.Background(), key); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: replaying %s: %s\n", key, err)
+				status = 1
+				continue
+			}
+			fmt.Println("replayed", key)
+		}
+		return status
+	}
+	flags.Usage()
+	return 2
+}
+
+// scansCommand lists the latest scans kept in state file, the output
+// of a failed scan is printed below it with -v
+func scansCommand(args []string) int {
+	flags := flag.NewFlagSet("scans", flag.ExitOnError)
+	path := flags.String("config", configPath, "path to the config file")
+	verbose := flags.Bool("v", false, "print the output of the failed scans")
+	flags.Parse(args)
+	cfg, err := config.New().Load(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	state, err := store.Open(cfg.StateFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	names := []string{defaultServer}
+	for name := range cfg.PlexServers {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "SERVER\tSTARTED\tSECTION\tFOLDER\tDURATION\tEXIT\tERROR")
+	for _, name := range names {
+		records, err := scanner.History(state, scansBucket(name))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		for _, record := range records {
+			fmt.Fprintf(out, "%s\t%s\t%d\t%s\t%s\t%d\t%s\n", name, record.Started.Format(time.RFC3339), record.Section, record.Folder,
+				record.Duration.Round(time.Millisecond), record.ExitCode, record.Error)
+			if *verbose && record.Failed() {
+				out.Flush()
+				fmt.Printf("%s%s\n", record.Stdout, record.Stderr)
+			}
+		}
+	}
+	out.Flush()
+	return 0
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/outbox"
+	"github.com/rimaulana/plexgoslack/scanner"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+// capture returns the exit status of command and what it printed on
+// stdout
+func capture(t *testing.T, command func(args []string) int, args ...string) (int, string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printed := make(chan string)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		printed <- string(out)
+	}()
+	status := command(args)
+	os.Stdout = stdout
+	w.Close()
+	return status, <-printed
+}
+
+// commandDir returns a temporary folder holding config.toml, which
+// keeps the state file in the folder too
+func commandDir(t *testing.T, cfg string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = fmt.Sprintf("state_file = %q\n%s", filepath.Join(dir, "state.json"), cfg)
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.toml"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRenderCommand(t *testing.T) {
+	dir := commandDir(t, "")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "new.tmpl")
+	ioutil.WriteFile(file, []byte("{{.Movie.Title}} landed"), 0644)
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		output string
+	}{
+		{name: "case template file", args: []string{"-config", filepath.Join(dir, "missing.toml"), "-template", file}, output: "The Shawshank Redemption landed"},
+		{name: "case config file", args: []string{"-config", filepath.Join(dir, "config.toml"), "-event", "digest"}, output: "Spirited Away"},
+		{name: "case unknown event type", args: []string{"-event", "deleted"}, status: 2},
+		{name: "case missing config file without template", args: []string{"-config", filepath.Join(dir, "missing.toml")}, status: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, output := capture(t, renderCommand, tt.args...)
+			if status != tt.status || !strings.Contains(output, tt.output) {
+				t.Errorf("renderCommand(%q) = %d printing %q, want %d printing %q", tt.args, status, output, tt.status, tt.output)
+			}
+		})
+	}
+}
+
+func TestOutboxCommand(t *testing.T) {
+	dir := commandDir(t, "[destinations.general]\nwebhook = \""+hook+"\"\n")
+	defer os.RemoveAll(dir)
+	state, err := store.Open(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	box := outbox.New(state, map[string]notify.Destination{})
+	box.Enqueue(notify.Message{Destination: "general", Item: "movies/Heat (1995)", Kind: notify.KindNew, Created: time.Now()})
+	key := box.Pending()[0].Key
+	path := filepath.Join(dir, "config.toml")
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		output string
+	}{
+		{name: "case no subcommand", args: []string{"-config", path}, status: 2},
+		{name: "case list", args: []string{"-config", path, "list"}, output: "pending  " + key},
+		{name: "case replay unknown key", args: []string{"-config", path, "replay", "general/movies/Coco (2017)"}, status: 1},
+		{name: "case unknown subcommand", args: []string{"-config", path, "purge"}, status: 2},
+		{name: "case missing config file", args: []string{"-config", filepath.Join(dir, "missing.toml"), "list"}, status: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, output := capture(t, outboxCommand, tt.args...)
+			if status != tt.status || !strings.Contains(output, tt.output) {
+				t.Errorf("outboxCommand(%q) = %d printing %q, want %d printing %q", tt.args, status, output, tt.status, tt.output)
+			}
+		})
+	}
+}
+
+func TestScansCommand(t *testing.T) {
+	dir := commandDir(t, "[plex_servers.office]\nurl = \"http://10.0.0.5:32400\"\n")
+	defer os.RemoveAll(dir)
+	state, err := store.Open(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scans := scanner.NewScheduler(&scannerStub{}, 0, scanner.WithHistory(state, scansBucket("office"), 5))
+	<-scans.Request(context.Background(), 3, "/movies/Heat (1995)")
+
+	status, output := capture(t, scansCommand, "-config", filepath.Join(dir, "config.toml"))
+	if status != 0 || !strings.Contains(output, "office") || !strings.Contains(output, "/movies/Heat (1995)") {
+		t.Errorf("scansCommand() = %d printing %q, want the scan of office", status, output)
+	}
+	if status, _ := capture(t, scansCommand, "-config", filepath.Join(dir, "missing.toml")); status != 1 {
+		t.Errorf("scansCommand() with missing config file = %d, want 1", status)
+	}
+}
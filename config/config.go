@@ -2,6 +2,7 @@ package config
 
 import (
 	"io/ioutil"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -15,38 +16,282 @@ type CfgLoader struct {
 	Reader func(filename string) ([]byte, error)
 }
 
+// Duration wraps time.Duration so that it can be written
+// in toml config file as a string such as "5s" or "1m30s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses duration string from toml config file
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
 // TmdbCfg represent a section on toml config file
 // that hold the APIKey required to authenticate
-// request to The Movie DB API endpoint.
+// request to The Movie DB API endpoint. AccessToken
+// is the v4 read access token, when it is set it will
+// be used instead of APIKey. The rest of the settings
+// are optional and override the default of tmdb client,
+// Proxy and CABundle override the ones of network section.
 type TmdbCfg struct {
-	APIKey string `toml:"api_key"`
+	APIKey      string   `toml:"api_key"`
+	AccessToken string   `toml:"access_token"`
+	BaseURL     string   `toml:"base_url"`
+	Timeout     Duration `toml:"timeout"`
+	UserAgent   string   `toml:"user_agent"`
+	Proxy       string   `toml:"proxy"`
+	CABundle    string   `toml:"ca_bundle"`
+}
+
+// OmdbCfg represent a section on toml config file
+// that hold the APIKey required to authenticate
+// request to OMDb API endpoint, it is only needed
+// when omdb is listed as metadata provider. Proxy and
+// CABundle override the ones of network section.
+type OmdbCfg struct {
+	APIKey   string   `toml:"api_key"`
+	BaseURL  string   `toml:"base_url"`
+	Timeout  Duration `toml:"timeout"`
+	Proxy    string   `toml:"proxy"`
+	CABundle string   `toml:"ca_bundle"`
+}
+
+// ImdbCfg represent a section on toml config file
+// that locates IMDb dataset dumps and the local store
+// they are imported into, it is only needed when imdb
+// is listed as metadata provider. The store is imported
+// again when the dumps are newer, checked every Refresh.
+type ImdbCfg struct {
+	Ratings string   `toml:"ratings"`
+	Basics  string   `toml:"basics"`
+	Store   string   `toml:"store"`
+	Refresh Duration `toml:"refresh"`
+}
+
+// NetworkCfg represent a section on toml config file
+// holding how every integration reaches the internet.
+// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy,
+// or direct to ignore the proxy of environment. CABundle
+// is a PEM file of certificate authorities trusted along
+// with the ones of the system.
+type NetworkCfg struct {
+	Proxy    string `toml:"proxy"`
+	CABundle string `toml:"ca_bundle"`
+}
+
+// PlexServerCfg represent a section on toml config file
+// locating the HTTP API of Plex Media Server, it is used
+// to confirm, link, match, discover and scan the movies
+// of its libraries. See README for every setting.
+type PlexServerCfg struct {
+	URL          string            `toml:"url"`
+	Token        string            `toml:"token"`
+	Timeout      Duration          `toml:"timeout"`
+	IndexTimeout Duration          `toml:"index_timeout"`
+	Confirm      bool              `toml:"confirm"`
+	FixMatch     bool              `toml:"fix_match"`
+	Discover     bool              `toml:"discover"`
+	Poll         Duration          `toml:"poll"`
+	Include      []string          `toml:"include"`
+	Exclude      []string          `toml:"exclude"`
+	Remap        map[string]string `toml:"remap"`
+	Proxy        string            `toml:"proxy"`
+	CABundle     string            `toml:"ca_bundle"`
+	PlexURL      string            `toml:"plex_url"`
+	Scanner      ScannerCfg        `toml:"scanner"`
+}
+
+// PlexWebhookCfg represent a section on toml config file
+// setting up the endpoint receiving the webhooks of Plex
+// Media Server, for setups where the media folders can't
+// be watched. It listens on Listen when it is set, such as
+// :9090, for webhooks sent to Path followed by Secret or
+// having Secret as token query parameter.
+type PlexWebhookCfg struct {
+	Listen string `toml:"listen"`
+	Path   string `toml:"path"`
+	Secret string `toml:"secret"`
+}
+
+// ScannerCfg represent a section on toml config file
+// choosing how Plex is asked to scan the folders that
+// changed, through its API or by running Plex Media
+// Scanner. See README for every setting.
+type ScannerCfg struct {
+	Backend string   `toml:"backend"`
+	Quiet   Duration `toml:"quiet"`
+	Command []string `toml:"command"`
+	Env     []string `toml:"env"`
+	User    string   `toml:"user"`
+	Dir     string   `toml:"dir"`
+	Timeout Duration `toml:"timeout"`
+	History int      `toml:"history"`
+}
+
+// MetadataCfg represent a section on toml config file
+// that lists the metadata providers asked for movie
+// information, in the order they are asked.
+type MetadataCfg struct {
+	Providers []string `toml:"providers"`
+}
+
+// LinksCfg represent a section on toml config file
+// that toggles each of the links added to movie
+// announcement, all of them are enabled by default.
+type LinksCfg struct {
+	Trailer bool `toml:"trailer"`
+	IMDb    bool `toml:"imdb"`
+	TMDb    bool `toml:"tmdb"`
 }
 
 // SlackCfg represent a section on toml config file
 // that contains a collection of Slack webhook that
 // will be contacted on when there is new update on
-// the movie collection
+// the movie collection. Layout is either blocks or
+// legacy for workspaces that can't show Block Kit.
+// BotToken is the token of the Slack app used by
+// destinations posting to a channel. Rate is how many
+// messages per second are sent to every destination,
+// Burst of them can be sent at once. Proxy and CABundle
+// override the ones of network section.
 type SlackCfg struct {
-	Webhook []string `toml:"webhooks"`
+	Webhook  []string `toml:"webhooks"`
+	Layout   string   `toml:"layout"`
+	Links    LinksCfg `toml:"links"`
+	BotToken string   `toml:"bot_token"`
+	APIURL   string   `toml:"api_url"`
+	Rate     float64  `toml:"rate"`
+	Burst    int      `toml:"burst"`
+	Proxy    string   `toml:"proxy"`
+	CABundle string   `toml:"ca_bundle"`
+}
+
+// TemplateCfg represents a message template on toml config
+// file, either written inline in Text or kept in a File.
+type TemplateCfg struct {
+	Text string `toml:"text"`
+	File string `toml:"file"`
+}
+
+// DestinationCfg represents a section on toml config file
+// describing a place announcements are sent to, either a
+// Webhook or a Channel posted to with the bot token. Layout
+// overrides the layout set in slack section. OnRemove tells
+// what happens to the message of a removed movie posted to
+// a channel: strike, delete or post. Digest makes the
+// destination get a single message listing the new movies
+// hourly, daily at DigestAt or weekly on DigestDay. Messages
+// are held during QuietHours, written as 22:00-07:00, and
+// QuietMerge sends the movies added meanwhile in a single
+// message. Times are in TimeZone, local time by default.
+// Rate and Burst override the ones set in slack section.
+type DestinationCfg struct {
+	Webhook    string  `toml:"webhook"`
+	Channel    string  `toml:"channel"`
+	OnRemove   string  `toml:"on_remove"`
+	Layout     string  `toml:"layout"`
+	Digest     string  `toml:"digest"`
+	DigestAt   string  `toml:"digest_at"`
+	DigestDay  string  `toml:"digest_day"`
+	QuietHours string  `toml:"quiet_hours"`
+	QuietMerge bool    `toml:"quiet_merge"`
+	TimeZone   string  `toml:"timezone"`
+	Rate       float64 `toml:"rate"`
+	Burst      int     `toml:"burst"`
+}
+
+// RouteCfg represents an entry of routes array on toml config
+// file. Announcements matching every condition set in the route
+// are sent to its destinations, a condition listing several
+// values matches when any of them matches. Title is a regular
+// expression matched against the movie title. Servers are the
+// names of the servers of plex_servers section.
+type RouteCfg struct {
+	Servers        []string `toml:"servers"`
+	Libraries      []string `toml:"libraries"`
+	Events         []string `toml:"events"`
+	Genres         []string `toml:"genres"`
+	Certifications []string `toml:"certifications"`
+	Resolutions    []string `toml:"resolutions"`
+	Title          string   `toml:"title"`
+	Destinations   []string `toml:"destinations"`
+}
+
+// RoutingCfg represents a section on toml config file holding
+// the destinations of announcements no route matches, and the
+// Ops destinations told when something goes wrong.
+type RoutingCfg struct {
+	Default []string `toml:"default"`
+	Ops     []string `toml:"ops"`
+}
+
+// OutboxCfg represents a section on toml config file tuning how
+// messages Slack didn't accept are retried. The wait between two
+// attempts starts at MinBackoff and doubles up to MaxBackoff, the
+// message is given up after Expire and kept as dead letter.
+type OutboxCfg struct {
+	MinBackoff Duration `toml:"min_backoff"`
+	MaxBackoff Duration `toml:"max_backoff"`
+	Expire     Duration `toml:"expire"`
 }
 
 // PlexLibCfg represents a section on toml config file.
 // it holds the information on the folder that needs to
 // monitored for changes and the plex section number for
-// the associated folder
+// the associated folder. Templates are keyed by event
+// type and override the global ones for this library.
+// ScanTimeout overrides the timeout of scanner section.
+// Server names the server of plex_servers section holding
+// the library, the one of plex_server section when empty.
+// ArtworkURL is the URL root is served at, so that the
+// artwork found next to a movie can be shown in Slack.
 type PlexLibCfg struct {
-	Root    string `toml:"root"`
-	Section int    `toml:"section"`
+	Root        string                 `toml:"root"`
+	Section     int                    `toml:"section"`
+	Templates   map[string]TemplateCfg `toml:"templates"`
+	ScanTimeout Duration               `toml:"scan_timeout"`
+	Server      string                 `toml:"server"`
+	ArtworkURL  string                 `toml:"artwork_url"`
 }
 
 // Config represent the main configuration file that
 // contains all sections of the config. This will be the
 // one that will be the result of this package
 type Config struct {
-	Tmdb    TmdbCfg               `toml:"tmdb"`
-	PlexURL string                `toml:"plex_url"`
-	Plex    map[string]PlexLibCfg `toml:"plex"`
-	Slack   SlackCfg              `toml:"slack"`
+	Network  NetworkCfg            `toml:"network"`
+	Tmdb     TmdbCfg               `toml:"tmdb"`
+	Omdb     OmdbCfg               `toml:"omdb"`
+	Imdb     ImdbCfg               `toml:"imdb"`
+	Metadata MetadataCfg           `toml:"metadata"`
+	PlexURL  string                `toml:"plex_url"`
+	Plex     map[string]PlexLibCfg `toml:"plex"`
+	// PlexServer is the API of the server indexing the
+	// libraries of Plex
+	PlexServer PlexServerCfg `toml:"plex_server"`
+	// PlexServers are more servers keyed by name, their
+	// timeouts default to the ones of plex_server section
+	// and the settings of their scanner to the ones of
+	// scanner section, but for the backend
+	PlexServers map[string]PlexServerCfg `toml:"plex_servers"`
+	Scanner     ScannerCfg               `toml:"scanner"`
+	// PlexWebhook receives the webhooks of Plex
+	PlexWebhook PlexWebhookCfg `toml:"plex_webhook"`
+	Slack       SlackCfg       `toml:"slack"`
+	// Templates are keyed by event type: new, removed,
+	// upgraded or digest
+	Templates    map[string]TemplateCfg    `toml:"templates"`
+	Destinations map[string]DestinationCfg `toml:"destinations"`
+	Routes       []RouteCfg                `toml:"routes"`
+	Routing      RoutingCfg                `toml:"routing"`
+	Outbox       OutboxCfg                 `toml:"outbox"`
+	// StateFile keeps what was posted where across restarts,
+	// the messages and webhooks older than StateExpire are
+	// forgotten
+	StateFile   string   `toml:"state_file"`
+	StateExpire Duration `toml:"state_expire"`
 }
 
 // New creates new instance of CfgLoader with its default
@@ -68,10 +313,50 @@ func (cfg *CfgLoader) Load(Path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	var buffer Config
+	buffer := defaults()
 	_, errs := toml.Decode(string(rawData[:]), &buffer)
 	if errs != nil {
 		return nil, errs
 	}
 	return &buffer, nil
 }
+
+// defaults returns the Config holding the default value of
+// every setting that is not zero value, settings found in
+// config file override them.
+func defaults() Config {
+	return Config{
+		StateFile:   "state.json",
+		StateExpire: Duration{90 * 24 * time.Hour},
+		PlexServer: PlexServerCfg{
+			IndexTimeout: Duration{2 * time.Minute},
+		},
+		Scanner: ScannerCfg{
+			Quiet:   Duration{10 * time.Second},
+			Timeout: Duration{30 * time.Minute},
+			History: 20,
+		},
+		PlexWebhook: PlexWebhookCfg{
+			Path: "/plex",
+		},
+		Outbox: OutboxCfg{
+			MinBackoff: Duration{30 * time.Second},
+			MaxBackoff: Duration{30 * time.Minute},
+			Expire:     Duration{24 * time.Hour},
+		},
+		Imdb: ImdbCfg{
+			Store:   "imdb.db",
+			Refresh: Duration{24 * time.Hour},
+		},
+		Slack: SlackCfg{
+			Layout: "blocks",
+			Rate:   1,
+			Burst:  1,
+			Links: LinksCfg{
+				Trailer: true,
+				IMDb:    true,
+				TMDb:    true,
+			},
+		},
+	}
+}
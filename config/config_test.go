@@ -5,38 +5,211 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
 	sampleTOML = `plex_url = "https://apps.plex.tv/"
+[network]
+proxy = "http://proxy.example.com:3128"
+ca_bundle = "/etc/ssl/corporate.pem"
 [tmdb]
 api_key = "1234567890"
+base_url = "http://localhost:8080/3"
+timeout = "10s"
+[omdb]
+api_key = "abcdef"
+proxy = "direct"
+[imdb]
+ratings = "/data/title.ratings.tsv.gz"
+[metadata]
+providers = ["tmdb", "omdb", "imdb"]
 [slack]
 webhooks = ["slack_webhook_1","slack_webhook_2"]
+layout = "legacy"
+bot_token = "xoxb-1234"
+[slack.links]
+tmdb = false
+[templates.new]
+file = "/path/to/new.tmpl"
+[destinations.learning]
+webhook = "slack_webhook_3"
+digest = "weekly"
+digest_at = "09:00"
+digest_day = "friday"
+[destinations.home-theater]
+webhook = "slack_webhook_4"
+layout = "legacy"
+quiet_hours = "22:00-07:00"
+quiet_merge = true
+timezone = "Asia/Jakarta"
+[destinations.announcements]
+channel = "#movies"
+on_remove = "delete"
+rate = 0.5
+burst = 3
+[[routes]]
+libraries = ["show"]
+destinations = ["learning"]
+[[routes]]
+servers = ["office"]
+destinations = ["announcements"]
+[[routes]]
+resolutions = ["2160p"]
+title = "(?i)^star wars"
+destinations = ["home-theater", "learning"]
+[routing]
+default = ["learning"]
+ops = ["announcements"]
+[outbox]
+expire = "6h"
+[plex_server]
+url = "http://127.0.0.1:32400"
+token = "plex-token"
+confirm = true
+fix_match = true
+discover = true
+poll = "5m"
+exclude = ["Home Videos"]
+[plex_server.remap]
+"/data/movies" = "/mnt/media/movies"
+[scanner]
+backend = "cli"
+command = ["docker", "exec", "plex", "/usr/lib/plexmediaserver/Plex Media Scanner", "--section", "{{.Section}}"]
+env = ["LANG=C.UTF-8"]
+timeout = "1h"
+[plex_servers.office]
+url = "http://10.0.0.5:32400"
+token = "office-token"
+plex_url = "https://app.plex.tv/desktop/"
+[plex_servers.office.scanner]
+quiet = "1m"
+[plex_webhook]
+listen = ":9090"
+secret = "s3cret"
 [plex]
 [plex.movies] # the naming after plex. is up to you
 root = "/path/to/movie" #path where you keep you movie2 collection
 section = 1
+scan_timeout = "2h"
+[plex.office]
+root = "/mnt/office/movies"
+section = 4
+server = "office"
+[plex.movies.templates.removed]
+text = "{{.Movie.Title}} is gone"
 [plex.show] # the naming after plex. is up to you
 root = "/path/to/shows" #path where you keep you movie2 collection
 section = 2`
 	sampleOutput = &Config{
 		Tmdb: TmdbCfg{
-			APIKey: "1234567890",
+			APIKey:  "1234567890",
+			BaseURL: "http://localhost:8080/3",
+			Timeout: Duration{10 * time.Second},
+		},
+		Network: NetworkCfg{
+			Proxy:    "http://proxy.example.com:3128",
+			CABundle: "/etc/ssl/corporate.pem",
+		},
+		Omdb: OmdbCfg{
+			APIKey: "abcdef",
+			Proxy:  "direct",
+		},
+		Imdb: ImdbCfg{
+			Ratings: "/data/title.ratings.tsv.gz",
+			Store:   "imdb.db",
+			Refresh: Duration{24 * time.Hour},
+		},
+		Metadata: MetadataCfg{
+			Providers: []string{"tmdb", "omdb", "imdb"},
 		},
 		Slack: SlackCfg{
-			Webhook: []string{"slack_webhook_1", "slack_webhook_2"},
+			Webhook:  []string{"slack_webhook_1", "slack_webhook_2"},
+			Layout:   "legacy",
+			BotToken: "xoxb-1234",
+			Rate:     1,
+			Burst:    1,
+			Links: LinksCfg{
+				Trailer: true,
+				IMDb:    true,
+				TMDb:    false,
+			},
+		},
+		PlexURL:     `https://apps.plex.tv/`,
+		StateFile:   "state.json",
+		StateExpire: Duration{90 * 24 * time.Hour},
+		PlexServer: PlexServerCfg{
+			URL:          "http://127.0.0.1:32400",
+			Token:        "plex-token",
+			IndexTimeout: Duration{2 * time.Minute},
+			Confirm:      true,
+			FixMatch:     true,
+			Discover:     true,
+			Poll:         Duration{5 * time.Minute},
+			Exclude:      []string{"Home Videos"},
+			Remap:        map[string]string{"/data/movies": "/mnt/media/movies"},
+		},
+		PlexServers: map[string]PlexServerCfg{
+			"office": PlexServerCfg{
+				URL:     "http://10.0.0.5:32400",
+				Token:   "office-token",
+				PlexURL: "https://app.plex.tv/desktop/",
+				Scanner: ScannerCfg{Quiet: Duration{time.Minute}},
+			},
+		},
+		Scanner: ScannerCfg{
+			Backend: "cli",
+			Quiet:   Duration{10 * time.Second},
+			Command: []string{"docker", "exec", "plex", "/usr/lib/plexmediaserver/Plex Media Scanner", "--section", "{{.Section}}"},
+			Env:     []string{"LANG=C.UTF-8"},
+			Timeout: Duration{time.Hour},
+			History: 20,
+		},
+		PlexWebhook: PlexWebhookCfg{
+			Listen: ":9090",
+			Path:   "/plex",
+			Secret: "s3cret",
+		},
+		Templates: map[string]TemplateCfg{
+			"new": TemplateCfg{File: "/path/to/new.tmpl"},
+		},
+		Destinations: map[string]DestinationCfg{
+			"learning":      DestinationCfg{Webhook: "slack_webhook_3", Digest: "weekly", DigestAt: "09:00", DigestDay: "friday"},
+			"home-theater":  DestinationCfg{Webhook: "slack_webhook_4", Layout: "legacy", QuietHours: "22:00-07:00", QuietMerge: true, TimeZone: "Asia/Jakarta"},
+			"announcements": DestinationCfg{Channel: "#movies", OnRemove: "delete", Rate: 0.5, Burst: 3},
+		},
+		Routes: []RouteCfg{
+			{Libraries: []string{"show"}, Destinations: []string{"learning"}},
+			{Servers: []string{"office"}, Destinations: []string{"announcements"}},
+			{Resolutions: []string{"2160p"}, Title: "(?i)^star wars", Destinations: []string{"home-theater", "learning"}},
+		},
+		Routing: RoutingCfg{
+			Default: []string{"learning"},
+			Ops:     []string{"announcements"},
+		},
+		Outbox: OutboxCfg{
+			MinBackoff: Duration{30 * time.Second},
+			MaxBackoff: Duration{30 * time.Minute},
+			Expire:     Duration{6 * time.Hour},
 		},
-		PlexURL: `https://apps.plex.tv/`,
 		Plex: map[string]PlexLibCfg{
 			"movies": PlexLibCfg{
 				Root:    `/path/to/movie`,
 				Section: 1,
+				Templates: map[string]TemplateCfg{
+					"removed": TemplateCfg{Text: "{{.Movie.Title}} is gone"},
+				},
+				ScanTimeout: Duration{2 * time.Hour},
 			},
 			"show": PlexLibCfg{
 				Root:    `/path/to/shows`,
 				Section: 2,
 			},
+			"office": PlexLibCfg{
+				Root:    `/mnt/office/movies`,
+				Section: 4,
+				Server:  "office",
+			},
 		},
 	}
 )
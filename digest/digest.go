@@ -0,0 +1,284 @@
+// Package digest collects the movies announced to destinations that
+// post a single summary per time window instead of a message for
+// every movie, or that hold their messages during quiet hours.
+// Collected movies are kept in a persistent store so that a restart
+// doesn't lose the digest being collected.
+package digest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+)
+
+const (
+	// Hourly posts the digest at the start of every hour
+	Hourly = "hourly"
+	// Daily posts the digest every day at a set time
+	Daily = "daily"
+	// Weekly posts the digest every week on a set day and time
+	Weekly = "weekly"
+
+	// tick is how often Run checks for windows that ended
+	tick = time.Minute
+)
+
+// Store keeps the collected movies, store.Store implements it
+type Store interface {
+	Get(bucket string, key string, value interface{}) (bool, error)
+	Put(bucket string, key string, value interface{}) error
+	Delete(bucket string, key string) error
+	Keys(bucket string) []string
+}
+
+// Window tells when the window collecting movies ends, Next returns
+// the end of the window following t
+type Window interface {
+	Next(t time.Time) time.Time
+}
+
+// Schedule tells when the windows of a digest end
+type Schedule struct {
+	Every string
+	// Hour and Minute are the time of day daily and weekly digests
+	// are posted at
+	Hour   int
+	Minute int
+	// Day is the day weekly digests are posted on
+	Day      time.Weekday
+	Location *time.Location
+}
+
+// ParseSchedule parses how often a digest is posted, hourly, daily or
+// weekly, the time of day written as 15:04 and the day of week. at
+// defaults to midnight and day to monday.
+func ParseSchedule(every string, at string, day string) (Schedule, error) {
+	schedule := Schedule{
+		Every:    strings.ToLower(every),
+		Day:      time.Monday,
+		Location: time.Local,
+	}
+	switch schedule.Every {
+	case Hourly, Daily, Weekly:
+	default:
+		return Schedule{}, fmt.Errorf("unknown digest %q, want hourly, daily or weekly", every)
+	}
+	if len(at) > 0 {
+		clock, err := time.Parse("15:04", at)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid digest time %q, want 15:04", at)
+		}
+		schedule.Hour, schedule.Minute = clock.Hour(), clock.Minute()
+	}
+	if len(day) > 0 {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return Schedule{}, fmt.Errorf("unknown digest day %q", day)
+		}
+		schedule.Day = weekday
+	}
+	return schedule, nil
+}
+
+// weekdays maps the lower case names of days to time.Weekday
+var weekdays = map[string]time.Weekday{}
+
+func init() {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		weekdays[strings.ToLower(day.String())] = day
+		weekdays[strings.ToLower(day.String()[:3])] = day
+	}
+}
+
+// Next returns the end of the window after t
+func (schedule Schedule) Next(t time.Time) time.Time {
+	location := schedule.Location
+	if location == nil {
+		location = time.Local
+	}
+	t = t.In(location)
+	year, month, day := t.Date()
+	var next time.Time
+	switch schedule.Every {
+	case Hourly:
+		return time.Date(year, month, day, t.Hour()+1, 0, 0, 0, location)
+	case Weekly:
+		day += (int(schedule.Day) - int(t.Weekday()) + 7) % 7
+		next = time.Date(year, month, day, schedule.Hour, schedule.Minute, 0, 0, location)
+		if !next.After(t) {
+			next = time.Date(year, month, day+7, schedule.Hour, schedule.Minute, 0, 0, location)
+		}
+	default:
+		next = time.Date(year, month, day, schedule.Hour, schedule.Minute, 0, 0, location)
+		if !next.After(t) {
+			next = time.Date(year, month, day+1, schedule.Hour, schedule.Minute, 0, 0, location)
+		}
+	}
+	return next
+}
+
+// Item is a movie collected for a digest, PlexServer and PlexKey are
+// the ones of the announcement it was collected from
+type Item struct {
+	Server     string
+	Library    string
+	Folder     string
+	Movie      metadata.Movie
+	PlexServer string
+	PlexKey    string
+	Added      time.Time
+}
+
+// Post sends the movies collected for destination during the window
+// ending at end, the movies are collected again when it fails
+type Post func(destination string, end time.Time, items []Item) error
+
+// Collector collects movies for every destination having a window
+// and posts them when the window ends
+type Collector struct {
+	store Store
+	// bucket is the bucket of store holding when the window of every
+	// destination ends, the movies collected for a destination are
+	// kept in the bucket named after it and the destination
+	bucket  string
+	windows map[string]Window
+	post    Post
+	now     func() time.Time
+	mutex   sync.Mutex
+}
+
+// New creates a Collector for destinations by name, keeping what it
+// collects in bucket of store
+func New(store Store, bucket string, windows map[string]Window, post Post) *Collector {
+	return &Collector{
+		store:   store,
+		bucket:  bucket,
+		windows: windows,
+		post:    post,
+		now:     time.Now,
+	}
+}
+
+// Collects tells whether destination has a window
+func (collector *Collector) Collects(destination string) bool {
+	_, ok := collector.windows[destination]
+	return ok
+}
+
+// Add collects the movie of announcement for destination and tells
+// whether it was taken. New movies are added, upgraded ones update
+// the movie already collected and removed ones drop it, upgraded and
+// removed movies that were not collected are not taken.
+func (collector *Collector) Add(destination string, announcement notify.Announcement) (bool, error) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	bucket := collector.items(destination)
+	key := announcement.Key()
+	if announcement.Kind == notify.KindNew {
+		item := Item{Server: announcement.Server, Library: announcement.Library, Folder: announcement.Folder, Movie: announcement.Movie,
+			PlexServer: announcement.PlexServer, PlexKey: announcement.PlexKey, Added: collector.now()}
+		return true, collector.store.Put(bucket, key, item)
+	}
+	var item Item
+	found, err := collector.store.Get(bucket, key, &item)
+	if err != nil || !found {
+		return false, err
+	}
+	switch announcement.Kind {
+	case notify.KindUpgraded:
+		item.Movie = announcement.Movie
+		item.PlexServer, item.PlexKey = announcement.PlexServer, announcement.PlexKey
+		return true, collector.store.Put(bucket, key, item)
+	case notify.KindRemoved:
+		return true, collector.store.Delete(bucket, key)
+	}
+	return false, nil
+}
+
+// Run posts the digests as their window ends until ctx is cancelled,
+// the windows that ended while the program wasn't running are posted
+// right away
+func (collector *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		collector.Flush()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush posts the digest of every destination whose window ended, an
+// empty digest posts nothing
+func (collector *Collector) Flush() {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	now := collector.now()
+	for destination, window := range collector.windows {
+		var end time.Time
+		found, err := collector.store.Get(collector.bucket, destination, &end)
+		if err != nil {
+			log.Println("error:", err)
+			continue
+		}
+		if !found {
+			end = window.Next(now)
+			if err := collector.store.Put(collector.bucket, destination, end); err != nil {
+				log.Println("error:", err)
+			}
+			continue
+		}
+		if now.Before(end) {
+			continue
+		}
+		items := collector.collected(destination)
+		if len(items) > 0 {
+			if err := collector.post(destination, end, items); err != nil {
+				log.Printf("error: posting %s to %s: %s\n", collector.bucket, destination, err)
+				continue
+			}
+			bucket := collector.items(destination)
+			for _, key := range collector.store.Keys(bucket) {
+				collector.store.Delete(bucket, key)
+			}
+		}
+		if err := collector.store.Put(collector.bucket, destination, window.Next(now)); err != nil {
+			log.Println("error:", err)
+		}
+	}
+}
+
+// items returns the bucket holding the movies collected for
+// destination
+func (collector *Collector) items(destination string) string {
+	return collector.bucket + "/" + destination
+}
+
+// collected returns the movies collected for destination in the
+// order they were added
+func (collector *Collector) collected(destination string) []Item {
+	bucket := collector.items(destination)
+	var items []Item
+	for _, key := range collector.store.Keys(bucket) {
+		var item Item
+		if found, err := collector.store.Get(bucket, key, &item); err != nil {
+			log.Printf("error: reading %s from %s: %s\n", key, collector.bucket, err)
+		} else if found {
+			items = append(items, item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Added.Before(items[j].Added)
+	})
+	return items
+}
@@ -0,0 +1,195 @@
+package digest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+var scheduleCases = []struct {
+	name  string
+	every string
+	at    string
+	day   string
+	now   time.Time
+	next  time.Time
+	err   string
+}{
+	{
+		name:  "case hourly",
+		every: "hourly",
+		now:   time.Date(2019, 5, 1, 20, 15, 0, 0, time.UTC),
+		next:  time.Date(2019, 5, 1, 21, 0, 0, 0, time.UTC),
+	},
+	{
+		name:  "case daily later today",
+		every: "daily",
+		at:    "21:30",
+		now:   time.Date(2019, 5, 1, 20, 15, 0, 0, time.UTC),
+		next:  time.Date(2019, 5, 1, 21, 30, 0, 0, time.UTC),
+	},
+	{
+		name:  "case daily tomorrow",
+		every: "Daily",
+		at:    "09:00",
+		now:   time.Date(2019, 5, 31, 9, 0, 0, 0, time.UTC),
+		next:  time.Date(2019, 6, 1, 9, 0, 0, 0, time.UTC),
+	},
+	{
+		name:  "case weekly defaults to monday midnight",
+		every: "weekly",
+		now:   time.Date(2019, 5, 1, 20, 15, 0, 0, time.UTC),
+		next:  time.Date(2019, 5, 6, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		name:  "case weekly same day later",
+		every: "weekly",
+		at:    "18:00",
+		day:   "wed",
+		now:   time.Date(2019, 5, 1, 9, 0, 0, 0, time.UTC),
+		next:  time.Date(2019, 5, 1, 18, 0, 0, 0, time.UTC),
+	},
+	{
+		name:  "case weekly same day passed",
+		every: "weekly",
+		at:    "18:00",
+		day:   "Wednesday",
+		now:   time.Date(2019, 5, 1, 19, 0, 0, 0, time.UTC),
+		next:  time.Date(2019, 5, 8, 18, 0, 0, 0, time.UTC),
+	},
+	{
+		name:  "case unknown window",
+		every: "monthly",
+		err:   `unknown digest "monthly", want hourly, daily or weekly`,
+	},
+	{
+		name:  "case invalid time",
+		every: "daily",
+		at:    "9am",
+		err:   `invalid digest time "9am", want 15:04`,
+	},
+	{
+		name:  "case unknown day",
+		every: "weekly",
+		day:   "someday",
+		err:   `unknown digest day "someday"`,
+	},
+}
+
+func TestSchedule_Next(t *testing.T) {
+	for _, tt := range scheduleCases {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.every, tt.at, tt.day)
+			if err != nil || len(tt.err) > 0 {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("ParseSchedule() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			schedule.Location = time.UTC
+			if got := schedule.Next(tt.now); !got.Equal(tt.next) {
+				t.Errorf("Next() = %v, want %v", got, tt.next)
+			}
+		})
+	}
+}
+
+func announcement(kind notify.Kind, title string, synopsis string) notify.Announcement {
+	return notify.Announcement{
+		Kind:    kind,
+		Library: "movies",
+		Movie:   metadata.Movie{Title: title, Year: "2019", Synopsis: synopsis},
+	}
+}
+
+func TestCollector_Flush(t *testing.T) {
+	now := time.Date(2019, 5, 1, 20, 15, 0, 0, time.UTC)
+	var posted [][]string
+	var failure error
+	post := func(destination string, end time.Time, items []Item) error {
+		if failure != nil {
+			return failure
+		}
+		var titles []string
+		for _, item := range items {
+			titles = append(titles, destination+": "+item.Movie.Title+" "+item.Movie.Synopsis)
+		}
+		posted = append(posted, titles)
+		return nil
+	}
+	schedule, _ := ParseSchedule("hourly", "", "")
+	collector := New(store.Memory(), "digest", map[string]Window{"summary": schedule}, post)
+	collector.now = func() time.Time { return now }
+
+	if collector.Collects("general") || !collector.Collects("summary") {
+		t.Error("Collects() doesn't match the schedules")
+	}
+	collector.Flush()
+	adds := []struct {
+		kind  notify.Kind
+		title string
+		taken bool
+	}{
+		{notify.KindNew, "b", true},
+		{notify.KindNew, "a", true},
+		{notify.KindNew, "c", true},
+		{notify.KindUpgraded, "a", true},
+		{notify.KindUpgraded, "d", false},
+		{notify.KindRemoved, "c", true},
+		{notify.KindRemoved, "e", false},
+	}
+	for _, add := range adds {
+		synopsis := ""
+		if add.kind == notify.KindUpgraded {
+			synopsis = "4k"
+		}
+		taken, err := collector.Add("summary", announcement(add.kind, add.title, synopsis))
+		if taken != add.taken || err != nil {
+			t.Errorf("Add(%s %s) = %v, %v, want %v", add.kind, add.title, taken, err, add.taken)
+		}
+		now = now.Add(time.Second)
+	}
+	collector.Flush()
+	if len(posted) != 0 {
+		t.Fatalf("Flush() posted %v before window ended", posted)
+	}
+
+	now = time.Date(2019, 5, 1, 21, 0, 0, 0, time.UTC)
+	failure = fmt.Errorf("rendering failed")
+	collector.Flush()
+	failure = nil
+	collector.Flush()
+	want := [][]string{{"summary: b ", "summary: a 4k"}}
+	if !reflect.DeepEqual(posted, want) {
+		t.Fatalf("Flush() posted %q, want %q", posted, want)
+	}
+
+	now = time.Date(2019, 5, 1, 22, 0, 0, 0, time.UTC)
+	collector.Flush()
+	if !reflect.DeepEqual(posted, want) {
+		t.Errorf("Flush() posted %q for an empty digest", posted[len(posted)-1])
+	}
+}
+
+func TestCollector_AddPlexKey(t *testing.T) {
+	collector := New(store.Memory(), "digest", nil, nil)
+	added := announcement(notify.KindNew, "a", "")
+	added.Server, added.PlexServer, added.PlexKey = "office", "abc", "1"
+	upgraded := announcement(notify.KindUpgraded, "a", "4k")
+	upgraded.Server, upgraded.PlexServer, upgraded.PlexKey = "office", "abc", "2"
+	collector.Add("summary", added)
+	collector.Add("summary", upgraded)
+
+	var item Item
+	if found, err := collector.store.Get(collector.items("summary"), upgraded.Key(), &item); !found || err != nil {
+		t.Fatalf("Get() = %v, %v, want the collected movie", found, err)
+	}
+	if item.Server != "office" || item.PlexServer != "abc" || item.PlexKey != "2" {
+		t.Errorf("Add() kept server %q, Plex server %q and key %q, want office, abc and 2", item.Server, item.PlexServer, item.PlexKey)
+	}
+}
@@ -0,0 +1,73 @@
+package digest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is the time of every day during which messages are held
+// until it ends, it can span midnight such as 22:00-07:00
+type QuietHours struct {
+	// Start and End are minutes since midnight
+	Start    int
+	End      int
+	Location *time.Location
+}
+
+// ParseQuietHours parses quiet hours written as 22:00-07:00 in the
+// time zone of location
+func ParseQuietHours(hours string, location *time.Location) (QuietHours, error) {
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q, want 22:00-07:00", hours)
+	}
+	quiet := QuietHours{Location: location}
+	for i, part := range parts {
+		clock, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return QuietHours{}, fmt.Errorf("invalid quiet hours %q, want 22:00-07:00", hours)
+		}
+		minutes := clock.Hour()*60 + clock.Minute()
+		if i == 0 {
+			quiet.Start = minutes
+		} else {
+			quiet.End = minutes
+		}
+	}
+	if quiet.Start == quiet.End {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q, start and end are the same", hours)
+	}
+	return quiet, nil
+}
+
+// Contains tells whether t is within quiet hours
+func (quiet QuietHours) Contains(t time.Time) bool {
+	t = t.In(quiet.location())
+	minutes := t.Hour()*60 + t.Minute()
+	if quiet.Start < quiet.End {
+		return minutes >= quiet.Start && minutes < quiet.End
+	}
+	return minutes >= quiet.Start || minutes < quiet.End
+}
+
+// Next returns the end of quiet hours following t
+func (quiet QuietHours) Next(t time.Time) time.Time {
+	location := quiet.location()
+	t = t.In(location)
+	year, month, day := t.Date()
+	end := time.Date(year, month, day, quiet.End/60, quiet.End%60, 0, 0, location)
+	if !end.After(t) {
+		end = time.Date(year, month, day+1, quiet.End/60, quiet.End%60, 0, 0, location)
+	}
+	return end
+}
+
+// location returns the time zone of quiet hours, local time when
+// there is none
+func (quiet QuietHours) location() *time.Location {
+	if quiet.Location == nil {
+		return time.Local
+	}
+	return quiet.Location
+}
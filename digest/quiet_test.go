@@ -0,0 +1,81 @@
+package digest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHours(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	cases := []struct {
+		name     string
+		hours    string
+		now      time.Time
+		contains bool
+		next     time.Time
+		err      string
+	}{
+		{
+			name:     "case spanning midnight before midnight",
+			hours:    "22:00-07:00",
+			now:      time.Date(2019, 5, 1, 23, 30, 0, 0, jakarta),
+			contains: true,
+			next:     time.Date(2019, 5, 2, 7, 0, 0, 0, jakarta),
+		},
+		{
+			name:     "case spanning midnight after midnight",
+			hours:    "22:00-07:00",
+			now:      time.Date(2019, 5, 2, 3, 0, 0, 0, jakarta),
+			contains: true,
+			next:     time.Date(2019, 5, 2, 7, 0, 0, 0, jakarta),
+		},
+		{
+			name:     "case spanning midnight outside",
+			hours:    "22:00-07:00",
+			now:      time.Date(2019, 5, 2, 7, 0, 0, 0, jakarta),
+			contains: false,
+			next:     time.Date(2019, 5, 3, 7, 0, 0, 0, jakarta),
+		},
+		{
+			name:     "case time zone of quiet hours",
+			hours:    "22:00-07:00",
+			now:      time.Date(2019, 5, 1, 20, 0, 0, 0, time.UTC),
+			contains: true,
+			next:     time.Date(2019, 5, 2, 7, 0, 0, 0, jakarta),
+		},
+		{
+			name:     "case within a day",
+			hours:    "13:00-14:30",
+			now:      time.Date(2019, 5, 1, 14, 29, 0, 0, jakarta),
+			contains: true,
+			next:     time.Date(2019, 5, 1, 14, 30, 0, 0, jakarta),
+		},
+		{
+			name:  "case invalid",
+			hours: "22-7",
+			err:   `invalid quiet hours "22-7", want 22:00-07:00`,
+		},
+		{
+			name:  "case empty window",
+			hours: "22:00-22:00",
+			err:   `invalid quiet hours "22:00-22:00", start and end are the same`,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			quiet, err := ParseQuietHours(tt.hours, jakarta)
+			if err != nil || len(tt.err) > 0 {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("ParseQuietHours() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if got := quiet.Contains(tt.now); got != tt.contains {
+				t.Errorf("Contains() = %v, want %v", got, tt.contains)
+			}
+			if got := quiet.Next(tt.now); !got.Equal(tt.next) {
+				t.Errorf("Next() = %v, want %v", got, tt.next)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+// Package imdb imports the ratings published in IMDb dataset dumps
+// (title.ratings.tsv.gz and title.basics.tsv.gz) into a local store
+// indexed by IMDb id, and provides ratings from it without calling
+// any online API.
+package imdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+const (
+	// magic identifies the store file format
+	magic = "PGSIMDB1"
+	// headerSize is the size of magic followed by the record count
+	headerSize = len(magic) + 4
+	// recordSize is the size of a single record in the store file,
+	// id uint32, rating uint16, votes uint32 and year uint16
+	recordSize = 12
+	// null is the value IMDb dataset uses for missing fields
+	null = "\\N"
+)
+
+var (
+	// movieTypes are the title types kept in the store when
+	// title.basics.tsv.gz is imported along with the ratings
+	movieTypes = map[string]bool{
+		"movie":   true,
+		"tvMovie": true,
+		"video":   true,
+	}
+)
+
+// Rating represent the rating of a title stored in the store
+type Rating struct {
+	ID      string
+	Average float64
+	Votes   int
+	Year    int
+}
+
+// record represent a rating as it is kept in the store file
+type record struct {
+	id     uint32
+	rating uint16
+	votes  uint32
+	year   uint16
+}
+
+// Store represent the local rating store file, it is safe for
+// concurrent use and can be refreshed while it is being used.
+type Store struct {
+	// Path is the location of the store file
+	Path string
+	// RatingsPath is the location of title.ratings.tsv.gz
+	RatingsPath string
+	// BasicsPath is the location of title.basics.tsv.gz, it is optional
+	// and restricts the store to movies when it is set.
+	BasicsPath string
+
+	mutex sync.RWMutex
+	file  *os.File
+	count int
+}
+
+// New creates new instance of Store kept at path and
+// imported from the given dataset files
+func New(path string, ratingsPath string, basicsPath string) *Store {
+	return &Store{
+		Path:        path,
+		RatingsPath: ratingsPath,
+		BasicsPath:  basicsPath,
+	}
+}
+
+// Name identifies the store as metadata provider
+func (store *Store) Name() string {
+	return "imdb"
+}
+
+// Lookup implements metadata.Provider by returning the IMDb rating of the
+// movie, it needs the IMDb id found by a provider asked before.
+func (store *Store) Lookup(ctx context.Context, query metadata.Query) (*metadata.Movie, error) {
+	if len(query.IMDbID) == 0 {
+		return nil, fmt.Errorf("IMDb id of %s (%s) is unknown", query.Title, query.Year)
+	}
+	rating, err := store.Get(query.IMDbID)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata.Movie{
+		IMDbID: rating.ID,
+		Ratings: []metadata.Rating{{
+			Source: "IMDb",
+			Value:  fmt.Sprintf("%.1f/10", rating.Average),
+			Votes:  rating.Votes,
+		}},
+	}, nil
+}
+
+// Get returns the rating of title with the given IMDb id
+func (store *Store) Get(id string) (*Rating, error) {
+	key, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if store.file == nil {
+		return nil, fmt.Errorf("IMDb store %s is not open", store.Path)
+	}
+	buf := make([]byte, recordSize)
+	var readErr error
+	index := sort.Search(store.count, func(i int) bool {
+		if _, err := store.file.ReadAt(buf, int64(headerSize+i*recordSize)); err != nil {
+			readErr = err
+			return true
+		}
+		return binary.BigEndian.Uint32(buf) >= key
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	if index == store.count {
+		return nil, fmt.Errorf("Couldn't find %s in IMDb store", id)
+	}
+	if _, err := store.file.ReadAt(buf, int64(headerSize+index*recordSize)); err != nil {
+		return nil, err
+	}
+	rec := decode(buf)
+	if rec.id != key {
+		return nil, fmt.Errorf("Couldn't find %s in IMDb store", id)
+	}
+	return &Rating{
+		ID:      id,
+		Average: float64(rec.rating) / 10,
+		Votes:   int(rec.votes),
+		Year:    int(rec.year),
+	}, nil
+}
+
+// Refresh imports the dataset files again when they are newer than
+// the store file, then opens the store file when it is not open yet.
+// A store file imported again replaces the open one, lookups keep
+// reading the previous one until then.
+func (store *Store) Refresh() error {
+	imported := false
+	if store.stale() {
+		if err := Import(store.RatingsPath, store.BasicsPath, store.Path); err != nil {
+			return err
+		}
+		imported = true
+	}
+	store.mutex.RLock()
+	opened := store.file != nil
+	store.mutex.RUnlock()
+	if opened && !imported {
+		return nil
+	}
+	file, count, err := open(store.Path)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	previous := store.file
+	store.file = file
+	store.count = count
+	if previous != nil {
+		return previous.Close()
+	}
+	return nil
+}
+
+// Watch refreshes the store every interval until ctx is done,
+// a zero interval disables refreshing.
+func (store *Store) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.Refresh(); err != nil {
+				log.Printf("error: refreshing IMDb store: %s\n", err)
+			}
+		}
+	}
+}
+
+// Close closes the store file
+func (store *Store) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	store.count = 0
+	return err
+}
+
+// stale tells whether the store file is missing or older than
+// one of the dataset files
+func (store *Store) stale() bool {
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		return true
+	}
+	for _, source := range []string{store.RatingsPath, store.BasicsPath} {
+		if len(source) == 0 {
+			continue
+		}
+		if src, err := os.Stat(source); err == nil && src.ModTime().After(info.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Import reads title.ratings.tsv.gz at ratingsPath and writes the store
+// file at path. When basicsPath is set, title.basics.tsv.gz is read too
+// and only movies are kept. The store file is replaced atomically.
+func Import(ratingsPath string, basicsPath string, path string) error {
+	records := []record{}
+	index := map[uint32]int{}
+	err := readTSV(ratingsPath, func(fields []string) error {
+		if len(fields) < 3 {
+			return nil
+		}
+		id, err := parseID(fields[0])
+		if err != nil {
+			return nil
+		}
+		average, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil
+		}
+		votes, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil
+		}
+		index[id] = len(records)
+		records = append(records, record{
+			id:     id,
+			rating: uint16(average*10 + 0.5),
+			votes:  uint32(votes),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(basicsPath) > 0 {
+		keep := make([]bool, len(records))
+		err := readTSV(basicsPath, func(fields []string) error {
+			if len(fields) < 6 {
+				return nil
+			}
+			id, err := parseID(fields[0])
+			if err != nil {
+				return nil
+			}
+			i, ok := index[id]
+			if !ok || !movieTypes[fields[1]] {
+				return nil
+			}
+			keep[i] = true
+			if year, err := strconv.Atoi(fields[5]); err == nil && fields[5] != null {
+				records[i].year = uint16(year)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		movies := records[:0]
+		for i, rec := range records {
+			if keep[i] {
+				movies = append(movies, rec)
+			}
+		}
+		records = movies
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].id < records[j].id
+	})
+	return write(path, records)
+}
+
+// readTSV calls handle with the fields of every line of the gzipped
+// tab separated file at path, except its header line
+func readTSV(path string, handle func(fields []string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	defer reader.Close()
+	lines := bufio.NewReaderSize(reader, 64*1024)
+	header := true
+	for {
+		line, err := lines.ReadString('\n')
+		if len(line) > 0 && !header {
+			if err := handle(strings.Split(strings.TrimRight(line, "\r\n"), "\t")); err != nil {
+				return err
+			}
+		}
+		header = false
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+}
+
+// write stores records in a temporary file then renames it to path
+func write(path string, records []record) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[len(magic):], uint32(len(records)))
+	writer.Write(header)
+	buf := make([]byte, recordSize)
+	for _, rec := range records {
+		encode(buf, rec)
+		writer.Write(buf)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// open opens the store file at path and returns its record count
+func open(path string) (*os.File, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, header); err != nil || string(header[:len(magic)]) != magic {
+		file.Close()
+		return nil, 0, fmt.Errorf("%s is not an IMDb store file", path)
+	}
+	return file, int(binary.BigEndian.Uint32(header[len(magic):])), nil
+}
+
+// encode writes rec into buf
+func encode(buf []byte, rec record) {
+	binary.BigEndian.PutUint32(buf[0:], rec.id)
+	binary.BigEndian.PutUint16(buf[4:], rec.rating)
+	binary.BigEndian.PutUint32(buf[6:], rec.votes)
+	binary.BigEndian.PutUint16(buf[10:], rec.year)
+}
+
+// decode reads a record from buf
+func decode(buf []byte) record {
+	return record{
+		id:     binary.BigEndian.Uint32(buf[0:]),
+		rating: binary.BigEndian.Uint16(buf[4:]),
+		votes:  binary.BigEndian.Uint32(buf[6:]),
+		year:   binary.BigEndian.Uint16(buf[10:]),
+	}
+}
+
+// parseID converts IMDb id such as tt0111161 into its number
+func parseID(id string) (uint32, error) {
+	if !strings.HasPrefix(id, "tt") {
+		return 0, fmt.Errorf("invalid IMDb id %q", id)
+	}
+	number, err := strconv.ParseUint(id[2:], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid IMDb id %q", id)
+	}
+	return uint32(number), nil
+}
@@ -0,0 +1,186 @@
+package imdb
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+var (
+	ratingsTSV = "tconst\taverageRating\tnumVotes\n" +
+		"tt0111161\t9.3\t2871412\n" +
+		"tt0000001\t5.7\t2071\n" +
+		"tt0903747\t9.5\t2100000\n"
+	basicsTSV = "tconst\ttitleType\tprimaryTitle\toriginalTitle\tisAdult\tstartYear\tendYear\truntimeMinutes\tgenres\n" +
+		"tt0000001\tshort\tCarmencita\tCarmencita\t0\t1894\t\\N\t1\tDocumentary,Short\n" +
+		"tt0111161\tmovie\tThe \"Shawshank\" Redemption\tThe Shawshank Redemption\t0\t1994\t\\N\t142\tDrama\n" +
+		"tt0903747\ttvSeries\tBreaking Bad\tBreaking Bad\t0\t2008\t2013\t49\tCrime,Drama,Thriller\n"
+)
+
+func writeGzip(t *testing.T, path string, content string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := gzip.NewWriter(file)
+	writer.Write([]byte(content))
+	writer.Close()
+}
+
+func setup(t *testing.T, basics bool) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "imdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ratingsPath := filepath.Join(dir, "title.ratings.tsv.gz")
+	writeGzip(t, ratingsPath, ratingsTSV)
+	basicsPath := ""
+	if basics {
+		basicsPath = filepath.Join(dir, "title.basics.tsv.gz")
+		writeGzip(t, basicsPath, basicsTSV)
+	}
+	store := New(filepath.Join(dir, "imdb.db"), ratingsPath, basicsPath)
+	if err := store.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+var cases = []struct {
+	name         string
+	basics       bool
+	id           string
+	result       *Rating
+	errorMessage string
+}{
+	{
+		name:   "case rating imported from ratings only",
+		basics: false,
+		id:     "tt0903747",
+		result: &Rating{ID: "tt0903747", Average: 9.5, Votes: 2100000},
+	},
+	{
+		name:   "case movie rating with year from basics",
+		basics: true,
+		id:     "tt0111161",
+		result: &Rating{ID: "tt0111161", Average: 9.3, Votes: 2871412, Year: 1994},
+	},
+	{
+		name:         "case series dropped when basics is imported",
+		basics:       true,
+		id:           "tt0903747",
+		errorMessage: "Couldn't find tt0903747",
+	},
+	{
+		name:         "case unknown id",
+		basics:       false,
+		id:           "tt9999999",
+		errorMessage: "Couldn't find tt9999999",
+	},
+	{
+		name:         "case invalid id",
+		basics:       false,
+		id:           "nm0000001",
+		errorMessage: "invalid IMDb id",
+	},
+}
+
+func TestStore_Get(t *testing.T) {
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			store, cleanup := setup(t, tt.basics)
+			defer cleanup()
+			rating, err := store.Get(tt.id)
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in Get() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if !reflect.DeepEqual(rating, tt.result) {
+				t.Errorf("Get() = %v, want %v", rating, tt.result)
+			}
+		})
+	}
+}
+
+func TestStore_Lookup(t *testing.T) {
+	store, cleanup := setup(t, true)
+	defer cleanup()
+	movie, err := store.Lookup(context.Background(), metadata.Query{Title: "The Shawshank Redemption", IMDbID: "tt0111161"})
+	if err != nil {
+		t.Fatalf("Lookup() unexpected error %v", err)
+	}
+	want := []metadata.Rating{{Source: "IMDb", Value: "9.3/10", Votes: 2871412}}
+	if !reflect.DeepEqual(movie.Ratings, want) {
+		t.Errorf("Lookup() ratings = %v, want %v", movie.Ratings, want)
+	}
+	if _, err := store.Lookup(context.Background(), metadata.Query{Title: "The Shawshank Redemption"}); err == nil {
+		t.Error("Lookup() expected an error without IMDb id")
+	}
+}
+
+func TestStore_Refresh(t *testing.T) {
+	store, cleanup := setup(t, false)
+	defer cleanup()
+	if _, err := store.Get("tt0000002"); err == nil {
+		t.Fatal("Get() expected an error before refresh")
+	}
+	writeGzip(t, store.RatingsPath, ratingsTSV+"tt0000002\t6.1\t280\n")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(store.RatingsPath, later, later)
+	if err := store.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	rating, err := store.Get("tt0000002")
+	if err != nil {
+		t.Fatalf("Get() unexpected error after refresh %v", err)
+	}
+	if rating.Votes != 280 {
+		t.Errorf("Get() votes = %v, want 280", rating.Votes)
+	}
+}
+
+func TestStore_RefreshConcurrentGet(t *testing.T) {
+	store, cleanup := setup(t, false)
+	defer cleanup()
+	done := make(chan struct{})
+	failed := make(chan error, 1)
+	go func() {
+		defer close(failed)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := store.Get("tt0000001"); err != nil {
+				failed <- err
+				return
+			}
+		}
+	}()
+	for i := 1; i <= 5; i++ {
+		later := time.Now().Add(time.Duration(i) * time.Minute)
+		os.Chtimes(store.RatingsPath, later, later)
+		if err := store.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	if err := <-failed; err != nil {
+		t.Errorf("Get() unexpected error during refresh %v", err)
+	}
+}
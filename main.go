@@ -1,55 +1,324 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/ashwanthkumar/slack-go-webhook"
 	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/digest"
+	"github.com/rimaulana/plexgoslack/imdb"
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/nfo"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/omdb"
+	"github.com/rimaulana/plexgoslack/outbox"
+	"github.com/rimaulana/plexgoslack/route"
+	"github.com/rimaulana/plexgoslack/store"
 	"github.com/rimaulana/plexgoslack/tmdb"
+	"github.com/rimaulana/plexgoslack/transport"
+)
+
+const (
+	// lookupTimeout is the deadline given to the metadata lookup of
+	// every detected movie
+	lookupTimeout = time.Second * 30
+	// shutdownGrace is how long shutdown waits for the work under way
+	// before cancelling it, and again for the cancelled work to end
+	shutdownGrace = time.Second * 30
+	// pruneEvery is how often the expired state is forgotten
+	pruneEvery = time.Hour * 24
 )
 
 var (
-	tmdbConn   *tmdb.TMDb
+	provider   metadata.Provider
 	conf       *config.Config
 	configPath string
+	// templates holds the message templates of every library by
+	// event type, the global ones are kept under empty library name
+	templates map[string]map[notify.Kind]*notify.Template
+	// destinations are the places announcements are sent to by name
+	destinations map[string]config.DestinationCfg
+	// deliveries keeps the messages until their destination accepts them
+	deliveries *outbox.Outbox
+	// digests collects the movies of destinations posting digests
+	digests *digest.Collector
+	// quietHours are the quiet hours of destinations by name, their
+	// messages are held until quiet hours end
+	quietHours map[string]digest.QuietHours
+	// held collects the new movies of destinations merging the
+	// messages held during quiet hours
+	held *digest.Collector
+	// router picks the destinations of every announcement
+	router *route.Router
+	// watchEvery is how often Watcher reads the library root
+	watchEvery = time.Second * 5
+	// watchRetry is how long Watcher waits to read an unreadable root again
+	watchRetry = time.Minute
+	// folderRegex matches movie folder named "Title (Year)"
+	folderRegex = regexp.MustCompile("((?:[^\\/]+)(?:(?:\\S+\\s+)))\\(([0-9]{4})\\)\\/?$")
+	// videoExtensions are the extensions of files considered a movie
+	videoExtensions = map[string]bool{
+		".mkv": true, ".mp4": true, ".m4v": true, ".avi": true,
+		".mov": true, ".wmv": true, ".ts": true, ".m2ts": true,
+	}
 )
 
+// work counts the lookups and announcements under way, shutdown waits
+// for them so that detected movies are not lost
+type work struct {
+	mutex   sync.Mutex
+	running int
+	// idle is closed when running drops to zero
+	idle chan struct{}
+}
+
+// inflight is the work under way
+var inflight work
+
+// start records that some work started
+func (w *work) start() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.running++
+}
+
+// finish records that some work ended
+func (w *work) finish() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.running--
+	if w.running == 0 && w.idle != nil {
+		close(w.idle)
+		w.idle = nil
+	}
+}
+
+// wait waits up to timeout for the work under way to end, it tells
+// whether it ended
+func (w *work) wait(timeout time.Duration) bool {
+	w.mutex.Lock()
+	if w.running == 0 {
+		w.mutex.Unlock()
+		return true
+	}
+	if w.idle == nil {
+		w.idle = make(chan struct{})
+	}
+	idle := w.idle
+	w.mutex.Unlock()
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// track runs f in a goroutine shutdown waits for
+func track(f func()) {
+	inflight.start()
+	go func() {
+		defer inflight.finish()
+		f()
+	}()
+}
+
+// pruneState forgets the messages posted and the webhooks received
+// longer than expire ago, once a day until ctx is done
+func pruneState(ctx context.Context, state *store.Store, expire time.Duration) {
+	for ctx.Err() == nil {
+		before := time.Now().Add(-expire)
+		if removed, err := notify.PruneMessages(state, before); err != nil {
+			log.Println("error:", err)
+		} else if removed > 0 {
+			log.Println("info: forgot", removed, "messages posted before", before.Format("2006-01-02"))
+		}
+		if removed, err := router.Prune(before); err != nil {
+			log.Println("error:", err)
+		} else if removed > 0 {
+			log.Println("info: forgot where", removed, "movies announced before", before.Format("2006-01-02"), "were posted")
+		}
+		if webhooks != nil {
+			if removed, err := webhooks.Prune(before); err != nil {
+				log.Println("error:", err)
+			} else if removed > 0 {
+				log.Println("info: forgot", removed, "webhooks received before", before.Format("2006-01-02"))
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(pruneEvery):
+		}
+	}
+}
+
 // PostToSlack documentation
-func PostToSlack(message tmdb.MovieInfo) {
-	text := fmt.Sprintf("New movie is now available on <%sweb/index.html|Plex>", conf.PlexURL)
-	test := fmt.Sprintf("%s (%s)", message.Title, message.Year)
-	head := "Synopsis"
-	atth1 := slack.Attachment{
-		Title:    &test,
-		ImageUrl: &message.Thumbnail,
-	}
-	atth2 := slack.Attachment{
-		Title: &head,
-		Text:  &message.Synopsis,
-	}
-	payload := slack.Payload{
-		Text:        text,
-		Attachments: []slack.Attachment{atth1, atth2},
-	}
-	for _, hook := range conf.Slack.Webhook {
-		err := slack.Send(hook, "", payload)
-		log.Println("Send", message.Title, "info to Slack")
-		if len(err) > 0 {
+func PostToSlack(announcement notify.Announcement) {
+	announcement.PlexURL = webURL(announcement.Server)
+	announcement.Links = links()
+	tmpl := templateFor(announcement.Library, announcement.Kind)
+	routes, err := router.Route(announcement)
+	if err != nil {
+		log.Println("error:", err)
+	}
+	if len(routes) == 0 {
+		log.Println("warning: no destination for", announcement.Movie.Title, announcement.Kind)
+	}
+	for _, name := range routes {
+		// movies left out of the digest, such as the ones posted in an
+		// earlier digest and now removed, are posted on their own
+		if digests.Collects(name) {
+			taken, err := digests.Add(name, announcement)
+			if err != nil {
+				log.Printf("error: %s\n", err)
+			}
+			if taken {
+				log.Println("Collect", announcement.Movie.Title, announcement.Kind, "info in digest of", name)
+				continue
+			}
+		}
+		if quiet, ok := quietHours[name]; ok && held.Collects(name) && quiet.Contains(time.Now()) {
+			taken, err := held.Add(name, announcement)
+			if err != nil {
+				log.Printf("error: %s\n", err)
+			}
+			if taken {
+				log.Println("Hold", announcement.Movie.Title, announcement.Kind, "info to", name, "until quiet hours end")
+				continue
+			}
+		}
+		err := enqueue(name, tmpl, announcement.Key(), announcement)
+		log.Println("Queue", announcement.Movie.Title, announcement.Kind, "info to", name)
+		if err != nil {
 			log.Printf("error: %s\n", err)
 		}
 	}
 }
 
+// PostDigest posts the movies collected for destination during the
+// window ending at end in a single message
+func PostDigest(destination string, end time.Time, items []digest.Item) error {
+	log.Println("Queue digest of", len(items), "movies to", destination)
+	return enqueue(destination, templateFor("", notify.KindDigest), "digest/"+end.Format(time.RFC3339), digestAnnouncement(items))
+}
+
+// PostHeld posts the new movies held during quiet hours of destination
+// ending at end, in a single message when there are several of them
+func PostHeld(destination string, end time.Time, items []digest.Item) error {
+	log.Println("Queue", len(items), "movies held during quiet hours to", destination)
+	if len(items) > 1 {
+		return enqueue(destination, templateFor("", notify.KindDigest), "quiet/"+end.Format(time.RFC3339), digestAnnouncement(items))
+	}
+	announcement := notify.Announcement{
+		Kind:       notify.KindNew,
+		Server:     items[0].Server,
+		Library:    items[0].Library,
+		Folder:     items[0].Folder,
+		Movie:      items[0].Movie,
+		PlexURL:    webURL(items[0].Server),
+		PlexServer: items[0].PlexServer,
+		PlexKey:    items[0].PlexKey,
+		Links:      links(),
+	}
+	return enqueue(destination, templateFor(announcement.Library, notify.KindNew), announcement.Key(), announcement)
+}
+
+// digestAnnouncement returns the announcement listing the movies of
+// items. It names their server and links to its web app when they are
+// all on the same one, it links to the web app shared by their servers
+// otherwise.
+func digestAnnouncement(items []digest.Item) notify.Announcement {
+	announcement := notify.Announcement{
+		Kind: notify.KindDigest,
+	}
+	if len(items) > 0 {
+		announcement.Server, announcement.PlexURL = items[0].Server, webURL(items[0].Server)
+	}
+	for _, item := range items {
+		announcement.Movies = append(announcement.Movies, item.Movie)
+		if item.Server != announcement.Server {
+			announcement.Server = ""
+		}
+		if webURL(item.Server) != announcement.PlexURL {
+			announcement.PlexURL = ""
+		}
+	}
+	return announcement
+}
+
+// links returns the links enabled in config file
+func links() notify.Links {
+	return notify.Links{
+		Trailer: conf.Slack.Links.Trailer,
+		IMDb:    conf.Slack.Links.IMDb,
+		TMDb:    conf.Slack.Links.TMDb,
+	}
+}
+
+// enqueue renders announcement for destination and puts it in the
+// outbox, item identifies what the announcement is about. It is held
+// until the quiet hours of destination end.
+func enqueue(destination string, tmpl *notify.Template, item string, announcement notify.Announcement) error {
+	layout := destinations[destination].Layout
+	if len(layout) == 0 {
+		layout = conf.Slack.Layout
+	}
+	payload, err := notify.Render(layout, tmpl, announcement)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	at := now
+	if quiet, ok := quietHours[destination]; ok && quiet.Contains(now) {
+		at = quiet.Next(now)
+	}
+	created := announcement.Detected
+	if created.IsZero() {
+		created = now
+	}
+	return deliveries.EnqueueAt(notify.Message{
+		Destination: destination,
+		Item:        item,
+		Kind:        announcement.Kind,
+		Payload:     payload,
+		Created:     created,
+	}, at)
+}
+
+// Alert tells the ops destinations of routing section about text, item
+// identifies what went wrong so that an alert waiting for delivery is
+// replaced rather than repeated
+func Alert(item string, text string) {
+	log.Println("warning:", text)
+	for _, name := range conf.Routing.Ops {
+		err := deliveries.Enqueue(notify.Message{
+			Destination: name,
+			Item:        "alert/" + item,
+			Kind:        notify.KindAlert,
+			Payload:     notify.Alert(text),
+			Created:     time.Now(),
+		})
+		if err != nil {
+			log.Println("error:", err)
+		}
+	}
+}
+
 // Diff documentation
 func Diff(a, b []os.FileInfo) []string {
 	mb := map[string]bool{}
@@ -65,77 +334,295 @@ func Diff(a, b []os.FileInfo) []string {
 	return ab
 }
 
+// Modified returns the names of folders found in both a and b
+// whose content changed in between
+func Modified(a, b []os.FileInfo) []string {
+	ma := map[string]os.FileInfo{}
+	for _, x := range a {
+		ma[x.Name()] = x
+	}
+	changed := []string{}
+	for _, x := range b {
+		if old, ok := ma[x.Name()]; ok && x.IsDir() && !x.ModTime().Equal(old.ModTime()) {
+			changed = append(changed, x.Name())
+		}
+	}
+	return changed
+}
+
+// VideoFiles returns the names of the video files in folder
+func VideoFiles(folder string) []string {
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return nil
+	}
+	videos := []string{}
+	for _, file := range files {
+		if !file.IsDir() && videoExtensions[strings.ToLower(filepath.Ext(file.Name()))] {
+			videos = append(videos, file.Name())
+		}
+	}
+	return videos
+}
+
+// ParseFolder extracts movie title and year from the name of its
+// folder, following Plex movie naming standard. The folders path is
+// in are left out of the title.
+func ParseFolder(path string) (string, string, error) {
+	result := folderRegex.FindStringSubmatch(filepath.Base(path))
+	if len(result) != 3 {
+		return "", "", errors.New("Path doesn't match regex")
+	}
+	return strings.TrimSpace(result[1]), strings.TrimSpace(result[2]), nil
+}
+
 // Analyze documentation
-func Analyze(path string) (*tmdb.MovieInfo, error) {
-	regex := regexp.MustCompile("((?:[^\\/]+)(?:(?:\\S+\\s+)))\\(([0-9]{4})\\)\\/?$")
-	result := regex.FindStringSubmatch(path)
-	if len(result) == 3 {
-		res, err := tmdbConn.GetInfo(strings.TrimSpace(result[1]), strings.TrimSpace(result[2]))
-		if err != nil {
-			return nil, err
-		}
-		return res, nil
-	}
-	return nil, errors.New("Path doesn't match regex")
-}
-
-// UpdateRepo documentation
-func UpdateRepo(invoker <-chan int) {
-	DataMap := make(map[int]time.Time)
-	log.Println("Updater daemon status Running")
-	for true {
-		inv := <-invoker
-		log.Println("Updater daemon invoked by watcher")
-		command := fmt.Sprintf("sudo -u plex -E -H \"$LD_LIBRARY_PATH/Plex Media Scanner\" --scan --refresh --section %d", inv)
-		if _, ok := DataMap[inv]; ok {
-			tnow := time.Now()
-			if elapsed := tnow.Sub(DataMap[inv]); elapsed >= 5 {
-				DataMap[inv] = tnow
-				if _, err := exec.Command("/bin/bash", "-c", command).Output(); err != nil {
-					log.Println("Error :", err)
-				}
-			} else {
-				log.Println("Updated:", elapsed, "ago")
-			}
-		} else {
-			DataMap[inv] = time.Now()
-			if _, err := exec.Command("/bin/bash", "-c", command).Output(); err != nil {
-				log.Println("Error :", err)
-			}
+func Analyze(ctx context.Context, path string) (*metadata.Movie, error) {
+	title, year, err := ParseFolder(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	movie, err := provider.Lookup(ctx, metadata.Query{
+		Title: title,
+		Year:  year,
+		Path:  path,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(movie.Resolution) == 0 {
+		movie.Resolution = metadata.DetectResolution(append(VideoFiles(path), filepath.Base(path))...)
+	}
+	return movie, nil
+}
+
+// localArtwork shows the poster, or the fanart, found next to movie
+// when no provider found a poster and the library sets artwork_url
+func localArtwork(lib config.PlexLibCfg, movie *metadata.Movie) {
+	if len(movie.Thumbnail) > 0 || len(lib.ArtworkURL) == 0 {
+		return
+	}
+	for _, file := range []string{movie.Poster, movie.Fanart} {
+		if len(file) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(lib.Root, file)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		for i, part := range parts {
+			parts[i] = url.PathEscape(part)
 		}
+		movie.Thumbnail = strings.TrimSuffix(lib.ArtworkURL, "/") + "/" + strings.Join(parts, "/")
+		return
 	}
 }
 
+// pendingScan is a movie announced once Plex scanned its folder,
+// folder is the one seen by Plex
+type pendingScan struct {
+	folder       string
+	announcement notify.Announcement
+	// scanned is closed once Plex scanned folder
+	scanned <-chan struct{}
+}
+
 // Watcher documentation
-func Watcher(root string, section int, invoker chan<- int) {
+func Watcher(ctx context.Context, library string, lib config.PlexLibCfg, srv *server) {
+	root := lib.Root
 	log.Println("info: monitoring folder", root)
+	// an unreadable root, such as a share not mounted yet, is retried
+	// without stopping the other libraries
 	files, err := ioutil.ReadDir(root)
-	if err != nil {
-		log.Fatal(err)
+	for err != nil {
+		log.Println("error:", err, "retrying in", watchRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetry):
+		}
+		if files, err = ioutil.ReadDir(root); err == nil {
+			log.Println("info: monitoring folder", root)
+		}
+	}
+	// video files of every movie, a movie is upgraded
+	// when its video files are replaced
+	videos := map[string][]string{}
+	for _, file := range files {
+		if file.IsDir() {
+			videos[file.Name()] = VideoFiles(filepath.Join(root, file.Name()))
+		}
 	}
-	for {
+	for ctx.Err() == nil {
+		inflight.start()
 		files2, err := ioutil.ReadDir(root)
 		if err != nil {
+			// an unreadable root would look as if every movie was removed
 			log.Println("error:", err)
+			files2 = files
 		}
-		diff := Diff(files, files2)
-		isNew := false
-		for _, newMovie := range diff {
+		removed := false
+		var scanned []pendingScan
+		for _, newMovie := range Diff(files, files2) {
 			log.Println("info: detected", newMovie)
-			res, err := Analyze(newMovie)
+			detected := time.Now()
+			path := filepath.Join(root, newMovie)
+			videos[newMovie] = VideoFiles(path)
+			res, err := Analyze(ctx, path)
+			if err != nil {
+				log.Println("error:", err)
+			} else {
+				localArtwork(lib, res)
+				scanned = append(scanned, pendingScan{folder: srv.remap.Plex(path), announcement: notify.Announcement{Kind: notify.KindNew, Server: srv.name, Library: library, Folder: newMovie, Movie: *res, Detected: detected}})
+			}
+		}
+		// folders are gone, the movie is only described by folder name
+		for _, oldMovie := range Diff(files2, files) {
+			log.Println("info: removed", oldMovie)
+			delete(videos, oldMovie)
+			if title, year, err := ParseFolder(oldMovie); err == nil {
+				PostToSlack(notify.Announcement{Kind: notify.KindRemoved, Server: srv.name, Library: library, Folder: oldMovie, Movie: metadata.Movie{Title: title, Year: year}, Detected: time.Now()})
+				removed = true
+			}
+		}
+		for _, movie := range Modified(files, files2) {
+			path := filepath.Join(root, movie)
+			current := VideoFiles(path)
+			previous := videos[movie]
+			videos[movie] = current
+			if len(previous) == 0 || len(current) == 0 || reflect.DeepEqual(previous, current) {
+				continue
+			}
+			log.Println("info: upgraded", movie)
+			detected := time.Now()
+			res, err := Analyze(ctx, path)
 			if err != nil {
 				log.Println("error:", err)
 			} else {
-				go PostToSlack(*res)
-				isNew = true
+				localArtwork(lib, res)
+				scanned = append(scanned, pendingScan{folder: srv.remap.Plex(path), announcement: notify.Announcement{Kind: notify.KindUpgraded, Server: srv.name, Library: library, Folder: movie, Movie: *res, Detected: detected}})
 			}
 		}
-		if isNew {
-			invoker <- section
+		// removed folders are gone, Plex notices it scanning the section
+		if removed {
+			srv.scans.Request(ctx, lib.Section, "")
+		}
+		for i, pending := range scanned {
+			scanned[i].scanned = srv.scans.Request(ctx, lib.Section, pending.folder)
+		}
+		// new and upgraded movies are announced once Plex scanned them
+		for _, pending := range scanned {
+			if srv.api == nil {
+				PostToSlack(pending.announcement)
+			} else {
+				pending := pending
+				track(func() {
+					AnnounceIndexed(ctx, srv, lib.Section, pending.folder, pending.scanned, pending.announcement)
+				})
+			}
 		}
 		files = files2
-		time.Sleep(time.Second * 5)
+		inflight.finish()
+		select {
+		case <-ctx.Done():
+		case <-time.After(watchEvery):
+		}
+	}
+}
+
+// newTransport returns the transport of an integration, going through
+// proxy and trusting caBundle when they are set, or else the ones of
+// network section
+func newTransport(cfg *config.Config, proxy string, caBundle string) (*http.Transport, error) {
+	global := transport.Settings{Proxy: cfg.Network.Proxy, CABundle: cfg.Network.CABundle}
+	return transport.New(global.Merge(transport.Settings{Proxy: proxy, CABundle: caBundle}))
+}
+
+// tmdbOptions translates the tmdb section of config file
+// into options of tmdb client
+func tmdbOptions(root *config.Config) ([]tmdb.Option, error) {
+	cfg := root.Tmdb
+	client, err := newTransport(root, cfg.Proxy, cfg.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("tmdb: %s", err)
+	}
+	options := []tmdb.Option{tmdb.WithTransport(client)}
+	if len(cfg.AccessToken) > 0 {
+		options = append(options, tmdb.WithAccessToken(cfg.AccessToken))
+	}
+	if len(cfg.BaseURL) > 0 {
+		options = append(options, tmdb.WithBaseURL(cfg.BaseURL))
+	}
+	if cfg.Timeout.Duration > 0 {
+		options = append(options, tmdb.WithTimeout(cfg.Timeout.Duration))
+	}
+	if len(cfg.UserAgent) > 0 {
+		options = append(options, tmdb.WithUserAgent(cfg.UserAgent))
 	}
+	return options, nil
+}
+
+// omdbOptions translates the omdb section of config file
+// into options of omdb client
+func omdbOptions(root *config.Config) ([]omdb.Option, error) {
+	cfg := root.Omdb
+	client, err := newTransport(root, cfg.Proxy, cfg.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("omdb: %s", err)
+	}
+	options := []omdb.Option{omdb.WithTransport(client)}
+	if len(cfg.BaseURL) > 0 {
+		options = append(options, omdb.WithBaseURL(cfg.BaseURL))
+	}
+	if cfg.Timeout.Duration > 0 {
+		options = append(options, omdb.WithTimeout(cfg.Timeout.Duration))
+	}
+	return options, nil
+}
+
+// newProvider builds the metadata provider chain in the order
+// listed in metadata section of config file, local nfo files
+// followed by tmdb are used when none is listed.
+func newProvider(ctx context.Context, cfg *config.Config) (metadata.Provider, error) {
+	names := cfg.Metadata.Providers
+	if len(names) == 0 {
+		names = []string{"nfo", "tmdb"}
+	}
+	var providers []metadata.Provider
+	for _, name := range names {
+		switch name {
+		case "nfo":
+			providers = append(providers, nfo.New())
+		case "tmdb":
+			options, err := tmdbOptions(cfg)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, tmdb.New(cfg.Tmdb.APIKey, options...))
+		case "omdb":
+			options, err := omdbOptions(cfg)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, omdb.New(cfg.Omdb.APIKey, options...))
+		case "imdb":
+			store := imdb.New(cfg.Imdb.Store, cfg.Imdb.Ratings, cfg.Imdb.Basics)
+			if err := store.Refresh(); err != nil {
+				return nil, fmt.Errorf("IMDb store: %s", err)
+			}
+			go store.Watch(ctx, cfg.Imdb.Refresh.Duration)
+			providers = append(providers, store)
+		default:
+			return nil, fmt.Errorf("unknown metadata provider %q", name)
+		}
+	}
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return metadata.NewChain(providers...), nil
 }
 
 func init() {
@@ -145,6 +632,11 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 	flag.Parse()
 
 	// Load configuration file
@@ -154,14 +646,91 @@ func main() {
 		log.Fatal("Error: ", err)
 	}
 	conf = cfg
+	templates, err = loadTemplates(conf)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	var webhooks []string
+	destinations, webhooks = loadDestinations(conf)
+	router, err = newRouter(conf, destinations, webhooks)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	state, err := store.Open(conf.StateFile)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	router.State, router.Bucket = state, "routes"
+	deliveries, err = newOutbox(conf, destinations, state)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	schedules, err := newSchedules(destinations)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	digests = digest.New(state, "digest", schedules, PostDigest)
+	var merged map[string]digest.Window
+	quietHours, merged, err = newQuietHours(destinations)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	held = digest.New(state, "quiet", merged, PostHeld)
 
-	tmdbConn = tmdb.New(conf.Tmdb.APIKey)
-	invoker := make(chan int, 100)
-	done := make(chan bool)
+	// shutdown waits for the lookups in flight before cancelling ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	provider, err = newProvider(ctx, conf)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	servers, err = newServers(ctx, conf, state)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	waiting = state
+	resumeIndexed(ctx)
+	watched, err := watchedLibraries(ctx, conf)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	receiver, err := newWebhookServer(ctx, conf, state)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
 
-	go UpdateRepo(invoker)
-	for fldr := range conf.Plex {
-		go Watcher(conf.Plex[fldr].Root, conf.Plex[fldr].Section, invoker)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go deliveries.Run(ctx)
+	go digests.Run(ctx)
+	go held.Run(ctx)
+	if conf.StateExpire.Duration > 0 {
+		go pruneState(ctx, state, conf.StateExpire.Duration)
+	}
+	for _, lib := range watched {
+		go Watcher(ctx, lib.name, lib.cfg, lib.server)
+	}
+	for _, srv := range servers {
+		if srv.poller != nil {
+			go srv.poller.Run(ctx, srv.cfg.Poll.Duration)
+		}
+	}
+	if receiver != nil {
+		log.Println("info: receiving Plex webhooks on", receiver.Addr)
+		go func() {
+			if err := receiver.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal("Error: ", err)
+			}
+		}()
+	}
+	sig := <-signals
+	log.Println("info: received", sig, "shutting down")
+	if receiver != nil {
+		receiver.Shutdown(context.Background())
+	}
+	if !inflight.wait(shutdownGrace) {
+		log.Println("warning: cancelling the lookups still running")
 	}
-	<-done
+	cancel()
+	inflight.wait(shutdownGrace)
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/digest"
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/outbox"
+	"github.com/rimaulana/plexgoslack/scanner"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+// providerStub finds every movie it is asked for, adding genres
+type providerStub struct {
+	genres []string
+	err    error
+}
+
+func (p *providerStub) Name() string {
+	return "stub"
+}
+
+func (p *providerStub) Lookup(ctx context.Context, query metadata.Query) (*metadata.Movie, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &metadata.Movie{Title: query.Title, Year: query.Year, TMDbID: query.TMDbID, Genres: p.genres}, nil
+}
+
+// scannerStub records the scans it is asked for
+type scannerStub struct {
+	mutex sync.Mutex
+	scans []string
+}
+
+func (s *scannerStub) Scan(ctx context.Context, section int, folder string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scans = append(s.scans, fmt.Sprintf("%d %s", section, folder))
+	return nil
+}
+
+// setup points the globals announcements go through to cfg, keeping
+// everything in memory. Messages stay in the outbox, see queued.
+func setup(t *testing.T, cfg *config.Config) *store.Store {
+	t.Helper()
+	conf = cfg
+	state := store.Memory()
+	var err error
+	if templates, err = loadTemplates(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var webhooks []string
+	destinations, webhooks = loadDestinations(cfg)
+	if router, err = newRouter(cfg, destinations, webhooks); err != nil {
+		t.Fatal(err)
+	}
+	router.State, router.Bucket = state, "routes"
+	deliveries = outbox.New(state, map[string]notify.Destination{})
+	schedules, err := newSchedules(destinations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests = digest.New(state, "digest", schedules, PostDigest)
+	var merged map[string]digest.Window
+	if quietHours, merged, err = newQuietHours(destinations); err != nil {
+		t.Fatal(err)
+	}
+	held = digest.New(state, "quiet", merged, PostHeld)
+	servers = map[string]*server{defaultServer: {name: defaultServer, webURL: cfg.PlexURL}}
+	waiting = nil
+	return state
+}
+
+// queued returns the destination, event type and item of every
+// message waiting in the outbox
+func queued() []string {
+	var messages []string
+	for _, entry := range deliveries.Pending() {
+		messages = append(messages, fmt.Sprintf("%s %s %s", entry.Message.Destination, entry.Message.Kind, entry.Message.Item))
+	}
+	sort.Strings(messages)
+	return messages
+}
+
+// aroundNow returns quiet hours that started an hour ago
+func aroundNow() string {
+	now := time.Now().UTC()
+	return now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04")
+}
+
+func TestPostToSlack(t *testing.T) {
+	coco := notify.Announcement{Kind: notify.KindNew, Library: "movies", Folder: "Coco (2017)", Movie: metadata.Movie{Title: "Coco", Year: "2017"}}
+	tests := []struct {
+		name         string
+		destination  config.DestinationCfg
+		announcement notify.Announcement
+		queued       []string
+		digest       int
+		held         int
+	}{
+		{
+			name:         "case posted right away",
+			destination:  config.DestinationCfg{Webhook: hook},
+			announcement: coco,
+			queued:       []string{"general new movies/Coco (2017)"},
+		},
+		{
+			name:         "case collected in digest",
+			destination:  config.DestinationCfg{Webhook: hook, Digest: "daily", DigestAt: "20:00"},
+			announcement: coco,
+			digest:       1,
+		},
+		{
+			name:         "case removal of a movie not in digest is posted",
+			destination:  config.DestinationCfg{Webhook: hook, Digest: "daily", DigestAt: "20:00"},
+			announcement: notify.Announcement{Kind: notify.KindRemoved, Library: "movies", Folder: "Heat (1995)", Movie: metadata.Movie{Title: "Heat", Year: "1995"}},
+			queued:       []string{"general removed movies/Heat (1995)"},
+		},
+		{
+			name:         "case held during quiet hours",
+			destination:  config.DestinationCfg{Webhook: hook, QuietHours: aroundNow(), QuietMerge: true, TimeZone: "UTC"},
+			announcement: coco,
+			held:         1,
+		},
+		{
+			name:         "case queued for the end of quiet hours",
+			destination:  config.DestinationCfg{Webhook: hook, QuietHours: aroundNow(), TimeZone: "UTC"},
+			announcement: coco,
+			queued:       []string{"general new movies/Coco (2017)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := setup(t, &config.Config{
+				Destinations: map[string]config.DestinationCfg{"general": tt.destination},
+				Routing:      config.RoutingCfg{Default: []string{"general"}},
+			})
+			PostToSlack(tt.announcement)
+			if got := queued(); !reflect.DeepEqual(got, tt.queued) {
+				t.Errorf("PostToSlack() queued %q, want %q", got, tt.queued)
+			}
+			if got := len(state.Keys("digest/general")); got != tt.digest {
+				t.Errorf("PostToSlack() collected %d movies in digest, want %d", got, tt.digest)
+			}
+			if got := len(state.Keys("quiet/general")); got != tt.held {
+				t.Errorf("PostToSlack() held %d movies, want %d", got, tt.held)
+			}
+		})
+	}
+}
+
+func TestPostToSlack_QuietHoursDelay(t *testing.T) {
+	setup(t, &config.Config{
+		Destinations: map[string]config.DestinationCfg{"general": {Webhook: hook, QuietHours: aroundNow(), TimeZone: "UTC"}},
+		Routing:      config.RoutingCfg{Default: []string{"general"}},
+	})
+	PostToSlack(notify.Announcement{Kind: notify.KindNew, Library: "movies", Movie: metadata.Movie{Title: "Coco", Year: "2017"}})
+	pending := deliveries.Pending()
+	if len(pending) != 1 || !pending[0].Next.After(time.Now().Add(30*time.Minute)) {
+		t.Errorf("Pending() = %+v, want a message due when quiet hours end", pending)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, file := range []string{"Heat (1995)/Heat.avi", "Coco (2017)/Coco.mkv"} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0755)
+		ioutil.WriteFile(filepath.Join(root, file), nil, 0644)
+	}
+	setup(t, &config.Config{
+		Destinations: map[string]config.DestinationCfg{"general": {Webhook: hook}},
+		Routing:      config.RoutingCfg{Default: []string{"general"}},
+	})
+	provider = &providerStub{}
+	scans := &scannerStub{}
+	srv := &server{name: defaultServer, scans: scanner.NewScheduler(scans, 0)}
+	defer func(every time.Duration) { watchEvery = every }(watchEvery)
+	watchEvery = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		Watcher(ctx, "movies", config.PlexLibCfg{Root: root, Section: 1}, srv)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	// let Watcher read the movies already there
+	time.Sleep(100 * time.Millisecond)
+	os.RemoveAll(filepath.Join(root, "Coco (2017)"))
+	os.Rename(filepath.Join(root, "Heat (1995)/Heat.avi"), filepath.Join(root, "Heat (1995)/Heat 2160p.mkv"))
+	os.MkdirAll(filepath.Join(root, "Dune (2021)"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "Dune (2021)/Dune.mkv"), nil, 0644)
+
+	want := []string{"general new movies/Dune (2021)", "general removed movies/Coco (2017)", "general upgraded movies/Heat (1995)"}
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(queued(), want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := queued(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Watcher() queued %q, want %q", got, want)
+	}
+	for _, entry := range deliveries.Pending() {
+		if entry.Message.Kind == notify.KindUpgraded && entry.Message.Payload.Text == "" {
+			t.Errorf("Watcher() queued an empty upgrade message")
+		}
+	}
+}
+
+func TestWatcher_UnreadableRoot(t *testing.T) {
+	setup(t, &config.Config{})
+	defer func(retry time.Duration) { watchRetry = retry }(watchRetry)
+	watchRetry = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// returns once ctx is done instead of exiting
+	Watcher(ctx, "movies", config.PlexLibCfg{Root: "/nonexistent/movies", Section: 1}, &server{})
+}
+
+func TestModified(t *testing.T) {
+	now := time.Now()
+	before := []os.FileInfo{
+		fileInfo{"Heat (1995)", now, true},
+		fileInfo{"Coco (2017)", now, true},
+		fileInfo{"notes.txt", now, false},
+	}
+	after := []os.FileInfo{
+		fileInfo{"Heat (1995)", now.Add(time.Minute), true},
+		fileInfo{"Coco (2017)", now, true},
+		fileInfo{"notes.txt", now.Add(time.Minute), false},
+		fileInfo{"Dune (2021)", now, true},
+	}
+	if got, want := Modified(before, after), []string{"Heat (1995)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Modified() = %v, want %v", got, want)
+	}
+	if got, want := Diff(before, after), []string{"Dune (2021)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+	if got, want := Diff(after, before), []string{}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+}
+
+// fileInfo is an os.FileInfo of the given name and modification time
+type fileInfo struct {
+	name    string
+	modTime time.Time
+	dir     bool
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return 0 }
+func (f fileInfo) Mode() os.FileMode  { return 0755 }
+func (f fileInfo) ModTime() time.Time { return f.modTime }
+func (f fileInfo) IsDir() bool        { return f.dir }
+func (f fileInfo) Sys() interface{}   { return nil }
+
+func TestParseFolder(t *testing.T) {
+	tests := []struct {
+		path  string
+		title string
+		year  string
+		valid bool
+	}{
+		{"/movies/Heat (1995)", "Heat", "1995", true},
+		{"/movies/The Lord of the Rings (2001)/", "The Lord of the Rings", "2001", true},
+		{"/movies/Heat", "", "", false},
+	}
+	for _, tt := range tests {
+		title, year, err := ParseFolder(tt.path)
+		if (err == nil) != tt.valid || title != tt.title || year != tt.year {
+			t.Errorf("ParseFolder(%q) = %q, %q, %v, want %q, %q", tt.path, title, year, err, tt.title, tt.year)
+		}
+	}
+}
+
+func TestLocalArtwork(t *testing.T) {
+	lib := config.PlexLibCfg{Root: "/movies", ArtworkURL: "https://media.example.com/movies/"}
+	tests := []struct {
+		name      string
+		lib       config.PlexLibCfg
+		movie     metadata.Movie
+		thumbnail string
+	}{
+		{
+			name:      "case poster",
+			lib:       lib,
+			movie:     metadata.Movie{Poster: "/movies/Coco (2017)/poster.jpg", Fanart: "/movies/Coco (2017)/fanart.jpg"},
+			thumbnail: "https://media.example.com/movies/Coco%20%282017%29/poster.jpg",
+		},
+		{
+			name:      "case fanart without poster",
+			lib:       lib,
+			movie:     metadata.Movie{Fanart: "/movies/Coco (2017)/fanart.jpg"},
+			thumbnail: "https://media.example.com/movies/Coco%20%282017%29/fanart.jpg",
+		},
+		{
+			name:      "case provider found a poster",
+			lib:       lib,
+			movie:     metadata.Movie{Thumbnail: "https://image.tmdb.org/poster.jpg", Poster: "/movies/Coco (2017)/poster.jpg"},
+			thumbnail: "https://image.tmdb.org/poster.jpg",
+		},
+		{
+			name:  "case library without artwork_url",
+			lib:   config.PlexLibCfg{Root: "/movies"},
+			movie: metadata.Movie{Poster: "/movies/Coco (2017)/poster.jpg"},
+		},
+		{
+			name:  "case artwork outside root",
+			lib:   lib,
+			movie: metadata.Movie{Poster: "/other/Coco (2017)/poster.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := tt.movie
+			localArtwork(tt.lib, &movie)
+			if movie.Thumbnail != tt.thumbnail {
+				t.Errorf("localArtwork() thumbnail = %q, want %q", movie.Thumbnail, tt.thumbnail)
+			}
+		})
+	}
+}
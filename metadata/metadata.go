@@ -0,0 +1,261 @@
+// Package metadata defines the movie information shared by every
+// metadata provider and the interface those providers implement.
+// it also provides a chain provider that combines several providers.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is wrapped by the errors of providers that have nothing
+// about a movie, such as a folder without nfo file. Chain skips them
+// without a warning.
+var ErrNotFound = errors.New("not found")
+
+// Rating represent a score given to a movie by a rating source
+// such as IMDb or Rotten Tomatoes. Votes is zero when the source
+// doesn't tell how many votes the score is based on.
+type Rating struct {
+	Source string
+	Value  string
+	Votes  int
+}
+
+// String formats the rating along with its vote count
+func (rating Rating) String() string {
+	if rating.Votes <= 0 {
+		return rating.Value
+	}
+	return fmt.Sprintf("%s (%s votes)", rating.Value, thousands(rating.Votes))
+}
+
+// thousands formats n with comma as thousands separator
+func thousands(n int) string {
+	digits := strconv.Itoa(n)
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
+
+// Movie represent the structure of the information
+// we want to get from the movie we are searching for.
+// Poster and Fanart are paths of artwork files found
+// next to the movie, Thumbnail and Trailer are URLs.
+// Certification is the age rating such as PG-13 and
+// Resolution is detected from the names of video files.
+type Movie struct {
+	Title         string
+	Year          string
+	Thumbnail     string
+	Synopsis      string
+	Genres        []string
+	Certification string
+	Resolution    string
+	TMDbID        string
+	IMDbID        string
+	Poster        string
+	Fanart        string
+	Trailer       string
+	Ratings       []Rating
+}
+
+// Query holds the information known about a movie
+// before it is looked up in a provider. Path is the
+// folder of the movie, TMDbID and IMDbID are set when
+// an earlier provider already identified the movie and
+// allow the next providers to fetch it exactly.
+type Query struct {
+	Title  string
+	Year   string
+	Path   string
+	TMDbID string
+	IMDbID string
+}
+
+// Provider is implemented by every source of movie information.
+type Provider interface {
+	// Name identifies the provider in logs and config file
+	Name() string
+	// Lookup returns the information of the movie described by query
+	// or an error when the provider couldn't find it.
+	Lookup(ctx context.Context, query Query) (*Movie, error)
+}
+
+// Chain is a Provider that asks each of its providers in order.
+// the first provider that finds the movie decides its information,
+// the following providers only fill in the blanks and add their
+// own ratings. A provider that fails is skipped.
+type Chain struct {
+	Providers []Provider
+}
+
+// NewChain creates new instance of Chain asking providers in
+// the given order.
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{
+		Providers: providers,
+	}
+}
+
+// Name returns the names of the chained providers
+func (chain *Chain) Name() string {
+	names := make([]string, 0, len(chain.Providers))
+	for _, provider := range chain.Providers {
+		names = append(names, provider.Name())
+	}
+	return fmt.Sprintf("chain(%s)", strings.Join(names, ","))
+}
+
+// Lookup asks every provider in the chain for the movie described by
+// query and merges their answers. It only fails when none of the
+// providers could find the movie.
+func (chain *Chain) Lookup(ctx context.Context, query Query) (*Movie, error) {
+	var movie *Movie
+	var errs []string
+	for _, provider := range chain.Providers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res, err := provider.Lookup(ctx, query)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Printf("warning: %s lookup failed: %s\n", provider.Name(), err)
+			}
+			errs = append(errs, fmt.Sprintf("%s: %s", provider.Name(), err))
+			continue
+		}
+		if movie == nil {
+			movie = res
+		} else {
+			movie.Merge(res)
+		}
+		// let the following providers fetch exactly the movie
+		// identified so far instead of searching it again
+		if len(query.TMDbID) == 0 {
+			query.TMDbID = movie.TMDbID
+		}
+		if len(query.IMDbID) == 0 {
+			query.IMDbID = movie.IMDbID
+		}
+	}
+	if movie == nil {
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("no metadata provider configured")
+		}
+		return nil, fmt.Errorf("Couldn't find %s (%s): %s", query.Title, query.Year, strings.Join(errs, "; "))
+	}
+	return movie, nil
+}
+
+// Merge fills the empty fields of movie with the ones from other and
+// adds the ratings of the sources movie doesn't have a rating from.
+func (movie *Movie) Merge(other *Movie) {
+	if len(movie.Title) == 0 {
+		movie.Title = other.Title
+	}
+	if len(movie.Year) == 0 {
+		movie.Year = other.Year
+	}
+	if len(movie.Thumbnail) == 0 {
+		movie.Thumbnail = other.Thumbnail
+	}
+	if len(movie.Synopsis) == 0 {
+		movie.Synopsis = other.Synopsis
+	}
+	if len(movie.Genres) == 0 {
+		movie.Genres = other.Genres
+	}
+	if len(movie.Certification) == 0 {
+		movie.Certification = other.Certification
+	}
+	if len(movie.Resolution) == 0 {
+		movie.Resolution = other.Resolution
+	}
+	if len(movie.TMDbID) == 0 {
+		movie.TMDbID = other.TMDbID
+	}
+	if len(movie.IMDbID) == 0 {
+		movie.IMDbID = other.IMDbID
+	}
+	if len(movie.Poster) == 0 {
+		movie.Poster = other.Poster
+	}
+	if len(movie.Fanart) == 0 {
+		movie.Fanart = other.Fanart
+	}
+	if len(movie.Trailer) == 0 {
+		movie.Trailer = other.Trailer
+	}
+	for _, rating := range other.Ratings {
+		if movie.Rating(rating.Source) == nil {
+			movie.Ratings = append(movie.Ratings, rating)
+		}
+	}
+}
+
+// Rating returns the rating given by source, nil when there is none
+func (movie Movie) Rating(source string) *Rating {
+	for i := range movie.Ratings {
+		if strings.EqualFold(movie.Ratings[i].Source, source) {
+			return &movie.Ratings[i]
+		}
+	}
+	return nil
+}
+
+// resolutions maps the tags found in release names to the
+// resolution they stand for, the highest resolution first
+var resolutions = []struct {
+	resolution string
+	tags       []string
+}{
+	{"2160p", []string{"2160p", "4k", "uhd"}},
+	{"1080p", []string{"1080p", "1080i"}},
+	{"720p", []string{"720p"}},
+	{"576p", []string{"576p"}},
+	{"480p", []string{"480p", "dvdrip", "sdtv"}},
+}
+
+// DetectResolution returns the highest resolution tagged in
+// the given file or folder names, empty when there is none
+func DetectResolution(names ...string) string {
+	for _, r := range resolutions {
+		for _, name := range names {
+			words := strings.FieldsFunc(strings.ToLower(name), func(c rune) bool {
+				return !('a' <= c && c <= 'z' || '0' <= c && c <= '9')
+			})
+			for _, word := range words {
+				for _, tag := range r.tags {
+					if word == tag {
+						return r.resolution
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// IMDbURL returns the address of the movie page on IMDb,
+// empty when the IMDb id of the movie is unknown
+func (movie Movie) IMDbURL() string {
+	if len(movie.IMDbID) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://www.imdb.com/title/%s/", movie.IMDbID)
+}
+
+// TMDbURL returns the address of the movie page on TMDb,
+// empty when the TMDb id of the movie is unknown
+func (movie Movie) TMDbURL() string {
+	if len(movie.TMDbID) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://www.themoviedb.org/movie/%s", movie.TMDbID)
+}
@@ -0,0 +1,165 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type providerStub struct {
+	name    string
+	movie   *Movie
+	err     error
+	calls   int
+	queries []Query
+}
+
+func (p *providerStub) Name() string {
+	return p.name
+}
+
+func (p *providerStub) Lookup(ctx context.Context, query Query) (*Movie, error) {
+	p.calls++
+	p.queries = append(p.queries, query)
+	if p.err != nil {
+		return nil, p.err
+	}
+	movie := *p.movie
+	return &movie, nil
+}
+
+var cases = []struct {
+	name         string
+	providers    []*providerStub
+	result       *Movie
+	queries      []Query
+	errorMessage string
+}{
+	{
+		name: "case first provider decides and second adds ratings",
+		providers: []*providerStub{
+			{name: "tmdb", movie: &Movie{Title: "test title", Year: "2018", Thumbnail: "poster", Ratings: []Rating{{Source: "TMDb", Value: "7.0/10"}}}},
+			{name: "omdb", movie: &Movie{Title: "Test Title", Year: "2018", Synopsis: "plot", Ratings: []Rating{{Source: "IMDb", Value: "7.9/10"}, {Source: "tmdb", Value: "1.0/10"}}}},
+		},
+		result: &Movie{Title: "test title", Year: "2018", Thumbnail: "poster", Synopsis: "plot", Ratings: []Rating{{Source: "TMDb", Value: "7.0/10"}, {Source: "IMDb", Value: "7.9/10"}}},
+	},
+	{
+		name: "case first provider is down",
+		providers: []*providerStub{
+			{name: "tmdb", err: fmt.Errorf("HTTP response 503")},
+			{name: "omdb", movie: &Movie{Title: "test title", Year: "2018"}},
+		},
+		result: &Movie{Title: "test title", Year: "2018"},
+	},
+	{
+		name: "case every provider fails",
+		providers: []*providerStub{
+			{name: "tmdb", err: fmt.Errorf("HTTP response 503")},
+			{name: "omdb", err: fmt.Errorf("Movie not found!")},
+		},
+		errorMessage: "tmdb: HTTP response 503; omdb: Movie not found!",
+	},
+	{
+		name: "case ids found by first provider are passed to the next one",
+		providers: []*providerStub{
+			{name: "nfo", movie: &Movie{Title: "test title", Year: "2018", Genres: []string{"Drama"}, TMDbID: "42", Poster: "/movies/test title (2018)/poster.jpg"}},
+			{name: "tmdb", movie: &Movie{Title: "test title", Year: "2018", Thumbnail: "poster", TMDbID: "42", IMDbID: "tt0000042", Genres: []string{"Comedy"}}},
+			{name: "omdb", movie: &Movie{Title: "test title", Year: "2018", IMDbID: "tt0000042"}},
+		},
+		result: &Movie{Title: "test title", Year: "2018", Thumbnail: "poster", Genres: []string{"Drama"}, TMDbID: "42", IMDbID: "tt0000042", Poster: "/movies/test title (2018)/poster.jpg"},
+		queries: []Query{
+			{Title: "test title", Year: "2018"},
+			{Title: "test title", Year: "2018", TMDbID: "42"},
+			{Title: "test title", Year: "2018", TMDbID: "42", IMDbID: "tt0000042"},
+		},
+	},
+	{
+		name:         "case no provider configured",
+		errorMessage: "no metadata provider configured",
+	},
+}
+
+func TestChain_Lookup(t *testing.T) {
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var providers []Provider
+			for _, p := range tt.providers {
+				providers = append(providers, p)
+			}
+			chain := NewChain(providers...)
+			movie, err := chain.Lookup(context.Background(), Query{Title: "test title", Year: "2018"})
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in Lookup() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if !reflect.DeepEqual(movie, tt.result) {
+				t.Errorf("Lookup() = %v, want %v", movie, tt.result)
+			}
+			for i, p := range tt.providers {
+				if p.calls != 1 {
+					t.Errorf("%s called %d times, want 1", p.name, p.calls)
+				}
+				if tt.queries != nil && !reflect.DeepEqual(p.queries[0], tt.queries[i]) {
+					t.Errorf("%s queried with %v, want %v", p.name, p.queries[0], tt.queries[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRating_String(t *testing.T) {
+	ratings := []struct {
+		rating Rating
+		want   string
+	}{
+		{Rating{Source: "Rotten Tomatoes", Value: "91%"}, "91%"},
+		{Rating{Source: "IMDb", Value: "7.9/10", Votes: 512}, "7.9/10 (512 votes)"},
+		{Rating{Source: "IMDb", Value: "9.3/10", Votes: 2871412}, "9.3/10 (2,871,412 votes)"},
+	}
+	for _, tt := range ratings {
+		if got := tt.rating.String(); got != tt.want {
+			t.Errorf("String() = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestDetectResolution(t *testing.T) {
+	names := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"Test Title (2018)", "Test.Title.2018.2160p.UHD.BluRay.x265.mkv"}, "2160p"},
+		{[]string{"Test Title (2018) [4K]"}, "2160p"},
+		{[]string{"Test.Title.2018.720p.WEB.mkv", "Test.Title.2018.1080p.BluRay.mkv"}, "1080p"},
+		{[]string{"Test Title (2018)", "test.title.mkv"}, ""},
+		{[]string{"Test.Title.2018.x1080px.mkv"}, ""},
+	}
+	for _, tt := range names {
+		if got := DetectResolution(tt.names...); got != tt.want {
+			t.Errorf("DetectResolution(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestChain_LookupNotFound(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	chain := NewChain(
+		&providerStub{name: "nfo", err: fmt.Errorf("nfo file in /movies/a: %w", ErrNotFound)},
+		&providerStub{name: "tmdb", movie: &Movie{Title: "a", Year: "2018"}},
+	)
+	if _, err := chain.Lookup(context.Background(), Query{Title: "a", Year: "2018"}); err != nil {
+		t.Fatalf("Lookup() unexpected error %v", err)
+	}
+	if logged.Len() > 0 {
+		t.Errorf("Lookup() logged %q for a movie the provider has nothing about", logged.String())
+	}
+}
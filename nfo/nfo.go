@@ -0,0 +1,194 @@
+// Package nfo implements a metadata provider that reads Kodi and
+// Jellyfin style movie.nfo files and artwork kept next to a movie,
+// so that movie information is available without calling any API.
+package nfo
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+var (
+	// nfoNames are the nfo file names looked up first, before
+	// any other file with .nfo extension in the movie folder
+	nfoNames = []string{"movie.nfo"}
+	// posterNames are the artwork file names used as movie poster
+	posterNames = []string{"poster.jpg", "poster.png", "folder.jpg", "cover.jpg"}
+	// fanartNames are the artwork file names used as movie fanart
+	fanartNames = []string{"fanart.jpg", "fanart.png", "backdrop.jpg"}
+)
+
+// uniqueID represent uniqueid element of nfo file
+type uniqueID struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// thumb represent thumb element of nfo file
+type thumb struct {
+	Aspect string `xml:"aspect,attr"`
+	URL    string `xml:",chardata"`
+}
+
+// movieNFO represent the information we want to extract
+// from the movie element of nfo file
+type movieNFO struct {
+	XMLName   xml.Name   `xml:"movie"`
+	Title     string     `xml:"title"`
+	Year      string     `xml:"year"`
+	Premiered string     `xml:"premiered"`
+	Plot      string     `xml:"plot"`
+	Outline   string     `xml:"outline"`
+	Genres    []string   `xml:"genre"`
+	MPAA      string     `xml:"mpaa"`
+	ID        string     `xml:"id"`
+	TMDbID    string     `xml:"tmdbid"`
+	IMDbID    string     `xml:"imdbid"`
+	UniqueIDs []uniqueID `xml:"uniqueid"`
+	Thumbs    []thumb    `xml:"thumb"`
+}
+
+// NFO represent the local metadata provider
+type NFO struct {
+	// Reader reads the content of a file, it is replaced
+	// with a stub during unit testing.
+	Reader func(filename string) ([]byte, error)
+	// Glob lists the files of the movie folder matching a pattern
+	Glob func(pattern string) ([]string, error)
+	// Exists tells whether an artwork file is present
+	Exists func(filename string) bool
+}
+
+// New creates new instance of NFO reading from the file system
+func New() *NFO {
+	return &NFO{
+		Reader: ioutil.ReadFile,
+		Glob:   filepath.Glob,
+		Exists: exists,
+	}
+}
+
+// Name identifies NFO as metadata provider
+func (n *NFO) Name() string {
+	return "nfo"
+}
+
+// Lookup implements metadata.Provider by reading the nfo file and
+// artwork found in the movie folder at query.Path
+func (n *NFO) Lookup(ctx context.Context, query metadata.Query) (*metadata.Movie, error) {
+	if len(query.Path) == 0 {
+		return nil, fmt.Errorf("no folder to read nfo file from")
+	}
+	raw, err := n.read(query.Path)
+	if err != nil {
+		return nil, err
+	}
+	var parsed movieNFO
+	if err := xml.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid nfo file in %s: %s", query.Path, err)
+	}
+	movie := parsed.info()
+	if len(movie.Title) == 0 {
+		movie.Title = query.Title
+	}
+	if len(movie.Year) == 0 {
+		movie.Year = query.Year
+	}
+	movie.Poster = n.find(query.Path, posterNames)
+	movie.Fanart = n.find(query.Path, fanartNames)
+	return movie, nil
+}
+
+// read returns the content of movie.nfo, or of the first nfo file
+// found in folder when there is no movie.nfo
+func (n *NFO) read(folder string) ([]byte, error) {
+	for _, name := range nfoNames {
+		if raw, err := n.Reader(filepath.Join(folder, name)); err == nil {
+			return raw, nil
+		}
+	}
+	matches, _ := n.Glob(filepath.Join(folder, "*.nfo"))
+	for _, match := range matches {
+		if raw, err := n.Reader(match); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, fmt.Errorf("nfo file in %s: %w", folder, metadata.ErrNotFound)
+}
+
+// find returns the path of the first of names existing in folder
+func (n *NFO) find(folder string, names []string) string {
+	for _, name := range names {
+		path := filepath.Join(folder, name)
+		if n.Exists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// info converts the parsed nfo file into metadata.Movie
+func (parsed *movieNFO) info() *metadata.Movie {
+	movie := &metadata.Movie{
+		Title:    strings.TrimSpace(parsed.Title),
+		Year:     strings.TrimSpace(parsed.Year),
+		Synopsis: strings.TrimSpace(parsed.Plot),
+		TMDbID:   strings.TrimSpace(parsed.TMDbID),
+		IMDbID:   strings.TrimSpace(parsed.IMDbID),
+	}
+	if len(movie.Year) == 0 && len(parsed.Premiered) >= 4 {
+		movie.Year = parsed.Premiered[:4]
+	}
+	if len(movie.Synopsis) == 0 {
+		movie.Synopsis = strings.TrimSpace(parsed.Outline)
+	}
+	// mpaa is written either as "Rated PG-13" or as "US:PG-13"
+	mpaa := strings.TrimPrefix(strings.TrimSpace(parsed.MPAA), "Rated ")
+	if colon := strings.LastIndex(mpaa, ":"); colon >= 0 {
+		mpaa = mpaa[colon+1:]
+	}
+	movie.Certification = strings.TrimSpace(mpaa)
+	for _, g := range parsed.Genres {
+		if g = strings.TrimSpace(g); len(g) > 0 {
+			movie.Genres = append(movie.Genres, g)
+		}
+	}
+	for _, id := range parsed.UniqueIDs {
+		value := strings.TrimSpace(id.Value)
+		switch strings.ToLower(id.Type) {
+		case "tmdb":
+			if len(movie.TMDbID) == 0 {
+				movie.TMDbID = value
+			}
+		case "imdb":
+			if len(movie.IMDbID) == 0 {
+				movie.IMDbID = value
+			}
+		}
+	}
+	// older nfo files only keep the IMDb id in the id element
+	if id := strings.TrimSpace(parsed.ID); len(movie.IMDbID) == 0 && strings.HasPrefix(id, "tt") {
+		movie.IMDbID = id
+	}
+	for _, t := range parsed.Thumbs {
+		url := strings.TrimSpace(t.URL)
+		if (t.Aspect == "" || t.Aspect == "poster") && strings.HasPrefix(url, "http") {
+			movie.Thumbnail = url
+			break
+		}
+	}
+	return movie
+}
+
+// exists tells whether a regular file is present at path
+func exists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,131 @@
+package nfo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+var (
+	sampleNFO = `<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>
+<movie>
+  <title>Test Title</title>
+  <year>2018</year>
+  <plot>test plot</plot>
+  <genre>Drama</genre>
+  <genre>Comedy</genre>
+  <mpaa>US:PG-13</mpaa>
+  <uniqueid type="tmdb">42</uniqueid>
+  <uniqueid type="imdb" default="true">tt0000042</uniqueid>
+  <thumb aspect="poster">https://image.tmdb.org/t/p/original/poster.jpg</thumb>
+</movie>`
+	legacyNFO = `<movie>
+  <title>Test Title</title>
+  <premiered>2018-05-04</premiered>
+  <outline>test outline</outline>
+  <mpaa>Rated R</mpaa>
+  <id>tt0000042</id>
+  <tmdbid>42</tmdbid>
+</movie>`
+	folder = "/movies/Test Title (2018)"
+)
+
+func fsStub(files map[string]string) *NFO {
+	return &NFO{
+		Reader: func(filename string) ([]byte, error) {
+			if content, ok := files[filename]; ok {
+				return []byte(content), nil
+			}
+			return nil, fmt.Errorf("open %s: no such file or directory", filename)
+		},
+		Glob: func(pattern string) ([]string, error) {
+			var matches []string
+			for name := range files {
+				if strings.HasSuffix(name, ".nfo") {
+					matches = append(matches, name)
+				}
+			}
+			return matches, nil
+		},
+		Exists: func(filename string) bool {
+			_, ok := files[filename]
+			return ok
+		},
+	}
+}
+
+var cases = []struct {
+	name         string
+	files        map[string]string
+	result       *metadata.Movie
+	errorMessage string
+}{
+	{
+		name: "case movie.nfo with artwork",
+		files: map[string]string{
+			folder + "/movie.nfo":  sampleNFO,
+			folder + "/poster.jpg": "",
+			folder + "/fanart.jpg": "",
+		},
+		result: &metadata.Movie{
+			Title:         "Test Title",
+			Year:          "2018",
+			Synopsis:      "test plot",
+			Genres:        []string{"Drama", "Comedy"},
+			Certification: "PG-13",
+			TMDbID:        "42",
+			IMDbID:        "tt0000042",
+			Thumbnail:     "https://image.tmdb.org/t/p/original/poster.jpg",
+			Poster:        folder + "/poster.jpg",
+			Fanart:        folder + "/fanart.jpg",
+		},
+	},
+	{
+		name: "case nfo named after the video file with legacy elements",
+		files: map[string]string{
+			folder + "/Test Title (2018).nfo": legacyNFO,
+			folder + "/folder.jpg":            "",
+		},
+		result: &metadata.Movie{
+			Title:         "Test Title",
+			Year:          "2018",
+			Synopsis:      "test outline",
+			Certification: "R",
+			TMDbID:        "42",
+			IMDbID:        "tt0000042",
+			Poster:        folder + "/folder.jpg",
+		},
+	},
+	{
+		name:         "case no nfo file in folder",
+		files:        map[string]string{folder + "/poster.jpg": ""},
+		errorMessage: "nfo file in " + folder + ": not found",
+	},
+	{
+		name:         "case invalid nfo file",
+		files:        map[string]string{folder + "/movie.nfo": "<tvshow></tvshow>"},
+		errorMessage: "invalid nfo file",
+	},
+}
+
+func TestNFO_Lookup(t *testing.T) {
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := fsStub(tt.files)
+			movie, err := provider.Lookup(context.Background(), metadata.Query{Title: "Test Title", Year: "2018", Path: folder})
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in Lookup() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if !reflect.DeepEqual(movie, tt.result) {
+				t.Errorf("Lookup() = %+v, want %+v", movie, tt.result)
+			}
+		})
+	}
+}
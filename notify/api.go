@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// apiBaseURL provides the endpoint for Slack Web API
+	apiBaseURL = "https://slack.com/api"
+)
+
+// API represent a connection to Slack Web API authenticated with
+// a bot token
+type API struct {
+	// Token is the bot token, it starts with xoxb-
+	Token string
+	// BaseURL is the endpoint of Slack Web API, it is replaced with
+	// a local stub during testing
+	BaseURL string
+	// Client is an instance of httpClient interface
+	Client httpClient
+}
+
+// Ref locates a message posted through the Web API
+type Ref struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// apiResponse represent the fields of Web API responses we need
+type apiResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// NewAPI creates new instance of API using token, the default Slack
+// endpoint is used when baseURL is empty and the default transport
+// when transport is nil
+func NewAPI(token string, baseURL string, transport http.RoundTripper) *API {
+	if len(baseURL) == 0 {
+		baseURL = apiBaseURL
+	}
+	return &API{
+		Token:   token,
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   defaultTimeout,
+		},
+	}
+}
+
+// PostMessage posts payload to channel with chat.postMessage, as a
+// reply in the thread of threadTS when it is not empty. It returns
+// where the message was posted so that it can be edited later.
+func (api *API) PostMessage(ctx context.Context, channel string, threadTS string, payload Payload) (*Ref, error) {
+	extra := map[string]string{"channel": channel}
+	if len(threadTS) > 0 {
+		extra["thread_ts"] = threadTS
+	}
+	res, err := api.call(ctx, "chat.postMessage", payload, extra)
+	if err != nil {
+		return nil, err
+	}
+	return &Ref{Channel: res.Channel, TS: res.TS}, nil
+}
+
+// Update replaces the message at ref with payload using chat.update
+func (api *API) Update(ctx context.Context, ref Ref, payload Payload) error {
+	_, err := api.call(ctx, "chat.update", payload, map[string]string{"channel": ref.Channel, "ts": ref.TS})
+	return err
+}
+
+// Delete removes the message at ref using chat.delete
+func (api *API) Delete(ctx context.Context, ref Ref) error {
+	_, err := api.call(ctx, "chat.delete", Payload{}, map[string]string{"channel": ref.Channel, "ts": ref.TS})
+	return err
+}
+
+// call sends payload along with extra fields to the Web API method
+func (api *API) call(ctx context.Context, method string, payload Payload, extra map[string]string) (*apiResponse, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		fields[key] = value
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/%s", api.BaseURL, method), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	request.Header.Set("Authorization", "Bearer "+api.Token)
+	res, err := api.Client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := rateLimited(res); err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: HTTP response %d", method, res.StatusCode)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var decoded apiResponse
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("%s: %s", method, err)
+	}
+	if !decoded.OK {
+		return nil, fmt.Errorf("%s: %s", method, decoded.Error)
+	}
+	return &decoded, nil
+}
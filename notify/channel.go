@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// RemoveStrike edits the message of a removed movie to strike it
+	// through, it is the default
+	RemoveStrike = "strike"
+	// RemoveDelete deletes the message of a removed movie
+	RemoveDelete = "delete"
+	// RemovePost posts the removal in the thread of the movie message
+	RemovePost = "post"
+
+	// messagesBucket is the bucket of state where the messages
+	// posted for every item are kept
+	messagesBucket = "messages"
+)
+
+// State keeps where the message of every item was posted
+type State interface {
+	Get(bucket string, key string, value interface{}) (bool, error)
+	Put(bucket string, key string, value interface{}) error
+	Delete(bucket string, key string) error
+}
+
+// Pruner removes the expired values of a bucket, store.Store
+// implements it
+type Pruner interface {
+	Prune(bucket string, expired func(key string, value json.RawMessage) bool) (int, error)
+}
+
+// PruneMessages forgets the messages posted before before, later
+// messages about their movies are posted anew. It returns how many
+// were forgotten.
+func PruneMessages(state Pruner, before time.Time) (int, error) {
+	return state.Prune(messagesBucket, func(key string, value json.RawMessage) bool {
+		var ref Ref
+		if err := json.Unmarshal(value, &ref); err != nil {
+			return true
+		}
+		return ref.Posted().Before(before)
+	})
+}
+
+// Posted returns when the message of ref was posted, the timestamp
+// of a Slack message is the unix time it was posted at
+func (ref Ref) Posted() time.Time {
+	seconds, _ := strconv.ParseFloat(ref.TS, 64)
+	return time.Unix(int64(seconds), 0)
+}
+
+// Channel is a Destination posting to a Slack channel through the
+// Web API. It remembers the message posted for every item so that
+// later messages about the same item edit it, delete it or follow
+// it up in its thread instead of posting a new message.
+type Channel struct {
+	API      *API
+	Channel  string
+	OnRemove string
+	State    State
+}
+
+// Deliver implements Destination. A new movie is posted, or edits
+// the message posted earlier. An upgraded movie edits the message
+// posted earlier and follows it up in its thread. A removed movie
+// strikes, deletes or follows up the message posted earlier depending
+// on OnRemove. Digests and alerts are always posted.
+func (channel *Channel) Deliver(ctx context.Context, message Message) error {
+	if message.Kind == KindDigest || message.Kind == KindAlert {
+		_, err := channel.API.PostMessage(ctx, channel.Channel, "", message.Payload)
+		return err
+	}
+	key := message.Destination + "/" + message.Item
+	var ref Ref
+	found, err := channel.State.Get(messagesBucket, key, &ref)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if message.Kind == KindRemoved && channel.OnRemove == RemoveDelete {
+			return nil
+		}
+		return channel.post(ctx, key, message.Payload)
+	}
+	switch message.Kind {
+	case KindUpgraded:
+		if err := channel.API.Update(ctx, ref, message.Payload); err != nil {
+			return err
+		}
+		_, err := channel.API.PostMessage(ctx, ref.Channel, ref.TS, Payload{Text: message.Payload.Text})
+		return err
+	case KindRemoved:
+		switch channel.OnRemove {
+		case RemoveDelete:
+			if err := channel.API.Delete(ctx, ref); err != nil {
+				return err
+			}
+			return channel.State.Delete(messagesBucket, key)
+		case RemovePost:
+			_, err := channel.API.PostMessage(ctx, ref.Channel, ref.TS, message.Payload)
+			return err
+		}
+	}
+	return channel.API.Update(ctx, ref, message.Payload)
+}
+
+// post posts payload and remembers where it was posted under key.
+// Failing to remember it is only logged, Slack accepted the message
+// and retrying would post it twice.
+func (channel *Channel) post(ctx context.Context, key string, payload Payload) error {
+	ref, err := channel.API.PostMessage(ctx, channel.Channel, "", payload)
+	if err != nil {
+		return err
+	}
+	if err := channel.State.Put(messagesBucket, key, ref); err != nil {
+		log.Println("error: remembering where", key, "was posted:", err)
+	}
+	return nil
+}
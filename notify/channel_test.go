@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+// slackStub records the Web API calls it receives and answers
+// like Slack does
+type slackStub struct {
+	mutex sync.Mutex
+	calls []string
+	next  int
+}
+
+func (stub *slackStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var fields map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&fields)
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	if r.Header.Get("Authorization") != "Bearer xoxb-test" {
+		fmt.Fprint(w, `{"ok":false,"error":"invalid_auth"}`)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	call := fmt.Sprintf("%s %v %v", method, fields["channel"], fields["ts"])
+	if thread, ok := fields["thread_ts"]; ok {
+		call += fmt.Sprintf(" thread=%v", thread)
+	}
+	if text, ok := fields["text"]; ok {
+		call += fmt.Sprintf(" %q", text)
+	}
+	stub.calls = append(stub.calls, call)
+	switch method {
+	case "chat.postMessage":
+		stub.next++
+		fmt.Fprintf(w, `{"ok":true,"channel":"C123","ts":"%d.000"}`, stub.next)
+	case "chat.update", "chat.delete":
+		fmt.Fprint(w, `{"ok":true,"channel":"C123","ts":"1.000"}`)
+	default:
+		fmt.Fprint(w, `{"ok":false,"error":"unknown_method"}`)
+	}
+}
+
+var channelCases = []struct {
+	name     string
+	onRemove string
+	messages []Message
+	calls    []string
+}{
+	{
+		name: "case new movie is posted then edited with better metadata",
+		messages: []Message{
+			{Destination: "general", Item: "movies/a", Kind: KindNew, Payload: Payload{Text: "a"}},
+			{Destination: "general", Item: "movies/a", Kind: KindNew, Payload: Payload{Text: "a better"}},
+		},
+		calls: []string{
+			`chat.postMessage #general <nil> "a"`,
+			`chat.update C123 1.000 "a better"`,
+		},
+	},
+	{
+		name: "case upgraded movie is edited and followed up in thread",
+		messages: []Message{
+			{Destination: "general", Item: "movies/a", Kind: KindNew, Payload: Payload{Text: "a"}},
+			{Destination: "general", Item: "movies/a", Kind: KindUpgraded, Payload: Payload{Text: "a 4k"}},
+		},
+		calls: []string{
+			`chat.postMessage #general <nil> "a"`,
+			`chat.update C123 1.000 "a 4k"`,
+			`chat.postMessage C123 <nil> thread=1.000 "a 4k"`,
+		},
+	},
+	{
+		name:     "case removed movie is struck through",
+		onRemove: RemoveStrike,
+		messages: []Message{
+			{Destination: "general", Item: "movies/a", Kind: KindNew, Payload: Payload{Text: "a"}},
+			{Destination: "general", Item: "movies/a", Kind: KindRemoved, Payload: Payload{Text: "~a~"}},
+		},
+		calls: []string{
+			`chat.postMessage #general <nil> "a"`,
+			`chat.update C123 1.000 "~a~"`,
+		},
+	},
+	{
+		name:     "case removed movie is deleted",
+		onRemove: RemoveDelete,
+		messages: []Message{
+			{Destination: "general", Item: "movies/a", Kind: KindNew, Payload: Payload{Text: "a"}},
+			{Destination: "general", Item: "movies/a", Kind: KindRemoved, Payload: Payload{Text: "~a~"}},
+			{Destination: "general", Item: "movies/a", Kind: KindRemoved, Payload: Payload{Text: "~a~"}},
+		},
+		calls: []string{
+			`chat.postMessage #general <nil> "a"`,
+			`chat.delete C123 1.000`,
+		},
+	},
+	{
+		name:     "case removal is posted in thread",
+		onRemove: RemovePost,
+		messages: []Message{
+			{Destination: "general", Item: "movies/a", Kind: KindNew, Payload: Payload{Text: "a"}},
+			{Destination: "general", Item: "movies/b", Kind: KindNew, Payload: Payload{Text: "b"}},
+			{Destination: "general", Item: "movies/a", Kind: KindRemoved, Payload: Payload{Text: "~a~"}},
+		},
+		calls: []string{
+			`chat.postMessage #general <nil> "a"`,
+			`chat.postMessage #general <nil> "b"`,
+			`chat.postMessage C123 <nil> thread=1.000 "~a~"`,
+		},
+	},
+	{
+		name: "case alerts are always posted",
+		messages: []Message{
+			{Destination: "ops", Item: "alert/a", Kind: KindAlert, Payload: Alert("a failed")},
+			{Destination: "ops", Item: "alert/a", Kind: KindAlert, Payload: Alert("a failed")},
+		},
+		calls: []string{
+			`chat.postMessage #general <nil> ":warning: a failed"`,
+			`chat.postMessage #general <nil> ":warning: a failed"`,
+		},
+	},
+}
+
+func TestChannel_Deliver(t *testing.T) {
+	for _, tt := range channelCases {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &slackStub{}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+			channel := &Channel{
+				API:      NewAPI("xoxb-test", server.URL+"/api/", nil),
+				Channel:  "#general",
+				OnRemove: tt.onRemove,
+				State:    store.Memory(),
+			}
+			for _, message := range tt.messages {
+				if err := channel.Deliver(context.Background(), message); err != nil {
+					t.Fatalf("Deliver() unexpected error %v", err)
+				}
+			}
+			if !reflect.DeepEqual(stub.calls, tt.calls) {
+				t.Errorf("Deliver() calls = %q, want %q", stub.calls, tt.calls)
+			}
+		})
+	}
+}
+
+// failingState is a State failing to remember anything
+type failingState struct {
+	*store.Store
+}
+
+func (state failingState) Put(bucket string, key string, value interface{}) error {
+	return fmt.Errorf("disk full")
+}
+
+func TestChannel_DeliverStateFails(t *testing.T) {
+	stub := &slackStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	channel := &Channel{
+		API:     NewAPI("xoxb-test", server.URL+"/api/", nil),
+		Channel: "#general",
+		State:   failingState{store.Memory()},
+	}
+	// Slack accepted the message, failing would post it again
+	err := channel.Deliver(context.Background(), Message{Destination: "general", Item: "movies/a", Kind: KindNew, Payload: Payload{Text: "a"}})
+	if err != nil {
+		t.Errorf("Deliver() unexpected error %v", err)
+	}
+	if len(stub.calls) != 1 {
+		t.Errorf("Deliver() calls = %q, want a single post", stub.calls)
+	}
+}
+
+func TestPruneMessages(t *testing.T) {
+	state := store.Memory()
+	state.Put(messagesBucket, "general/movies/a", Ref{Channel: "C1", TS: "1556741700.000100"})
+	state.Put(messagesBucket, "general/movies/b", Ref{Channel: "C1", TS: "1556741800.000200"})
+	removed, err := PruneMessages(state, time.Unix(1556741750, 0))
+	if removed != 1 || err != nil {
+		t.Errorf("PruneMessages() = %v, %v, want 1", removed, err)
+	}
+	if keys := state.Keys(messagesBucket); !reflect.DeepEqual(keys, []string{"general/movies/b"}) {
+		t.Errorf("PruneMessages() kept %v, want the later message", keys)
+	}
+}
+
+func TestAPI_Error(t *testing.T) {
+	server := httptest.NewServer(&slackStub{})
+	defer server.Close()
+	api := NewAPI("xoxb-wrong", server.URL+"/api", nil)
+	_, err := api.PostMessage(context.Background(), "#general", "", Payload{Text: "a"})
+	if err == nil || !strings.Contains(err.Error(), "chat.postMessage: invalid_auth") {
+		t.Errorf("PostMessage() error = %v, want invalid_auth", err)
+	}
+}
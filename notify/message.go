@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRetryAfter is the wait asked by a rate limited response
+	// without Retry-After header
+	defaultRetryAfter = time.Second
+)
+
+// Message is an announcement rendered for a destination. Item
+// identifies the movie it is about, so that destinations able to
+// edit their messages can find the ones posted earlier.
+type Message struct {
+	Destination string
+	Item        string
+	Kind        Kind
+	Payload     Payload
+	Created     time.Time
+}
+
+// Destination delivers messages to Slack
+type Destination interface {
+	Deliver(ctx context.Context, message Message) error
+}
+
+// Key identifies the movie of announcement within its library by the
+// name of its folder, or by its title when the folder is unknown, and
+// the server of the library when it is named. Removed movies are only
+// known by their folder, keying on it lets them find the messages
+// announcing them.
+func (announcement Announcement) Key() string {
+	name := announcement.Folder
+	if len(name) == 0 {
+		name = Title(announcement.Movie)
+	}
+	key := announcement.Library + "/" + name
+	if len(announcement.Server) > 0 {
+		return announcement.Server + "/" + key
+	}
+	return key
+}
+
+// RateLimitError is returned when Slack turns a message down because
+// too many messages were sent, nothing must be sent to the same place
+// before RetryAfter has passed
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited by Slack, retry after %s", err.RetryAfter)
+}
+
+// rateLimited returns a RateLimitError when res tells too many
+// messages were sent
+func rateLimited(res *http.Response) error {
+	if res.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	wait := defaultRetryAfter
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		wait = time.Duration(seconds) * time.Second
+	}
+	return &RateLimitError{RetryAfter: wait}
+}
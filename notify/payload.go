@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Payload represent the message sent to Slack. Text is shown in
+// notifications and by clients that can't render the rest of it.
+type Payload struct {
+	Text        string       `json:"text,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Blocks      []Block      `json:"blocks,omitempty"`
+
+	// raw keeps the message as it was decoded, so that fields this
+	// package doesn't know about are sent to Slack unchanged
+	raw json.RawMessage
+}
+
+// payload has the fields of Payload without its json methods
+type payload Payload
+
+// MarshalJSON encodes the message, a decoded message is encoded
+// exactly as it was decoded
+func (p Payload) MarshalJSON() ([]byte, error) {
+	if p.raw != nil {
+		return p.raw, nil
+	}
+	return json.Marshal(payload(p))
+}
+
+// UnmarshalJSON decodes the message and keeps its original JSON
+func (p *Payload) UnmarshalJSON(data []byte) error {
+	var decoded payload
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return err
+	}
+	*p = Payload(decoded)
+	p.raw = compact.Bytes()
+	return nil
+}
+
+// Field represent a short title and value pair shown
+// in a legacy attachment
+type Field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// Attachment represent a legacy Slack message attachment
+type Attachment struct {
+	Title    string  `json:"title,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	ImageURL string  `json:"image_url,omitempty"`
+	ThumbURL string  `json:"thumb_url,omitempty"`
+	Fields   []Field `json:"fields,omitempty"`
+}
+
+// Text represent a Block Kit text object, its Type is
+// either plain_text or mrkdwn
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Image represent a Block Kit image element
+type Image struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// Button represent a Block Kit button element opening URL
+type Button struct {
+	Type string `json:"type"`
+	Text Text   `json:"text"`
+	URL  string `json:"url"`
+}
+
+// Block represent a Block Kit layout block. Elements holds Text,
+// Image or Button values depending on the type of the block.
+type Block struct {
+	Type      string        `json:"type"`
+	Text      *Text         `json:"text,omitempty"`
+	Accessory *Image        `json:"accessory,omitempty"`
+	Elements  []interface{} `json:"elements,omitempty"`
+}
+
+// PlainText creates a plain_text text object
+func PlainText(text string) *Text {
+	return &Text{Type: "plain_text", Text: text}
+}
+
+// Markdown creates a mrkdwn text object
+func Markdown(text string) *Text {
+	return &Text{Type: "mrkdwn", Text: text}
+}
@@ -0,0 +1,381 @@
+// Package notify renders announcements into Slack messages and
+// delivers them to Slack.
+package notify
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+const (
+	// LayoutBlocks renders announcements with Block Kit
+	LayoutBlocks = "blocks"
+	// LayoutLegacy renders announcements with legacy attachments
+	// for older workspaces
+	LayoutLegacy = "legacy"
+
+	// maxDigestMovies is the number of movies listed in a digest, Slack
+	// rejects messages holding more than 50 blocks
+	maxDigestMovies = 45
+	// maxHeaderText and maxSectionText are the longest texts Slack
+	// accepts in header and section blocks
+	maxHeaderText  = 150
+	maxSectionText = 3000
+)
+
+// Kind is the type of event an announcement is about
+type Kind string
+
+const (
+	// KindNew announces a movie added to a library
+	KindNew Kind = "new"
+	// KindRemoved announces a movie removed from a library
+	KindRemoved Kind = "removed"
+	// KindUpgraded announces a movie whose video files were replaced
+	KindUpgraded Kind = "upgraded"
+	// KindDigest announces several movies in a single message
+	KindDigest Kind = "digest"
+	// KindAlert tells the people running the program that something
+	// went wrong, it is not an announcement and has no template
+	KindAlert Kind = "alert"
+)
+
+// Kinds lists every kind of announcement
+var Kinds = []Kind{KindNew, KindRemoved, KindUpgraded, KindDigest}
+
+// Alert returns the message of an alert telling text
+func Alert(text string) Payload {
+	return Payload{Text: ":warning: " + text}
+}
+
+// Links toggles each of the links added to an announcement
+type Links struct {
+	Trailer bool
+	IMDb    bool
+	TMDb    bool
+}
+
+// Link is a URL along with the label it is shown with
+type Link struct {
+	Label string
+	URL   string
+}
+
+// Announcement holds everything that is rendered into the
+// message announcing a movie. Movies holds the movies of a
+// digest, Movie is used by every other kind. Detected is when
+// the change was detected, messages are sent in that order.
+// PlexServer and PlexKey are the machine identifier of the
+// server and the rating key of the movie once Plex indexed it.
+// Server names the server holding the library when there are
+// several of them.
+type Announcement struct {
+	Kind       Kind
+	Server     string
+	Library    string
+	Folder     string
+	Movie      metadata.Movie
+	Movies     []metadata.Movie
+	PlexURL    string
+	PlexServer string
+	PlexKey    string
+	Links      Links
+	Detected   time.Time
+}
+
+// Render renders announcement with the given layout, LayoutBlocks
+// is used when layout is empty. When tmpl is not nil its output
+// replaces either the text or the whole message, see Template.
+func Render(layout string, tmpl *Template, announcement Announcement) (Payload, error) {
+	intro := announcement.Intro()
+	if tmpl != nil {
+		out, payload, err := tmpl.Execute(announcement)
+		if err != nil {
+			return Payload{}, err
+		}
+		if payload != nil {
+			return *payload, nil
+		}
+		intro = out
+	}
+	switch layout {
+	case LayoutBlocks, "":
+		return Blocks(announcement, intro), nil
+	case LayoutLegacy:
+		return Legacy(announcement, intro), nil
+	}
+	return Payload{}, fmt.Errorf("unknown message layout %q", layout)
+}
+
+// Intro returns the default text opening the announcement
+func (announcement Announcement) Intro() string {
+	switch announcement.Kind {
+	case KindRemoved:
+		return "This movie has been removed from Plex"
+	case KindUpgraded:
+		return fmt.Sprintf("A better version of this movie is now available on %s", announcement.plexMention())
+	case KindDigest:
+		return fmt.Sprintf("%d new movies are now available on %s", len(announcement.Movies), announcement.plexMention())
+	}
+	return fmt.Sprintf("New movie is now available on %s", announcement.plexMention())
+}
+
+// plexMention returns the name of the server of announcement linking
+// to PlexLink, or the bare name when there is no link
+func (announcement Announcement) plexMention() string {
+	link := announcement.PlexLink()
+	if len(link) == 0 {
+		return announcement.PlexName()
+	}
+	return fmt.Sprintf("<%s|%s>", link, announcement.PlexName())
+}
+
+// Legacy renders announcement with legacy attachments, one
+// for title and poster and another for synopsis and ratings
+func Legacy(announcement Announcement, intro string) Payload {
+	if announcement.Kind == KindDigest {
+		movies, more := digestMovies(announcement.Movies)
+		var attachments []Attachment
+		for _, movie := range movies {
+			attachments = append(attachments, Attachment{Title: Title(movie), ThumbURL: movie.Thumbnail})
+		}
+		if more > 0 {
+			attachments = append(attachments, Attachment{Text: fmt.Sprintf("and %d more", more)})
+		}
+		return Payload{
+			Text:        intro,
+			Attachments: attachments,
+		}
+	}
+	movie := announcement.Movie
+	if announcement.Kind == KindRemoved {
+		return Payload{
+			Text:        intro,
+			Attachments: []Attachment{{Title: Title(movie), Text: fmt.Sprintf("~%s~", Title(movie))}},
+		}
+	}
+	title := Attachment{
+		Title:    Title(movie),
+		ImageURL: movie.Thumbnail,
+	}
+	var links []string
+	for _, l := range announcement.EnabledLinks() {
+		links = append(links, fmt.Sprintf("<%s|%s>", l.URL, l.Label))
+	}
+	title.Text = strings.Join(links, " | ")
+	synopsis := Attachment{
+		Title: "Synopsis",
+		Text:  movie.Synopsis,
+	}
+	for _, rating := range movie.Ratings {
+		synopsis.Fields = append(synopsis.Fields, Field{
+			Title: rating.Source,
+			Value: rating.String(),
+			Short: true,
+		})
+	}
+	return Payload{
+		Text:        intro,
+		Attachments: []Attachment{title, synopsis},
+	}
+}
+
+// Blocks renders announcement with Block Kit, a header holding the
+// title, a section holding synopsis with poster as accessory, context
+// blocks holding ratings and genres and buttons for every link
+func Blocks(announcement Announcement, intro string) Payload {
+	if announcement.Kind == KindDigest {
+		return digestBlocks(announcement, intro)
+	}
+	movie := announcement.Movie
+	title := Title(movie)
+	if announcement.Kind == KindRemoved {
+		return Payload{
+			Text: fmt.Sprintf("%s: %s", plain(intro), title),
+			Blocks: []Block{{
+				Type: "section",
+				Text: Markdown(truncate(fmt.Sprintf("~*%s*~\n%s", title, intro), maxSectionText)),
+			}},
+		}
+	}
+	blocks := []Block{{
+		Type: "header",
+		Text: PlainText(truncate(title, maxHeaderText)),
+	}}
+	section := Block{
+		Type: "section",
+		Text: Markdown(intro),
+	}
+	if len(movie.Synopsis) > 0 {
+		section.Text.Text += "\n\n" + movie.Synopsis
+	}
+	section.Text.Text = truncate(section.Text.Text, maxSectionText)
+	if len(movie.Thumbnail) > 0 {
+		section.Accessory = &Image{
+			Type:     "image",
+			ImageURL: movie.Thumbnail,
+			AltText:  title,
+		}
+	}
+	blocks = append(blocks, section)
+	if len(movie.Ratings) > 0 {
+		var elements []interface{}
+		for _, rating := range movie.Ratings {
+			elements = append(elements, Markdown(fmt.Sprintf("*%s* %s", rating.Source, rating.String())))
+		}
+		blocks = append(blocks, Block{Type: "context", Elements: elements})
+	}
+	if len(movie.Genres) > 0 {
+		blocks = append(blocks, Block{
+			Type:     "context",
+			Elements: []interface{}{Markdown(strings.Join(movie.Genres, ", "))},
+		})
+	}
+	var buttons []interface{}
+	if link := announcement.PlexLink(); len(link) > 0 {
+		buttons = append(buttons, button("Open in "+announcement.PlexName(), link))
+	}
+	for _, l := range announcement.EnabledLinks() {
+		buttons = append(buttons, button(l.Label, l.URL))
+	}
+	// Slack rejects actions blocks without elements
+	if len(buttons) > 0 {
+		blocks = append(blocks, Block{Type: "actions", Elements: buttons})
+	}
+	return Payload{
+		Text:   fmt.Sprintf("%s: %s", plain(intro), title),
+		Blocks: blocks,
+	}
+}
+
+// digestBlocks renders a digest announcement with Block Kit,
+// a line for each movie with its poster as accessory
+func digestBlocks(announcement Announcement, intro string) Payload {
+	blocks := []Block{
+		{Type: "header", Text: PlainText("New on Plex")},
+		{Type: "section", Text: Markdown(intro)},
+	}
+	movies, more := digestMovies(announcement.Movies)
+	for _, movie := range movies {
+		section := Block{
+			Type: "section",
+			Text: Markdown(truncate(fmt.Sprintf("*%s*", Title(movie)), maxSectionText)),
+		}
+		if len(movie.Thumbnail) > 0 {
+			section.Accessory = &Image{
+				Type:     "image",
+				ImageURL: movie.Thumbnail,
+				AltText:  Title(movie),
+			}
+		}
+		blocks = append(blocks, section)
+	}
+	if more > 0 {
+		blocks = append(blocks, Block{
+			Type:     "context",
+			Elements: []interface{}{Markdown(fmt.Sprintf("and %d more", more))},
+		})
+	}
+	return Payload{
+		Text:   plain(intro),
+		Blocks: blocks,
+	}
+}
+
+// digestMovies returns the movies of a digest that fit in a single
+// Slack message along with the number of movies left out
+func digestMovies(movies []metadata.Movie) ([]metadata.Movie, int) {
+	if len(movies) <= maxDigestMovies {
+		return movies, 0
+	}
+	return movies[:maxDigestMovies], len(movies) - maxDigestMovies
+}
+
+// Title formats the title of movie along with its year
+func Title(movie metadata.Movie) string {
+	if len(movie.Year) == 0 {
+		return movie.Title
+	}
+	return fmt.Sprintf("%s (%s)", movie.Title, movie.Year)
+}
+
+// PlexName returns how the server of announcement is called in
+// messages
+func (announcement Announcement) PlexName() string {
+	if len(announcement.Server) == 0 {
+		return "Plex"
+	}
+	return fmt.Sprintf("Plex (%s)", announcement.Server)
+}
+
+// PlexLink returns the address of the movie in Plex web app, or of
+// the web app itself when the movie wasn't found in Plex. It is empty
+// when the address of Plex is unknown.
+func (announcement Announcement) PlexLink() string {
+	if len(announcement.PlexURL) == 0 {
+		return ""
+	}
+	if len(announcement.PlexServer) == 0 || len(announcement.PlexKey) == 0 {
+		return fmt.Sprintf("%sweb/index.html", announcement.PlexURL)
+	}
+	return fmt.Sprintf("%sweb/index.html#!/server/%s/details?key=%s", announcement.PlexURL,
+		announcement.PlexServer, url.QueryEscape("/library/metadata/"+announcement.PlexKey))
+}
+
+// EnabledLinks returns the links of the movie enabled in announcement
+func (announcement Announcement) EnabledLinks() []Link {
+	movie := announcement.Movie
+	var links []Link
+	if announcement.Links.Trailer && len(movie.Trailer) > 0 {
+		links = append(links, Link{"Trailer", movie.Trailer})
+	}
+	if url := movie.IMDbURL(); announcement.Links.IMDb && len(url) > 0 {
+		links = append(links, Link{"IMDb", url})
+	}
+	if url := movie.TMDbURL(); announcement.Links.TMDb && len(url) > 0 {
+		links = append(links, Link{"TMDb", url})
+	}
+	return links
+}
+
+// button creates a Block Kit button opening URL
+func button(label string, URL string) Button {
+	return Button{
+		Type: "button",
+		Text: *PlainText(label),
+		URL:  URL,
+	}
+}
+
+// truncate shortens text to max characters ending with an ellipsis
+// when it is longer
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// plain replaces Slack links in text with their label, it is used for
+// the fallback text shown in notifications
+func plain(text string) string {
+	var out []string
+	for _, part := range strings.Split(text, "<") {
+		end := strings.Index(part, ">")
+		if end < 0 || len(out) == 0 {
+			out = append(out, part)
+			continue
+		}
+		label := part[:end]
+		if bar := strings.LastIndex(label, "|"); bar >= 0 {
+			label = label[bar+1:]
+		}
+		out = append(out, label+part[end+1:])
+	}
+	return strings.Join(out, "")
+}
@@ -0,0 +1,215 @@
+package notify
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+var (
+	sampleAnnouncement = Announcement{
+		Kind:    KindNew,
+		Library: "movies",
+		Movie: metadata.Movie{
+			Title:     "test title",
+			Year:      "2018",
+			Thumbnail: "https://image.tmdb.org/t/p/w92/poster/path",
+			Synopsis:  "test overview",
+			Genres:    []string{"Drama", "Comedy"},
+			TMDbID:    "42",
+			IMDbID:    "tt0000042",
+			Trailer:   "https://www.youtube.com/watch?v=key",
+			Ratings:   []metadata.Rating{{Source: "IMDb", Value: "7.9/10", Votes: 1200}},
+		},
+		PlexURL: "https://app.plex.tv/",
+		Links:   Links{Trailer: true, IMDb: true, TMDb: false},
+	}
+)
+
+func TestRender_Legacy(t *testing.T) {
+	payload, err := Render(LayoutLegacy, nil, sampleAnnouncement)
+	if err != nil {
+		t.Fatalf("Render() unexpected error %v", err)
+	}
+	want := Payload{
+		Text: "New movie is now available on <https://app.plex.tv/web/index.html|Plex>",
+		Attachments: []Attachment{
+			{
+				Title:    "test title (2018)",
+				Text:     "<https://www.youtube.com/watch?v=key|Trailer> | <https://www.imdb.com/title/tt0000042/|IMDb>",
+				ImageURL: "https://image.tmdb.org/t/p/w92/poster/path",
+			},
+			{
+				Title:  "Synopsis",
+				Text:   "test overview",
+				Fields: []Field{{Title: "IMDb", Value: "7.9/10 (1,200 votes)", Short: true}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("Render() = %+v, want %+v", payload, want)
+	}
+}
+
+func TestRender_Blocks(t *testing.T) {
+	payload, err := Render("", nil, sampleAnnouncement)
+	if err != nil {
+		t.Fatalf("Render() unexpected error %v", err)
+	}
+	want := Payload{
+		Text: "New movie is now available on Plex: test title (2018)",
+		Blocks: []Block{
+			{Type: "header", Text: PlainText("test title (2018)")},
+			{
+				Type: "section",
+				Text: Markdown("New movie is now available on <https://app.plex.tv/web/index.html|Plex>\n\ntest overview"),
+				Accessory: &Image{
+					Type:     "image",
+					ImageURL: "https://image.tmdb.org/t/p/w92/poster/path",
+					AltText:  "test title (2018)",
+				},
+			},
+			{Type: "context", Elements: []interface{}{Markdown("*IMDb* 7.9/10 (1,200 votes)")}},
+			{Type: "context", Elements: []interface{}{Markdown("Drama, Comedy")}},
+			{Type: "actions", Elements: []interface{}{
+				Button{Type: "button", Text: *PlainText("Open in Plex"), URL: "https://app.plex.tv/web/index.html"},
+				Button{Type: "button", Text: *PlainText("Trailer"), URL: "https://www.youtube.com/watch?v=key"},
+				Button{Type: "button", Text: *PlainText("IMDb"), URL: "https://www.imdb.com/title/tt0000042/"},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("Render() = %+v, want %+v", payload, want)
+	}
+}
+
+func TestRender_NoPoster(t *testing.T) {
+	announcement := sampleAnnouncement
+	announcement.Movie.Thumbnail = ""
+	payload, _ := Render(LayoutBlocks, nil, announcement)
+	if payload.Blocks[1].Accessory != nil {
+		t.Errorf("Render() accessory = %+v for a movie without poster", payload.Blocks[1].Accessory)
+	}
+}
+
+func TestRender_LongText(t *testing.T) {
+	announcement := sampleAnnouncement
+	announcement.Movie.Title = strings.Repeat("é", 200)
+	announcement.Movie.Synopsis = strings.Repeat("a", 4000)
+	payload, _ := Render(LayoutBlocks, nil, announcement)
+	header, section := []rune(payload.Blocks[0].Text.Text), []rune(payload.Blocks[1].Text.Text)
+	if len(header) != maxHeaderText || header[len(header)-1] != '…' {
+		t.Errorf("Render() header of %d characters, want %d ending with an ellipsis", len(header), maxHeaderText)
+	}
+	if len(section) != maxSectionText || section[len(section)-1] != '…' {
+		t.Errorf("Render() section of %d characters, want %d ending with an ellipsis", len(section), maxSectionText)
+	}
+}
+
+func TestRender_UnknownLayout(t *testing.T) {
+	if _, err := Render("fancy", nil, sampleAnnouncement); err == nil {
+		t.Error("Render() expected an error for unknown layout")
+	}
+}
+
+func TestRender_Kinds(t *testing.T) {
+	removed := sampleAnnouncement
+	removed.Kind = KindRemoved
+	payload, _ := Render(LayoutBlocks, nil, removed)
+	want := Payload{
+		Text:   "This movie has been removed from Plex: test title (2018)",
+		Blocks: []Block{{Type: "section", Text: Markdown("~*test title (2018)*~\nThis movie has been removed from Plex")}},
+	}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("Render() = %+v, want %+v", payload, want)
+	}
+
+	digest := sampleAnnouncement
+	digest.Kind = KindDigest
+	digest.Movies = []metadata.Movie{{Title: "first", Year: "2001", Thumbnail: "http://poster/1.jpg"}, {Title: "second"}}
+	payload, _ = Render(LayoutLegacy, nil, digest)
+	want = Payload{
+		Text: "2 new movies are now available on <https://app.plex.tv/web/index.html|Plex>",
+		Attachments: []Attachment{
+			{Title: "first (2001)", ThumbURL: "http://poster/1.jpg"},
+			{Title: "second"},
+		},
+	}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("Render() = %+v, want %+v", payload, want)
+	}
+	payload, _ = Render(LayoutBlocks, nil, digest)
+	want = Payload{
+		Text: "2 new movies are now available on Plex",
+		Blocks: []Block{
+			{Type: "header", Text: PlainText("New on Plex")},
+			{Type: "section", Text: Markdown("2 new movies are now available on <https://app.plex.tv/web/index.html|Plex>")},
+			{Type: "section", Text: Markdown("*first (2001)*"), Accessory: &Image{Type: "image", ImageURL: "http://poster/1.jpg", AltText: "first (2001)"}},
+			{Type: "section", Text: Markdown("*second*")},
+		},
+	}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("Render() = %+v, want %+v", payload, want)
+	}
+
+	for i := 0; i < 50; i++ {
+		digest.Movies = append(digest.Movies, metadata.Movie{Title: fmt.Sprint(i)})
+	}
+	payload, _ = Render(LayoutBlocks, nil, digest)
+	if len(payload.Blocks) > 50 {
+		t.Errorf("Render() = %d blocks, Slack accepts 50", len(payload.Blocks))
+	}
+	if last := payload.Blocks[len(payload.Blocks)-1]; !reflect.DeepEqual(last.Elements, []interface{}{Markdown("and 7 more")}) {
+		t.Errorf("Render() last block = %+v, want and 7 more", last)
+	}
+}
+
+func TestAnnouncement_PlexLink(t *testing.T) {
+	announcement := sampleAnnouncement
+	if got, want := announcement.PlexLink(), "https://app.plex.tv/web/index.html"; got != want {
+		t.Errorf("PlexLink() = %v, want %v", got, want)
+	}
+	announcement.PlexServer, announcement.PlexKey = "abc123", "34"
+	if got, want := announcement.PlexLink(), "https://app.plex.tv/web/index.html#!/server/abc123/details?key=%2Flibrary%2Fmetadata%2F34"; got != want {
+		t.Errorf("PlexLink() = %v, want %v", got, want)
+	}
+	announcement.PlexURL = ""
+	if got := announcement.PlexLink(); len(got) > 0 {
+		t.Errorf("PlexLink() = %v without plex_url", got)
+	}
+	if got, want := announcement.Intro(), "New movie is now available on Plex"; got != want {
+		t.Errorf("Intro() = %v, want %v", got, want)
+	}
+	announcement.Links = Links{}
+	payload, _ := Render(LayoutBlocks, nil, announcement)
+	for _, block := range payload.Blocks {
+		if block.Type == "actions" {
+			t.Errorf("Render() = %+v, want no buttons without plex_url and links", block)
+		}
+	}
+}
+
+func TestAnnouncement_Key(t *testing.T) {
+	announcement := Announcement{Library: "movies", Movie: metadata.Movie{Title: "Heat", Year: "1995"}}
+	if got, want := announcement.Key(), "movies/Heat (1995)"; got != want {
+		t.Errorf("Key() = %v, want %v", got, want)
+	}
+	announcement.Server, announcement.Folder = "office", "Heat.1995.1080p"
+	if got, want := announcement.Key(), "office/movies/Heat.1995.1080p"; got != want {
+		t.Errorf("Key() = %v, want %v", got, want)
+	}
+}
+
+func TestAnnouncement_PlexName(t *testing.T) {
+	announcement := sampleAnnouncement
+	if got := announcement.Intro(); !strings.HasSuffix(got, "|Plex>") {
+		t.Errorf("Intro() = %v, want a link to Plex", got)
+	}
+	announcement.Server = "office"
+	if got := announcement.Intro(); !strings.HasSuffix(got, "|Plex (office)>") {
+		t.Errorf("Intro() = %v, want a link to Plex (office)", got)
+	}
+}
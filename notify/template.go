@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// funcs are the functions available to templates in addition
+// to the builtin functions of text/template
+var funcs = template.FuncMap{
+	"join":  strings.Join,
+	"title": Title,
+	"json": func(v interface{}) (string, error) {
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	},
+}
+
+// Template is a user defined text/template rendered against an
+// Announcement. When its output is a JSON object, it is the whole
+// Slack message, otherwise it replaces the text opening the message.
+type Template struct {
+	tmpl *template.Template
+}
+
+// ParseTemplate parses text as template with the given name
+func ParseTemplate(name string, text string) (*Template, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// ParseTemplateFile parses the template kept in the file at path
+func ParseTemplateFile(path string) (*Template, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplate(path, string(raw))
+}
+
+// Execute renders announcement with the template. It returns the text
+// output, or the message when the output is a JSON object.
+func (t *Template) Execute(announcement Announcement) (string, *Payload, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, announcement); err != nil {
+		return "", nil, err
+	}
+	out := strings.TrimSpace(buf.String())
+	if !strings.HasPrefix(out, "{") {
+		return out, nil, nil
+	}
+	var payload Payload
+	if err := json.Unmarshal([]byte(out), &payload); err != nil {
+		return "", nil, fmt.Errorf("template %s rendered invalid JSON: %s", t.tmpl.Name(), err)
+	}
+	return "", &payload, nil
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var templateCases = []struct {
+	name         string
+	template     string
+	text         string
+	json         string
+	errorMessage string
+}{
+	{
+		name:     "case text template replaces the intro",
+		template: `{{.Library}}: *{{title .Movie}}* is here, {{join .Movie.Genres " / "}}{{with .Movie.Rating "IMDb"}} rated {{.Value}}{{end}}`,
+		text:     "movies: *test title (2018)* is here, Drama / Comedy rated 7.9/10",
+	},
+	{
+		name:     "case JSON template replaces the whole message",
+		template: `{"text": {{json (title .Movie)}}, "blocks": [{"type": "image", "image_url": {{json .Movie.Thumbnail}}, "alt_text": "poster"}]}`,
+		json:     `{"text":"test title (2018)","blocks":[{"type":"image","image_url":"https://image.tmdb.org/t/p/w92/poster/path","alt_text":"poster"}]}`,
+	},
+	{
+		name:         "case JSON template with invalid output",
+		template:     `{"text": {{.Movie.Title}}}`,
+		errorMessage: "rendered invalid JSON",
+	},
+	{
+		name:         "case template referencing unknown field",
+		template:     `{{.Movie.Director}}`,
+		errorMessage: "can't evaluate field Director",
+	},
+}
+
+func TestTemplate_Execute(t *testing.T) {
+	for _, tt := range templateCases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate("test", tt.template)
+			if err != nil {
+				t.Fatalf("ParseTemplate() unexpected error %v", err)
+			}
+			payload, err := Render(LayoutBlocks, tmpl, sampleAnnouncement)
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in Render() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.text) > 0 && !strings.HasPrefix(payload.Blocks[1].Text.Text, tt.text) {
+				t.Errorf("Render() section = %v, want %v", payload.Blocks[1].Text.Text, tt.text)
+			}
+			if len(tt.json) > 0 {
+				raw, _ := json.Marshal(payload)
+				if string(raw) != tt.json {
+					t.Errorf("Render() = %s, want %s", raw, tt.json)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultTimeout is the timeout of every request sent to Slack
+	defaultTimeout = time.Second * 10
+)
+
+// httpClient interface implements httpClient.Do function and intended to
+// make stubbing http.Client easier during unit testing.
+type httpClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Webhook represent a Slack incoming webhook
+type Webhook struct {
+	// URL is the address of the incoming webhook
+	URL string
+	// Client is an instance of httpClient interface
+	Client httpClient
+}
+
+// NewWebhook creates new instance of Webhook posting to URL through
+// transport, the default transport is used when it is nil
+func NewWebhook(URL string, transport http.RoundTripper) *Webhook {
+	return &Webhook{
+		URL: URL,
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   defaultTimeout,
+			// webhooks never redirect, a redirect means the URL is wrong
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return fmt.Errorf("Incorrect webhook URL (redirection)")
+			},
+		},
+	}
+}
+
+// Send posts payload to the webhook
+func (hook *Webhook) Send(ctx context.Context, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	res, err := hook.Client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := rateLimited(res); err != nil {
+		return err
+	}
+	if res.StatusCode >= 400 {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("Error sending msg. Status: %d %s", res.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// Deliver implements Destination, webhooks can't edit messages
+// so every message is posted as a new one
+func (hook *Webhook) Deliver(ctx context.Context, message Message) error {
+	return hook.Send(ctx, message.Payload)
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type httpClientStub struct {
+	res  *http.Response
+	err  error
+	body string
+}
+
+func (cl *httpClientStub) Do(req *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(req.Body)
+	cl.body = string(body)
+	return cl.res, cl.err
+}
+
+func generalSet(statusCode int, body string) *http.Response {
+	return &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		StatusCode: statusCode,
+		Header:     http.Header{},
+	}
+}
+
+func rateLimitedSet(retryAfter string) *http.Response {
+	res := generalSet(429, "rate_limited")
+	if len(retryAfter) > 0 {
+		res.Header.Set("Retry-After", retryAfter)
+	}
+	return res
+}
+
+var webhookCases = []struct {
+	name         string
+	clientStub   *httpClientStub
+	errorMessage string
+}{
+	{
+		name:       "case message accepted",
+		clientStub: &httpClientStub{res: generalSet(200, "ok")},
+	},
+	{
+		name:         "case message rejected",
+		clientStub:   &httpClientStub{res: generalSet(400, "invalid_blocks")},
+		errorMessage: "Status: 400 invalid_blocks",
+	},
+	{
+		name:         "case rate limited",
+		clientStub:   &httpClientStub{res: rateLimitedSet("30")},
+		errorMessage: "rate limited by Slack, retry after 30s",
+	},
+	{
+		name:         "case rate limited without retry after",
+		clientStub:   &httpClientStub{res: rateLimitedSet("")},
+		errorMessage: "rate limited by Slack, retry after 1s",
+	},
+	{
+		name:         "case failed contacting slack",
+		clientStub:   &httpClientStub{err: fmt.Errorf("Timeout reached")},
+		errorMessage: "Timeout reached",
+	},
+}
+
+func TestWebhook_Send(t *testing.T) {
+	for _, tt := range webhookCases {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := NewWebhook("https://hooks.slack.com/services/T/B/X", nil)
+			hook.Client = tt.clientStub
+			err := hook.Send(context.Background(), Payload{Text: "hello"})
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in Send() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Errorf("Send() expected error %v", tt.errorMessage)
+			}
+			if got, want := tt.clientStub.body, "{\"text\":\"hello\"}"; got != want {
+				t.Errorf("Send() body = %v, want %v", got, want)
+			}
+		})
+	}
+}
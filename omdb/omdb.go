@@ -0,0 +1,233 @@
+// Package omdb implements communication to The Open Movie Database API.
+// it provides a metadata provider that brings IMDb and Rotten Tomatoes
+// ratings along with the movie information
+package omdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+const (
+	// baseURL provides the endpoint for OMDb API
+	baseURL = "https://www.omdbapi.com/"
+	// defaultTimeout is the timeout applied to every request when no
+	// WithTimeout option is given
+	defaultTimeout = time.Second * 5
+	// notAvailable is the value OMDb uses for missing fields
+	notAvailable = "N/A"
+	// redacted replaces the API key whenever it shows up in an error
+	redacted = "REDACTED"
+)
+
+// httpClient interface implements httpClient.Do function and intended to
+// make stubbing http.Client easier during unit testing.
+type httpClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// OMDb represent an instance of OMDb API connection
+type OMDb struct {
+	// APIKey is required and can be requested on OMDb website
+	APIKey string
+	// Client is an instance of httpClient interface
+	Client httpClient
+
+	baseURL string
+}
+
+// settings holds the values collected from Option functions before
+// the http client of an OMDb instance is built.
+type settings struct {
+	baseURL   string
+	timeout   time.Duration
+	transport http.RoundTripper
+}
+
+// Option configures optional behaviour of an OMDb instance created by New.
+type Option func(*settings)
+
+// WithBaseURL points the client to a different API endpoint
+func WithBaseURL(URL string) Option {
+	return func(s *settings) {
+		s.baseURL = URL
+	}
+}
+
+// WithTimeout overrides the default timeout of every request sent to
+// OMDb API.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *settings) {
+		s.timeout = timeout
+	}
+}
+
+// WithTransport replaces the transport of the underlying http client.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(s *settings) {
+		s.transport = transport
+	}
+}
+
+// New requires OMDb API key and will return a new instance of OMDb API
+// connection with all of its default setting, modified by the given options.
+func New(APIKey string, options ...Option) *OMDb {
+	s := &settings{
+		baseURL: baseURL,
+		timeout: defaultTimeout,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return &OMDb{
+		APIKey: APIKey,
+		Client: &http.Client{
+			Timeout:   s.timeout,
+			Transport: s.transport,
+		},
+		baseURL: s.baseURL,
+	}
+}
+
+// rating represent an entry of the Ratings array in OMDb response
+type rating struct {
+	Source string `json:"Source"`
+	Value  string `json:"Value"`
+}
+
+// result represent the information of a movie we want to
+// extract from OMDb response.
+type result struct {
+	Title    string   `json:"Title"`
+	Year     string   `json:"Year"`
+	Plot     string   `json:"Plot"`
+	Rated    string   `json:"Rated"`
+	Genre    string   `json:"Genre"`
+	Poster   string   `json:"Poster"`
+	IMDbID   string   `json:"imdbID"`
+	Ratings  []rating `json:"Ratings"`
+	Response string   `json:"Response"`
+	Error    string   `json:"Error"`
+}
+
+// Name identifies OMDb as metadata provider
+func (db *OMDb) Name() string {
+	return "omdb"
+}
+
+// Lookup implements metadata.Provider by fetching the movie
+// described by query from OMDb, by its IMDb id when an earlier
+// provider found it and by title and year otherwise
+func (db *OMDb) Lookup(ctx context.Context, query metadata.Query) (*metadata.Movie, error) {
+	values := url.Values{}
+	values.Set("apikey", db.APIKey)
+	values.Set("type", "movie")
+	if len(query.IMDbID) > 0 {
+		values.Set("i", query.IMDbID)
+	} else {
+		values.Set("t", query.Title)
+		if len(query.Year) > 0 {
+			values.Set("y", query.Year)
+		}
+	}
+	var res result
+	if err := db.get(ctx, values, &res); err != nil {
+		return nil, err
+	}
+	if res.Response != "True" {
+		return nil, fmt.Errorf("Couldn't find %s (%s) in OMDb: %s", query.Title, query.Year, res.Error)
+	}
+	movie := &metadata.Movie{
+		Title:    res.Title,
+		Year:     res.Year,
+		Synopsis: available(res.Plot),
+		IMDbID:   available(res.IMDbID),
+	}
+	movie.Thumbnail = available(res.Poster)
+	movie.Certification = available(res.Rated)
+	for _, genre := range strings.Split(available(res.Genre), ",") {
+		if genre = strings.TrimSpace(genre); len(genre) > 0 {
+			movie.Genres = append(movie.Genres, genre)
+		}
+	}
+	for _, r := range res.Ratings {
+		source := r.Source
+		if source == "Internet Movie Database" {
+			source = "IMDb"
+		}
+		movie.Ratings = append(movie.Ratings, metadata.Rating{
+			Source: source,
+			Value:  r.Value,
+		})
+	}
+	return movie, nil
+}
+
+// get sends get request with the given query to OMDb API endpoint
+// and decodes the json body of the response into target.
+func (db *OMDb) get(ctx context.Context, query url.Values, target interface{}) error {
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s?%s", db.baseURL, query.Encode()), nil)
+	if err != nil {
+		return db.redact(err)
+	}
+	res, err := db.Client.Do(request.WithContext(ctx))
+	if err != nil {
+		return db.redact(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("HTTP response %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return db.redact(err)
+	}
+	return json.Unmarshal(body, target)
+}
+
+// redact removes the API key from the message of err, since errors coming
+// from the http client quote the full request URL. The errors err wraps
+// are kept, redacted as well.
+func (db *OMDb) redact(err error) error {
+	if err == nil || len(db.APIKey) == 0 || !strings.Contains(err.Error(), db.APIKey) {
+		return err
+	}
+	if e, ok := err.(*url.Error); ok {
+		return &url.Error{Op: e.Op, URL: strings.Replace(e.URL, db.APIKey, redacted, -1), Err: db.redact(e.Err)}
+	}
+	return &redactedError{message: strings.Replace(err.Error(), db.APIKey, redacted, -1), err: db.redact(errors.Unwrap(err))}
+}
+
+// redactedError is an error whose message had the API key removed, it
+// wraps the error the original one wrapped, redacted as well
+type redactedError struct {
+	message string
+	err     error
+}
+
+// Error implements error
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+// Unwrap returns the redacted error the original one wrapped
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// available returns value unless OMDb marked it as not available
+func available(value string) string {
+	if value == notAvailable {
+		return ""
+	}
+	return value
+}
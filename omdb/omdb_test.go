@@ -0,0 +1,123 @@
+package omdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+var (
+	jsonSuccess  = "{\"Title\":\"test title\",\"Year\":\"2018\",\"Plot\":\"test plot\",\"Rated\":\"PG-13\",\"Genre\":\"Drama, Comedy\",\"Poster\":\"N/A\",\"Ratings\":[{\"Source\":\"Internet Movie Database\",\"Value\":\"7.9/10\"},{\"Source\":\"Rotten Tomatoes\",\"Value\":\"91%\"}],\"Response\":\"True\"}"
+	jsonNotFound = "{\"Response\":\"False\",\"Error\":\"Movie not found!\"}"
+	resultInfo   = &metadata.Movie{
+		Title:         "test title",
+		Year:          "2018",
+		Synopsis:      "test plot",
+		Certification: "PG-13",
+		Genres:        []string{"Drama", "Comedy"},
+		Ratings: []metadata.Rating{
+			{Source: "IMDb", Value: "7.9/10"},
+			{Source: "Rotten Tomatoes", Value: "91%"},
+		},
+	}
+)
+
+func generalSet(statusCode int, body string) *http.Response {
+	return &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		StatusCode: statusCode,
+	}
+}
+
+type httpClientStub struct {
+	res *http.Response
+	err error
+	req *http.Request
+}
+
+func (cl *httpClientStub) Do(req *http.Request) (*http.Response, error) {
+	cl.req = req
+	return cl.res, cl.err
+}
+
+var cases = []struct {
+	name         string
+	result       *metadata.Movie
+	clientStub   *httpClientStub
+	errorMessage string
+}{
+	{
+		name:         "Case successful request with movie details",
+		result:       resultInfo,
+		clientStub:   &httpClientStub{res: generalSet(200, jsonSuccess)},
+		errorMessage: "",
+	},
+	{
+		name:         "Case failed request with 401 status code",
+		result:       nil,
+		clientStub:   &httpClientStub{res: generalSet(401, "")},
+		errorMessage: "HTTP response 401",
+	},
+	{
+		name:   "Case failed request contacting server",
+		result: nil,
+		clientStub: &httpClientStub{
+			err: &url.Error{Op: "Get", URL: "https://www.omdbapi.com/?apikey=1234567890", Err: fmt.Errorf("Timeout reached")},
+		},
+		errorMessage: "apikey=REDACTED",
+	},
+	{
+		name:         "case failed movie information not found",
+		result:       nil,
+		clientStub:   &httpClientStub{res: generalSet(200, jsonNotFound)},
+		errorMessage: "Movie not found!",
+	},
+}
+
+func TestOmdb_Lookup(t *testing.T) {
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			db := New("1234567890", WithBaseURL("http://localhost/"))
+			db.Client = tt.clientStub
+			info, err := db.Lookup(context.Background(), metadata.Query{Title: resultInfo.Title, Year: resultInfo.Year})
+			if got, want := tt.clientStub.req.URL.String(), "http://localhost/?apikey=1234567890&t=test+title&type=movie&y=2018"; got != want {
+				t.Errorf("URL = %v, want %v", got, want)
+			}
+			if _, ok := err.(*url.Error); len(tt.errorMessage) > 0 && tt.clientStub.err != nil && !ok {
+				t.Errorf("Error in Lookup() = %T, want *url.Error", err)
+			}
+			if err != nil {
+				got := err.Error()
+				want := tt.errorMessage
+				if len(want) == 0 || !strings.Contains(got, want) {
+					t.Errorf("Error in Lookup() = %v, want %v", got, want)
+				}
+			} else {
+				if !reflect.DeepEqual(info, tt.result) {
+					t.Errorf("Error in Lookup() = %v, want %v", info, tt.result)
+				}
+			}
+		})
+	}
+}
+
+func TestOmdb_LookupByIMDbID(t *testing.T) {
+	db := New("1234567890", WithBaseURL("http://localhost/"))
+	stub := &httpClientStub{res: generalSet(200, "{\"Title\":\"Heat\",\"Year\":\"1995\",\"imdbID\":\"tt0113277\",\"Response\":\"True\"}")}
+	db.Client = stub
+	info, err := db.Lookup(context.Background(), metadata.Query{Title: "Heat", Year: "1986", IMDbID: "tt0113277"})
+	if got, want := stub.req.URL.String(), "http://localhost/?apikey=1234567890&i=tt0113277&type=movie"; got != want {
+		t.Errorf("URL = %v, want %v", got, want)
+	}
+	if err != nil || info.IMDbID != "tt0113277" || info.Year != "1995" {
+		t.Errorf("Lookup() = %+v, %v, want Heat (1995) tt0113277", info, err)
+	}
+}
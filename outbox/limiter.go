@@ -0,0 +1,67 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Rate is how many messages per second are sent to a destination,
+// Burst messages can be sent at once after it was idle
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// limiter is a token bucket holding up to Burst tokens, refilled at
+// PerSecond tokens every second. Every message sent takes a token.
+type limiter struct {
+	rate   Rate
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter creates a limiter with a full bucket, a rate that is not
+// positive doesn't limit anything
+func newLimiter(rate Rate) *limiter {
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	return &limiter{
+		rate:   rate,
+		tokens: float64(rate.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait takes a token, waiting for the bucket to be refilled when it is
+// empty. It returns the error of ctx when ctx is done before.
+func (l *limiter) Wait(ctx context.Context) error {
+	if l.rate.PerSecond <= 0 {
+		return ctx.Err()
+	}
+	for {
+		l.mutex.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate.PerSecond
+		if l.tokens > float64(l.rate.Burst) {
+			l.tokens = float64(l.rate.Burst)
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mutex.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate.PerSecond * float64(time.Second))
+		l.mutex.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Wait(t *testing.T) {
+	l := newLimiter(Rate{PerSecond: 50, Burst: 2})
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() unexpected error %v", err)
+		}
+	}
+	// the burst goes through at once, the next two wait 20ms each
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("Wait() took %v for 4 tokens, want at least 40ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := newLimiter(Rate{PerSecond: 0.001, Burst: 1})
+	slow.Wait(ctx)
+	if err := slow.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait() = %v, want %v", err, context.Canceled)
+	}
+}
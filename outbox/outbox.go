@@ -0,0 +1,475 @@
+// Package outbox keeps the messages waiting to be delivered to Slack
+// in a persistent store. Every message is written to the store before
+// it is delivered, the ones a destination doesn't accept are retried
+// with backoff until they expire and are kept as dead letters, which
+// can be listed and replayed later. Messages are sent to a destination
+// one at a time in the order they were created, no faster than the
+// rate of the destination.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/notify"
+)
+
+const (
+	// pendingBucket is the bucket of store holding the messages
+	// waiting to be delivered
+	pendingBucket = "outbox"
+	// deadBucket is the bucket of store holding the messages that
+	// expired before they could be delivered
+	deadBucket = "dead"
+	// defaultMinBackoff is the wait before the first retry when no
+	// WithBackoff option is given, it doubles on every retry
+	defaultMinBackoff = time.Second * 30
+	// defaultMaxBackoff is the longest wait between two retries when
+	// no WithBackoff option is given
+	defaultMaxBackoff = time.Minute * 30
+	// defaultExpiry is how long a message is retried when no
+	// WithExpiry option is given
+	defaultExpiry = time.Hour * 24
+	// idleWait is the longest Run sleeps, so that messages written to
+	// the store by another process are picked up
+	idleWait = time.Minute
+	// defaultRate is the rate of destinations when no WithRate option
+	// is given, Slack accepts a message per second
+	defaultRate = 1
+)
+
+// Store keeps the messages of outbox, store.Store implements it
+type Store interface {
+	Get(bucket string, key string, value interface{}) (bool, error)
+	Put(bucket string, key string, value interface{}) error
+	Delete(bucket string, key string) error
+	Keys(bucket string) []string
+}
+
+// Entry is a message kept in the outbox along with its delivery
+// attempts
+type Entry struct {
+	Key      string
+	Message  notify.Message
+	Attempts int
+	// First is when delivering the message was first attempted, it
+	// expires from then so that messages held for later get retried
+	First time.Time
+	// Next is when the message is delivered again
+	Next time.Time
+	// Error is why the last attempt failed
+	Error string
+}
+
+// Outbox delivers messages to their destination
+type Outbox struct {
+	store        Store
+	destinations map[string]notify.Destination
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	expiry       time.Duration
+	now          func() time.Time
+	wake         chan struct{}
+	// mutex makes reading and writing an entry in store atomic,
+	// deliveries happen without holding it
+	mutex sync.Mutex
+
+	// senders guards limiters, busy holding the destinations whose
+	// sender is running and paused holding until when Slack asked
+	// not to send anything to a destination
+	senders  sync.Mutex
+	limiters map[string]*limiter
+	busy     map[string]bool
+	paused   map[string]time.Time
+	running  sync.WaitGroup
+}
+
+// settings holds the optional configuration applied by Option
+type settings struct {
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	expiry     time.Duration
+	rate       Rate
+	rates      map[string]Rate
+}
+
+// Option configures optional behaviour of an Outbox created by New.
+type Option func(*settings)
+
+// WithBackoff sets the wait before the first retry, which doubles on
+// every retry up to max.
+func WithBackoff(min, max time.Duration) Option {
+	return func(s *settings) {
+		s.minBackoff = min
+		s.maxBackoff = max
+	}
+}
+
+// WithExpiry sets how long a message is retried before it is kept
+// as a dead letter.
+func WithExpiry(expiry time.Duration) Option {
+	return func(s *settings) {
+		s.expiry = expiry
+	}
+}
+
+// WithRate sets the rate of every destination that has no rate of
+// its own.
+func WithRate(rate Rate) Option {
+	return func(s *settings) {
+		s.rate = rate
+	}
+}
+
+// WithDestinationRate sets the rate of destination.
+func WithDestinationRate(destination string, rate Rate) Option {
+	return func(s *settings) {
+		s.rates[destination] = rate
+	}
+}
+
+// New creates an Outbox keeping its messages in store and delivering
+// them to destinations by name
+func New(store Store, destinations map[string]notify.Destination, options ...Option) *Outbox {
+	s := &settings{
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		expiry:     defaultExpiry,
+		rate:       Rate{PerSecond: defaultRate, Burst: 1},
+		rates:      map[string]Rate{},
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.maxBackoff < s.minBackoff {
+		s.maxBackoff = s.minBackoff
+	}
+	limiters := map[string]*limiter{}
+	for name := range destinations {
+		rate, ok := s.rates[name]
+		if !ok {
+			rate = s.rate
+		}
+		limiters[name] = newLimiter(rate)
+	}
+	return &Outbox{
+		store:        store,
+		destinations: destinations,
+		minBackoff:   s.minBackoff,
+		maxBackoff:   s.maxBackoff,
+		expiry:       s.expiry,
+		now:          time.Now,
+		wake:         make(chan struct{}, 1),
+		limiters:     limiters,
+		busy:         map[string]bool{},
+		paused:       map[string]time.Time{},
+	}
+}
+
+// Key identifies message in the outbox. A message replaces the one
+// waiting with the same key, so that only the latest message about
+// an item is delivered to a destination.
+func Key(message notify.Message) string {
+	return message.Destination + "/" + message.Item
+}
+
+// Enqueue writes message to the store, it is delivered by Run
+func (outbox *Outbox) Enqueue(message notify.Message) error {
+	return outbox.EnqueueAt(message, outbox.now())
+}
+
+// EnqueueAt writes message to the store, it is delivered by Run once
+// at has come
+func (outbox *Outbox) EnqueueAt(message notify.Message, at time.Time) error {
+	key := Key(message)
+	outbox.mutex.Lock()
+	err := outbox.store.Put(pendingBucket, key, Entry{Key: key, Message: message, Next: at})
+	if err == nil {
+		// a newer message supersedes the one that expired
+		err = outbox.store.Delete(deadBucket, key)
+	}
+	outbox.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	outbox.signal()
+	return nil
+}
+
+// signal wakes Run up
+func (outbox *Outbox) signal() {
+	select {
+	case outbox.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers the messages of the outbox as they are due until ctx
+// is cancelled
+func (outbox *Outbox) Run(ctx context.Context) {
+	for {
+		outbox.dispatch(ctx)
+		wait := idleWait
+		if next := outbox.due(); !next.IsZero() && next.Sub(outbox.now()) < wait {
+			wait = next.Sub(outbox.now())
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-outbox.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Flush delivers every message that is due and returns when the next
+// one is due, or zero time when there is none left
+func (outbox *Outbox) Flush(ctx context.Context) time.Time {
+	outbox.dispatch(ctx)
+	outbox.running.Wait()
+	return outbox.due()
+}
+
+// dispatch starts a sender for every destination having messages due
+// unless its sender is already running. The messages following one
+// waiting for a retry wait for it.
+func (outbox *Outbox) dispatch(ctx context.Context) {
+	now := outbox.now()
+	queues := map[string][]string{}
+	retrying := map[string]bool{}
+	outbox.senders.Lock()
+	defer outbox.senders.Unlock()
+	for _, entry := range outbox.entries(pendingBucket) {
+		destination := entry.Message.Destination
+		if outbox.busy[destination] || outbox.paused[destination].After(now) || retrying[destination] {
+			continue
+		}
+		if entry.Next.After(now) {
+			retrying[destination] = entry.Attempts > 0
+			continue
+		}
+		queues[destination] = append(queues[destination], entry.Key)
+	}
+	for destination, keys := range queues {
+		outbox.busy[destination] = true
+		outbox.running.Add(1)
+		go outbox.send(ctx, destination, keys)
+	}
+}
+
+// due returns when the next message is due, or zero time when there
+// is none left. Messages of destinations whose sender is running are
+// left out, Run is woken up when the sender is done.
+func (outbox *Outbox) due() time.Time {
+	outbox.senders.Lock()
+	defer outbox.senders.Unlock()
+	var next time.Time
+	retrying := map[string]bool{}
+	for _, entry := range outbox.entries(pendingBucket) {
+		destination := entry.Message.Destination
+		if outbox.busy[destination] || retrying[destination] {
+			continue
+		}
+		retrying[destination] = entry.Attempts > 0
+		at := entry.Next
+		if paused := outbox.paused[destination]; paused.After(at) {
+			at = paused
+		}
+		next = earliest(next, at)
+	}
+	return next
+}
+
+// send delivers the messages of keys to destination one at a time in
+// their order, as fast as the rate of destination allows. It stops
+// when Slack asks to slow down, the messages left are sent once the
+// wait Slack asked for has passed. It stops as well when a message
+// fails, the messages left are sent once it is delivered or expired.
+func (outbox *Outbox) send(ctx context.Context, destination string, keys []string) {
+	defer func() {
+		outbox.senders.Lock()
+		delete(outbox.busy, destination)
+		outbox.senders.Unlock()
+		outbox.running.Done()
+		outbox.signal()
+	}()
+	for _, key := range keys {
+		// the message might have been delivered by a replay or
+		// replaced by a newer one since it was dispatched
+		var entry Entry
+		found, err := outbox.store.Get(pendingBucket, key, &entry)
+		if err != nil {
+			log.Println("error:", err)
+			continue
+		}
+		if !found || entry.Next.After(outbox.now()) {
+			continue
+		}
+		if err := outbox.limiter(destination).Wait(ctx); err != nil {
+			return
+		}
+		if entry.First.IsZero() {
+			entry.First = outbox.now()
+		}
+		failure := outbox.deliver(ctx, entry.Message)
+		if ctx.Err() != nil {
+			// shutting down, the message is delivered again on start
+			return
+		}
+		if limited, ok := failure.(*notify.RateLimitError); ok {
+			until := outbox.now().Add(limited.RetryAfter)
+			outbox.senders.Lock()
+			outbox.paused[destination] = until
+			outbox.senders.Unlock()
+			entry.Error = failure.Error()
+			entry.Next = until
+			if err := outbox.settle(entry, failure); err != nil {
+				log.Println("error:", err)
+			}
+			return
+		}
+		if failure != nil {
+			entry.Attempts++
+			entry.Error = failure.Error()
+			entry.Next = outbox.now().Add(outbox.backoff(entry.Attempts))
+		}
+		if err := outbox.settle(entry, failure); err != nil {
+			log.Println("error:", err)
+		}
+		if failure != nil {
+			return
+		}
+	}
+}
+
+// limiter returns the limiter of destination
+func (outbox *Outbox) limiter(destination string) *limiter {
+	outbox.senders.Lock()
+	defer outbox.senders.Unlock()
+	l, ok := outbox.limiters[destination]
+	if !ok {
+		// destinations that are not configured fail right away
+		l = newLimiter(Rate{})
+		outbox.limiters[destination] = l
+	}
+	return l
+}
+
+// earliest returns the earliest of next and due, next is zero when
+// nothing is due yet
+func earliest(next time.Time, due time.Time) time.Time {
+	if next.IsZero() || due.Before(next) {
+		return due
+	}
+	return next
+}
+
+// deliver hands message to its destination
+func (outbox *Outbox) deliver(ctx context.Context, message notify.Message) error {
+	destination, ok := outbox.destinations[message.Destination]
+	if !ok {
+		return fmt.Errorf("unknown destination %q", message.Destination)
+	}
+	return destination.Deliver(ctx, message)
+}
+
+// settle records the outcome of delivering entry. It is left alone
+// when a newer message replaced it while it was being delivered.
+func (outbox *Outbox) settle(entry Entry, failure error) error {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	var current Entry
+	found, err := outbox.store.Get(pendingBucket, entry.Key, &current)
+	if err != nil {
+		return err
+	}
+	if !found || !current.Message.Created.Equal(entry.Message.Created) {
+		return nil
+	}
+	if failure == nil {
+		return outbox.store.Delete(pendingBucket, entry.Key)
+	}
+	first := entry.First
+	if first.IsZero() {
+		first = entry.Message.Created
+	}
+	if outbox.now().Sub(first) < outbox.expiry {
+		log.Printf("warning: delivering %s failed, attempt %d: %s\n", entry.Key, entry.Attempts, failure)
+		return outbox.store.Put(pendingBucket, entry.Key, entry)
+	}
+	log.Printf("error: giving up delivering %s after %d attempts: %s\n", entry.Key, entry.Attempts, failure)
+	if err := outbox.store.Put(deadBucket, entry.Key, entry); err != nil {
+		return err
+	}
+	return outbox.store.Delete(pendingBucket, entry.Key)
+}
+
+// backoff returns the wait before the given retry
+func (outbox *Outbox) backoff(attempts int) time.Duration {
+	wait := outbox.minBackoff
+	for i := 1; i < attempts && wait < outbox.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > outbox.maxBackoff {
+		wait = outbox.maxBackoff
+	}
+	return wait
+}
+
+// Pending returns the messages waiting to be delivered, oldest first
+func (outbox *Outbox) Pending() []Entry {
+	return outbox.entries(pendingBucket)
+}
+
+// Dead returns the messages that expired before they could be
+// delivered, oldest first
+func (outbox *Outbox) Dead() []Entry {
+	return outbox.entries(deadBucket)
+}
+
+// Replay delivers the dead letter of key right away, it is removed
+// once the destination accepts it
+func (outbox *Outbox) Replay(ctx context.Context, key string) error {
+	var entry Entry
+	found, err := outbox.store.Get(deadBucket, key, &entry)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no dead letter %q", key)
+	}
+	if err := outbox.limiter(entry.Message.Destination).Wait(ctx); err != nil {
+		return err
+	}
+	if err := outbox.deliver(ctx, entry.Message); err != nil {
+		return err
+	}
+	return outbox.store.Delete(deadBucket, key)
+}
+
+// entries returns the entries of bucket sorted by creation time
+func (outbox *Outbox) entries(bucket string) []Entry {
+	var entries []Entry
+	for _, key := range outbox.store.Keys(bucket) {
+		var entry Entry
+		found, err := outbox.store.Get(bucket, key, &entry)
+		if err != nil {
+			log.Printf("error: reading %s from outbox: %s\n", key, err)
+			continue
+		}
+		if found {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Message.Created.Before(entries[j].Message.Created)
+	})
+	return entries
+}
@@ -0,0 +1,232 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+// destinationStub fails the first failures deliveries, rate limits
+// the next limited ones and records the text of the messages it
+// accepts
+type destinationStub struct {
+	mutex     sync.Mutex
+	failures  int
+	limited   int
+	delivered []string
+}
+
+func (stub *destinationStub) Deliver(ctx context.Context, message notify.Message) error {
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	if stub.failures > 0 {
+		stub.failures--
+		return fmt.Errorf("Error sending msg. Status: 500")
+	}
+	if stub.limited > 0 {
+		stub.limited--
+		return &notify.RateLimitError{RetryAfter: 30 * time.Second}
+	}
+	stub.delivered = append(stub.delivered, message.Payload.Text)
+	return nil
+}
+
+// clock is a fake time source moved forward by tests
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newOutbox(stub *destinationStub, c *clock) *Outbox {
+	outbox := New(store.Memory(), map[string]notify.Destination{"general": stub},
+		WithBackoff(time.Minute, 4*time.Minute), WithExpiry(time.Hour), WithRate(Rate{}))
+	outbox.now = c.Now
+	return outbox
+}
+
+func message(item string, text string, created time.Time) notify.Message {
+	return notify.Message{
+		Destination: "general",
+		Item:        item,
+		Kind:        notify.KindNew,
+		Payload:     notify.Payload{Text: text},
+		Created:     created,
+	}
+}
+
+func TestOutbox_Retry(t *testing.T) {
+	c := &clock{now: time.Date(2019, 5, 1, 20, 0, 0, 0, time.UTC)}
+	stub := &destinationStub{failures: 2}
+	outbox := newOutbox(stub, c)
+	outbox.Enqueue(message("movies/a", "a", c.now))
+
+	next := outbox.Flush(context.Background())
+	if want := c.now.Add(time.Minute); !next.Equal(want) {
+		t.Errorf("Flush() = %v, want %v", next, want)
+	}
+	c.now = next
+	next = outbox.Flush(context.Background())
+	if want := c.now.Add(2 * time.Minute); !next.Equal(want) {
+		t.Errorf("Flush() = %v, want %v", next, want)
+	}
+	if len(stub.delivered) != 0 {
+		t.Fatalf("delivered %v before retry is due", stub.delivered)
+	}
+	c.now = next
+	outbox.Flush(context.Background())
+	if want := []string{"a"}; !reflect.DeepEqual(stub.delivered, want) {
+		t.Errorf("delivered %v, want %v", stub.delivered, want)
+	}
+	if pending := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("Pending() = %v, want none", pending)
+	}
+}
+
+func TestOutbox_Dedupe(t *testing.T) {
+	c := &clock{now: time.Date(2019, 5, 1, 20, 0, 0, 0, time.UTC)}
+	stub := &destinationStub{}
+	outbox := newOutbox(stub, c)
+	outbox.Enqueue(message("movies/b", "b", c.now))
+	outbox.Enqueue(message("movies/a", "a", c.now.Add(time.Second)))
+	outbox.Enqueue(message("movies/a", "a upgraded", c.now.Add(2*time.Second)))
+	outbox.Flush(context.Background())
+	if want := []string{"b", "a upgraded"}; !reflect.DeepEqual(stub.delivered, want) {
+		t.Errorf("delivered %v, want %v", stub.delivered, want)
+	}
+}
+
+func TestOutbox_RetryKeepsOrder(t *testing.T) {
+	c := &clock{now: time.Date(2019, 5, 1, 20, 0, 0, 0, time.UTC)}
+	stub := &destinationStub{failures: 1}
+	outbox := newOutbox(stub, c)
+	outbox.Enqueue(message("movies/a", "a", c.now))
+	outbox.Enqueue(message("movies/b", "b", c.now.Add(time.Second)))
+
+	next := outbox.Flush(context.Background())
+	if want := c.now.Add(time.Minute); !next.Equal(want) || len(stub.delivered) != 0 {
+		t.Fatalf("Flush() = %v delivering %v, want %v delivering nothing", next, stub.delivered, want)
+	}
+	c.now = next
+	outbox.Flush(context.Background())
+	if want := []string{"a", "b"}; !reflect.DeepEqual(stub.delivered, want) {
+		t.Errorf("delivered %v, want %v", stub.delivered, want)
+	}
+}
+
+func TestOutbox_DeadLetter(t *testing.T) {
+	c := &clock{now: time.Date(2019, 5, 1, 20, 0, 0, 0, time.UTC)}
+	stub := &destinationStub{failures: 100}
+	outbox := newOutbox(stub, c)
+	outbox.Enqueue(message("movies/a", "a", c.now))
+	for next := outbox.Flush(context.Background()); !next.IsZero(); next = outbox.Flush(context.Background()) {
+		c.now = next
+	}
+	dead := outbox.Dead()
+	if len(dead) != 1 || dead[0].Key != "general/movies/a" {
+		t.Fatalf("Dead() = %v, want general/movies/a", dead)
+	}
+	if dead[0].Attempts < 2 || dead[0].Error != "Error sending msg. Status: 500" {
+		t.Errorf("Dead() = %+v, want attempts and last error", dead[0])
+	}
+
+	if err := outbox.Replay(context.Background(), "general/movies/a"); err == nil {
+		t.Error("Replay() expected an error while destination fails")
+	}
+	stub.failures = 0
+	if err := outbox.Replay(context.Background(), "general/movies/a"); err != nil {
+		t.Fatalf("Replay() unexpected error %v", err)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(stub.delivered, want) {
+		t.Errorf("delivered %v, want %v", stub.delivered, want)
+	}
+	if dead := outbox.Dead(); len(dead) != 0 {
+		t.Errorf("Dead() = %v, want none after replay", dead)
+	}
+	if err := outbox.Replay(context.Background(), "general/movies/a"); err == nil {
+		t.Error("Replay() expected an error for missing dead letter")
+	}
+}
+
+func TestOutbox_UnknownDestination(t *testing.T) {
+	c := &clock{now: time.Date(2019, 5, 1, 20, 0, 0, 0, time.UTC)}
+	outbox := newOutbox(&destinationStub{}, c)
+	m := message("movies/a", "a", c.now)
+	m.Destination = "removed-from-config"
+	outbox.Enqueue(m)
+	outbox.Flush(context.Background())
+	pending := outbox.Pending()
+	if len(pending) != 1 || pending[0].Error != `unknown destination "removed-from-config"` {
+		t.Errorf("Pending() = %+v, want unknown destination error", pending)
+	}
+}
+
+func TestOutbox_EnqueueAt(t *testing.T) {
+	c := &clock{now: time.Date(2019, 5, 1, 23, 0, 0, 0, time.UTC)}
+	stub := &destinationStub{}
+	outbox := newOutbox(stub, c)
+	morning := time.Date(2019, 5, 2, 7, 0, 0, 0, time.UTC)
+	outbox.EnqueueAt(message("movies/a", "a", c.now), morning)
+	if next := outbox.Flush(context.Background()); !next.Equal(morning) || len(stub.delivered) != 0 {
+		t.Fatalf("Flush() = %v delivering %v, want %v delivering nothing", next, stub.delivered, morning)
+	}
+	c.now = morning
+	outbox.Flush(context.Background())
+	if want := []string{"a"}; !reflect.DeepEqual(stub.delivered, want) {
+		t.Errorf("delivered %v, want %v", stub.delivered, want)
+	}
+}
+
+func TestOutbox_ExpiryFromFirstAttempt(t *testing.T) {
+	c := &clock{now: time.Date(2019, 5, 1, 23, 0, 0, 0, time.UTC)}
+	stub := &destinationStub{failures: 1}
+	outbox := newOutbox(stub, c)
+	// held through quiet hours longer than the expiry
+	morning := time.Date(2019, 5, 2, 7, 0, 0, 0, time.UTC)
+	outbox.EnqueueAt(message("movies/a", "a", c.now), morning)
+	c.now = morning
+	next := outbox.Flush(context.Background())
+	if dead := outbox.Dead(); len(dead) != 0 {
+		t.Fatalf("Dead() = %v after the first attempt, want none", dead)
+	}
+	c.now = next
+	outbox.Flush(context.Background())
+	if want := []string{"a"}; !reflect.DeepEqual(stub.delivered, want) {
+		t.Errorf("delivered %v, want %v", stub.delivered, want)
+	}
+}
+
+func TestOutbox_RateLimited(t *testing.T) {
+	c := &clock{now: time.Date(2019, 5, 1, 20, 0, 0, 0, time.UTC)}
+	stub := &destinationStub{limited: 1}
+	outbox := newOutbox(stub, c)
+	outbox.Enqueue(message("movies/a", "a", c.now))
+	outbox.Enqueue(message("movies/b", "b", c.now.Add(time.Second)))
+	outbox.Enqueue(message("movies/c", "c", c.now.Add(2*time.Second)))
+
+	next := outbox.Flush(context.Background())
+	if want := c.now.Add(30 * time.Second); !next.Equal(want) || len(stub.delivered) != 0 {
+		t.Fatalf("Flush() = %v delivering %v, want %v delivering nothing", next, stub.delivered, want)
+	}
+	c.now = c.now.Add(10 * time.Second)
+	outbox.Flush(context.Background())
+	if len(stub.delivered) != 0 {
+		t.Fatalf("delivered %v before Retry-After passed", stub.delivered)
+	}
+	c.now = next
+	outbox.Flush(context.Background())
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(stub.delivered, want) {
+		t.Errorf("delivered %v, want %v", stub.delivered, want)
+	}
+	if pending := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("Pending() = %v, want none", pending)
+	}
+}
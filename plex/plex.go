@@ -0,0 +1,460 @@
+// Package plex implements communication to the HTTP API of Plex Media
+// Server. It asks the server to scan the folders of its libraries and
+// finds the movies the server indexed so that announcements can link
+// straight to them.
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+const (
+	// defaultTimeout is the timeout applied to every request when no
+	// WithTimeout option is given
+	defaultTimeout = time.Second * 10
+	// movieType is the type of movies in Plex library filters
+	movieType = "1"
+	// recentSize is how many of the items recently added are listed
+	recentSize = "100"
+)
+
+// httpClient interface implements httpClient.Do function and intended to
+// make stubbing http.Client easier during unit testing.
+type httpClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Plex represent a connection to the HTTP API of Plex Media Server
+type Plex struct {
+	// BaseURL is the address of the server, such as
+	// http://127.0.0.1:32400
+	BaseURL string
+	// Token is the X-Plex-Token authenticating every request
+	Token string
+	// Client is an instance of httpClient interface
+	Client httpClient
+}
+
+// settings holds the values collected from Option functions before
+// the http client of a Plex instance is built.
+type settings struct {
+	timeout   time.Duration
+	transport http.RoundTripper
+}
+
+// Option configures optional behaviour of a Plex instance created by New.
+type Option func(*settings)
+
+// WithTimeout overrides the default timeout of every request sent to
+// the server.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *settings) {
+		s.timeout = timeout
+	}
+}
+
+// WithTransport replaces the transport of the underlying http client.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(s *settings) {
+		s.transport = transport
+	}
+}
+
+// New returns a connection to the server at baseURL authenticated
+// with token, with all of its default setting modified by the given
+// options.
+func New(baseURL string, token string, options ...Option) *Plex {
+	s := &settings{
+		timeout: defaultTimeout,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return &Plex{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		Client: &http.Client{
+			Timeout:   s.timeout,
+			Transport: s.transport,
+		},
+	}
+}
+
+// Part is a file of a movie
+type Part struct {
+	File string `json:"file"`
+}
+
+// Media is a version of a movie, made of one or more files
+type Media struct {
+	Parts []Part `json:"Part"`
+}
+
+// Tag is an entry of a list such as the genres of a movie
+type Tag struct {
+	Tag string `json:"tag"`
+}
+
+// GUID is an identifier of an item in an external database, such as
+// imdb://tt0113277 or tmdb://949
+type GUID struct {
+	ID string `json:"id"`
+}
+
+// Item is a movie indexed by Plex. AddedAt is when it was added in
+// seconds since epoch and GUID is the identifier given by the agent
+// that matched it, legacy agents put the IMDb or TMDb id in it.
+type Item struct {
+	RatingKey     string  `json:"ratingKey"`
+	Type          string  `json:"type"`
+	Title         string  `json:"title"`
+	Year          int     `json:"year"`
+	Summary       string  `json:"summary"`
+	ContentRating string  `json:"contentRating"`
+	AddedAt       int64   `json:"addedAt"`
+	SectionID     int     `json:"librarySectionID"`
+	SectionTitle  string  `json:"librarySectionTitle"`
+	GUID          string  `json:"guid"`
+	GUIDs         []GUID  `json:"Guid"`
+	Genres        []Tag   `json:"Genre"`
+	Media         []Media `json:"Media"`
+}
+
+// agents maps the scheme of legacy agent identifiers to the scheme
+// of the database they come from
+var agents = map[string]string{
+	"com.plexapp.agents.imdb":       "imdb",
+	"com.plexapp.agents.themoviedb": "tmdb",
+}
+
+// ID returns the identifier of item in the database named scheme,
+// imdb or tmdb, or an empty string when Plex doesn't know it
+func (item Item) ID(scheme string) string {
+	guids := append([]GUID{{ID: item.GUID}}, item.GUIDs...)
+	for _, guid := range guids {
+		parts := strings.SplitN(guid.ID, "://", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if agent, ok := agents[parts[0]]; ok {
+			parts[0] = agent
+		}
+		if parts[0] == scheme {
+			return strings.SplitN(parts[1], "?", 2)[0]
+		}
+	}
+	return ""
+}
+
+// Pending tells whether the agent of the library didn't match item
+// yet, Plex knows none of its TMDb and IMDb ids right after indexing
+func (item Item) Pending() bool {
+	return len(item.ID("tmdb")) == 0 && len(item.ID("imdb")) == 0
+}
+
+// Matched tells whether Plex matched item to the movie having tmdbID
+// or, when they can't be compared, imdbID. An item that has none of
+// these ids is not matched, see Pending.
+func (item Item) Matched(tmdbID string, imdbID string) bool {
+	tmdb, imdb := item.ID("tmdb"), item.ID("imdb")
+	switch {
+	case len(tmdb) > 0 && len(tmdbID) > 0:
+		return tmdb == tmdbID
+	case len(imdb) > 0 && len(imdbID) > 0:
+		return imdb == imdbID
+	}
+	return len(tmdb) > 0 || len(imdb) > 0
+}
+
+// Movie returns the information of the movie Plex matched item with
+func (item Item) Movie() metadata.Movie {
+	movie := metadata.Movie{
+		Title:         item.Title,
+		Synopsis:      item.Summary,
+		Certification: item.ContentRating,
+		TMDbID:        item.ID("tmdb"),
+		IMDbID:        item.ID("imdb"),
+	}
+	if item.Year > 0 {
+		movie.Year = strconv.Itoa(item.Year)
+	}
+	for _, genre := range item.Genres {
+		movie.Genres = append(movie.Genres, genre.Tag)
+	}
+	return movie
+}
+
+// Files returns the path of every file of item
+func (item Item) Files() []string {
+	var files []string
+	for _, media := range item.Media {
+		for _, part := range media.Parts {
+			files = append(files, part.File)
+		}
+	}
+	return files
+}
+
+// Location is a folder of a library
+type Location struct {
+	Path string `json:"path"`
+}
+
+// Section is a library of the server, Key is its section number
+type Section struct {
+	Key       string     `json:"key"`
+	Type      string     `json:"type"`
+	Title     string     `json:"title"`
+	Locations []Location `json:"Location"`
+}
+
+// Match is a movie an item can be matched to, GUID identifies it for
+// the agent of the library
+type Match struct {
+	GUID string `json:"guid"`
+	Name string `json:"name"`
+	Year int    `json:"year"`
+}
+
+// container represent the fields of API responses we need
+type container struct {
+	MediaContainer struct {
+		MachineIdentifier string    `json:"machineIdentifier"`
+		Metadata          []Item    `json:"Metadata"`
+		Directory         []Section `json:"Directory"`
+		SearchResult      []Match   `json:"SearchResult"`
+	} `json:"MediaContainer"`
+}
+
+// MachineIdentifier returns the identifier of the server, the web app
+// uses it to tell servers apart in its links
+func (plex *Plex) MachineIdentifier(ctx context.Context) (string, error) {
+	var res container
+	if err := plex.get(ctx, "/identity", nil, &res); err != nil {
+		return "", err
+	}
+	if len(res.MediaContainer.MachineIdentifier) == 0 {
+		return "", fmt.Errorf("plex: /identity: no machine identifier")
+	}
+	return res.MediaContainer.MachineIdentifier, nil
+}
+
+// Sections lists the libraries of the server along with their folders
+func (plex *Plex) Sections(ctx context.Context) ([]Section, error) {
+	var res container
+	if err := plex.get(ctx, "/library/sections", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.MediaContainer.Directory, nil
+}
+
+// RecentlyAdded lists the items recently added to the libraries of the
+// server, latest first
+func (plex *Plex) RecentlyAdded(ctx context.Context) ([]Item, error) {
+	query := url.Values{}
+	query.Set("includeGuids", "1")
+	query.Set("X-Plex-Container-Start", "0")
+	query.Set("X-Plex-Container-Size", recentSize)
+	var res container
+	if err := plex.get(ctx, "/library/recentlyAdded", query, &res); err != nil {
+		return nil, err
+	}
+	return res.MediaContainer.Metadata, nil
+}
+
+// Find looks up the movie of section stored in folder. Movies listed
+// under title are matched by the path of their files, the movies of
+// year next as Plex may know the movie under another title. Title and
+// year only match when the path is unknown, another copy of the movie
+// would confirm a file Plex didn't index. It returns nil when the
+// movie is not indexed yet.
+func (plex *Plex) Find(ctx context.Context, section int, folder string, title string, year string) (*Item, error) {
+	items, err := plex.movies(ctx, section, "title", title)
+	if err != nil {
+		return nil, err
+	}
+	if item := byFolder(items, folder); item != nil {
+		return item, nil
+	}
+	for i, item := range items {
+		if len(folder) > 0 && len(item.Files()) > 0 {
+			continue
+		}
+		if strings.EqualFold(item.Title, title) && (len(year) == 0 || strconv.Itoa(item.Year) == year) {
+			return &items[i], nil
+		}
+	}
+	if len(year) == 0 || len(folder) == 0 {
+		return nil, nil
+	}
+	items, err = plex.movies(ctx, section, "year", year)
+	if err != nil {
+		return nil, err
+	}
+	return byFolder(items, folder), nil
+}
+
+// Metadata returns the item keyed ratingKey
+func (plex *Plex) Metadata(ctx context.Context, ratingKey string) (*Item, error) {
+	query := url.Values{}
+	query.Set("includeGuids", "1")
+	var res container
+	if err := plex.get(ctx, "/library/metadata/"+ratingKey, query, &res); err != nil {
+		return nil, err
+	}
+	if len(res.MediaContainer.Metadata) == 0 {
+		return nil, fmt.Errorf("plex: no item %s", ratingKey)
+	}
+	return &res.MediaContainer.Metadata[0], nil
+}
+
+// Scan asks the server to scan section, only folder when it is not
+// empty. It implements scanner.Scanner, the server scans in the
+// background after accepting the request.
+func (plex *Plex) Scan(ctx context.Context, section int, folder string) error {
+	query := url.Values{}
+	if len(folder) > 0 {
+		query.Set("path", folder)
+	}
+	return plex.get(ctx, fmt.Sprintf("/library/sections/%d/refresh", section), query, nil)
+}
+
+// Matches searches the agent of the library of the item keyed
+// ratingKey for the movies it can be matched to, best first. Plex
+// agents take "tmdb-<id>" or "imdb-<id>" as title to search by id.
+func (plex *Plex) Matches(ctx context.Context, ratingKey string, title string, year string) ([]Match, error) {
+	query := url.Values{}
+	query.Set("manual", "1")
+	query.Set("title", title)
+	if len(year) > 0 {
+		query.Set("year", year)
+	}
+	var res container
+	if err := plex.get(ctx, "/library/metadata/"+ratingKey+"/matches", query, &res); err != nil {
+		return nil, err
+	}
+	return res.MediaContainer.SearchResult, nil
+}
+
+// Match matches the item keyed ratingKey to match, the way Fix Match
+// of the web app does, Plex then refreshes its metadata
+func (plex *Plex) Match(ctx context.Context, ratingKey string, match Match) error {
+	query := url.Values{}
+	query.Set("guid", match.GUID)
+	query.Set("name", match.Name)
+	if match.Year > 0 {
+		query.Set("year", strconv.Itoa(match.Year))
+	}
+	return plex.do(ctx, "PUT", "/library/metadata/"+ratingKey+"/match", query, nil)
+}
+
+// FixMatch matches the item keyed ratingKey to movie, found by its
+// TMDb id, it returns the match. The best match the agent finds is
+// only applied when its id, or else its title and year, are the ones
+// of movie.
+func (plex *Plex) FixMatch(ctx context.Context, ratingKey string, movie metadata.Movie) (*Match, error) {
+	matches, err := plex.Matches(ctx, ratingKey, "tmdb-"+movie.TMDbID, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("plex: no match for TMDb id %s", movie.TMDbID)
+	}
+	best := matches[0]
+	if !best.Is(movie) {
+		return nil, fmt.Errorf("plex: best match for TMDb id %s is %s (%d), not %s (%s)", movie.TMDbID, best.Name, best.Year, movie.Title, movie.Year)
+	}
+	if err := plex.Match(ctx, ratingKey, best); err != nil {
+		return nil, err
+	}
+	return &best, nil
+}
+
+// Is tells whether match is movie, by TMDb id when the agent of match
+// tells it and by title and year otherwise
+func (match Match) Is(movie metadata.Movie) bool {
+	if id := (Item{GUID: match.GUID}).ID("tmdb"); len(id) > 0 {
+		return id == movie.TMDbID
+	}
+	return strings.EqualFold(match.Name, movie.Title) && strconv.Itoa(match.Year) == movie.Year
+}
+
+// movies lists the movies of section whose field matches value
+func (plex *Plex) movies(ctx context.Context, section int, field string, value string) ([]Item, error) {
+	query := url.Values{}
+	query.Set("type", movieType)
+	query.Set("includeGuids", "1")
+	query.Set(field, value)
+	var res container
+	if err := plex.get(ctx, fmt.Sprintf("/library/sections/%d/all", section), query, &res); err != nil {
+		return nil, err
+	}
+	return res.MediaContainer.Metadata, nil
+}
+
+// byFolder returns the item having a file in folder
+func byFolder(items []Item, folder string) *Item {
+	if len(folder) == 0 {
+		return nil
+	}
+	folder = filepath.Clean(folder) + string(filepath.Separator)
+	for i, item := range items {
+		for _, file := range item.Files() {
+			if strings.HasPrefix(file, folder) {
+				return &items[i]
+			}
+		}
+	}
+	return nil
+}
+
+// get sends get request with the given query to path of the server
+// and decodes the json body of the response into target, the body is
+// ignored when target is nil.
+func (plex *Plex) get(ctx context.Context, path string, query url.Values, target interface{}) error {
+	return plex.do(ctx, "GET", path, query, target)
+}
+
+// do sends a request of method to path of the server, like get
+func (plex *Plex) do(ctx context.Context, method string, path string, query url.Values, target interface{}) error {
+	address := plex.BaseURL + path
+	if len(query) > 0 {
+		address += "?" + query.Encode()
+	}
+	request, err := http.NewRequest(method, address, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("X-Plex-Token", plex.Token)
+	res, err := plex.Client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("plex: %s: HTTP response %d", path, res.StatusCode)
+	}
+	if target == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("plex: %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+)
+
+func generalSet(statusCode int, body string) *http.Response {
+	return &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		StatusCode: statusCode,
+	}
+}
+
+// httpClientStub answers with the response registered for the path
+// and query of every request, 404 when there is none
+type httpClientStub struct {
+	responses map[string]*http.Response
+	err       error
+	requests  []*http.Request
+}
+
+func (cl *httpClientStub) Do(req *http.Request) (*http.Response, error) {
+	cl.requests = append(cl.requests, req)
+	if cl.err != nil {
+		return nil, cl.err
+	}
+	if res, ok := cl.responses[req.URL.RequestURI()]; ok {
+		return res, nil
+	}
+	return generalSet(404, ""), nil
+}
+
+const (
+	byTitle = "/library/sections/3/all?includeGuids=1&title=Heat&type=1"
+	byYear  = "/library/sections/3/all?includeGuids=1&type=1&year=1995"
+	heat    = `{"MediaContainer":{"Metadata":[
+		{"ratingKey":"12","title":"Heat","year":1986,"Media":[{"Part":[{"file":"/movies/Heat (1986)/Heat.mkv"}]}]},
+		{"ratingKey":"34","title":"Heat","year":1995,"Media":[{"Part":[{"file":"/movies/Heat (1995)/Heat.mkv"}]}]}]}}`
+	renamed = `{"MediaContainer":{"Metadata":[
+		{"ratingKey":"56","title":"Heat (Director's Cut)","year":1995,"Media":[{"Part":[{"file":"/movies/Heat (1995) [Extended]/Heat.mkv"}]}]}]}}`
+	empty = `{"MediaContainer":{"size":0}}`
+)
+
+var findCases = []struct {
+	name         string
+	folder       string
+	responses    map[string]*http.Response
+	err          error
+	ratingKey    string
+	errorMessage string
+}{
+	{
+		name:      "case found by folder",
+		folder:    "/movies/Heat (1995)/",
+		responses: map[string]*http.Response{byTitle: generalSet(200, heat)},
+		ratingKey: "34",
+	},
+	{
+		name:      "case found by title and year without folder",
+		responses: map[string]*http.Response{byTitle: generalSet(200, heat)},
+		ratingKey: "34",
+	},
+	{
+		name:      "case another copy of the movie",
+		folder:    "/mnt/movies/Heat (1995)",
+		responses: map[string]*http.Response{byTitle: generalSet(200, heat), byYear: generalSet(200, heat)},
+	},
+	{
+		name:   "case found by folder under another title",
+		folder: "/movies/Heat (1995) [Extended]",
+		responses: map[string]*http.Response{
+			byTitle: generalSet(200, empty),
+			byYear:  generalSet(200, renamed),
+		},
+		ratingKey: "56",
+	},
+	{
+		name:   "case not indexed yet",
+		folder: "/movies/Heat (1995)",
+		responses: map[string]*http.Response{
+			byTitle: generalSet(200, empty),
+			byYear:  generalSet(200, empty),
+		},
+	},
+	{
+		name:         "case unauthorized",
+		folder:       "/movies/Heat (1995)",
+		responses:    map[string]*http.Response{byTitle: generalSet(401, "")},
+		errorMessage: "plex: /library/sections/3/all: HTTP response 401",
+	},
+	{
+		name:         "case failed contacting plex",
+		folder:       "/movies/Heat (1995)",
+		err:          fmt.Errorf("connection refused"),
+		errorMessage: "connection refused",
+	},
+}
+
+func TestPlex_Find(t *testing.T) {
+	for _, tt := range findCases {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &httpClientStub{responses: tt.responses, err: tt.err}
+			plex := New("http://127.0.0.1:32400/", "secret")
+			plex.Client = stub
+			item, err := plex.Find(context.Background(), 3, tt.folder, "Heat", "1995")
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in Find() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Fatalf("Find() expected error %v", tt.errorMessage)
+			}
+			if got := stub.requests[0].Header.Get("X-Plex-Token"); got != "secret" {
+				t.Errorf("Find() sent token %q, want secret", got)
+			}
+			if len(tt.ratingKey) == 0 {
+				if item != nil {
+					t.Errorf("Find() = %v, want nil", item.RatingKey)
+				}
+				return
+			}
+			if item == nil || item.RatingKey != tt.ratingKey {
+				t.Errorf("Find() = %v, want %v", item, tt.ratingKey)
+			}
+		})
+	}
+}
+
+func TestPlex_MachineIdentifier(t *testing.T) {
+	stub := &httpClientStub{responses: map[string]*http.Response{
+		"/identity": generalSet(200, `{"MediaContainer":{"size":0,"machineIdentifier":"abc123","version":"1.16.0"}}`),
+	}}
+	plex := New("http://127.0.0.1:32400", "secret")
+	plex.Client = stub
+	id, err := plex.MachineIdentifier(context.Background())
+	if err != nil || id != "abc123" {
+		t.Errorf("MachineIdentifier() = %v, %v, want abc123", id, err)
+	}
+	if got := stub.requests[0].Header.Get("Accept"); got != "application/json" {
+		t.Errorf("MachineIdentifier() sent Accept %q, want application/json", got)
+	}
+}
+
+var scanCases = []struct {
+	name         string
+	folder       string
+	uri          string
+	status       int
+	errorMessage string
+}{
+	{
+		name:   "case partial scan",
+		folder: "/movies/Heat (1995)",
+		uri:    "/library/sections/3/refresh?path=%2Fmovies%2FHeat+%281995%29",
+		status: 200,
+	},
+	{
+		name:   "case whole section",
+		uri:    "/library/sections/3/refresh",
+		status: 200,
+	},
+	{
+		name:         "case unknown section",
+		uri:          "/library/sections/3/refresh",
+		status:       404,
+		errorMessage: "plex: /library/sections/3/refresh: HTTP response 404",
+	},
+}
+
+func TestPlex_Scan(t *testing.T) {
+	for _, tt := range scanCases {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &httpClientStub{responses: map[string]*http.Response{tt.uri: generalSet(tt.status, "")}}
+			plex := New("http://127.0.0.1:32400", "secret")
+			plex.Client = stub
+			err := plex.Scan(context.Background(), 3, tt.folder)
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in Scan() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Errorf("Scan() expected error %v", tt.errorMessage)
+			}
+			if got := stub.requests[0].URL.RequestURI(); got != tt.uri {
+				t.Errorf("Scan() requested %v, want %v", got, tt.uri)
+			}
+		})
+	}
+}
+
+func TestPlex_Sections(t *testing.T) {
+	stub := &httpClientStub{responses: map[string]*http.Response{
+		"/library/sections": generalSet(200, `{"MediaContainer":{"size":2,"Directory":[
+			{"key":"1","type":"movie","title":"Movies","Location":[{"id":1,"path":"/data/movies"},{"id":4,"path":"/data/movies2"}]},
+			{"key":"2","type":"show","title":"TV Shows","Location":[{"id":2,"path":"/data/tv"}]}]}}`),
+	}}
+	plex := New("http://127.0.0.1:32400", "secret")
+	plex.Client = stub
+	sections, err := plex.Sections(context.Background())
+	want := []Section{
+		{Key: "1", Type: "movie", Title: "Movies", Locations: []Location{{Path: "/data/movies"}, {Path: "/data/movies2"}}},
+		{Key: "2", Type: "show", Title: "TV Shows", Locations: []Location{{Path: "/data/tv"}}},
+	}
+	if err != nil || !reflect.DeepEqual(sections, want) {
+		t.Errorf("Sections() = %+v, %v, want %+v", sections, err, want)
+	}
+}
+
+func TestPlex_RecentlyAdded(t *testing.T) {
+	stub := &httpClientStub{responses: map[string]*http.Response{
+		"/library/recentlyAdded?X-Plex-Container-Size=100&X-Plex-Container-Start=0&includeGuids=1": generalSet(200, `{"MediaContainer":{"size":2,"Metadata":[
+			{"ratingKey":"34","type":"movie","title":"Heat","year":1995,"addedAt":1560000000,"librarySectionID":1,"librarySectionTitle":"Movies","contentRating":"R","Genre":[{"tag":"Crime"},{"tag":"Drama"}],"Guid":[{"id":"imdb://tt0113277"},{"id":"tmdb://949"}]},
+			{"ratingKey":"78","type":"season","title":"Season 1","addedAt":1550000000}]}}`),
+	}}
+	plex := New("http://127.0.0.1:32400", "secret")
+	plex.Client = stub
+	items, err := plex.RecentlyAdded(context.Background())
+	if err != nil || len(items) != 2 {
+		t.Fatalf("RecentlyAdded() = %+v, %v, want 2 items", items, err)
+	}
+	want := metadata.Movie{
+		Title:         "Heat",
+		Year:          "1995",
+		Certification: "R",
+		Genres:        []string{"Crime", "Drama"},
+		TMDbID:        "949",
+		IMDbID:        "tt0113277",
+	}
+	if got := items[0].Movie(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Movie() = %+v, want %+v", got, want)
+	}
+	if items[0].AddedAt != 1560000000 || items[0].SectionID != 1 {
+		t.Errorf("RecentlyAdded() = %+v, want added at 1560000000 to section 1", items[0])
+	}
+}
+
+var idCases = []struct {
+	name string
+	item Item
+	imdb string
+	tmdb string
+}{
+	{
+		name: "case plex agent",
+		item: Item{GUID: "plex://movie/5d776829", GUIDs: []GUID{{ID: "imdb://tt0113277"}, {ID: "tmdb://949"}, {ID: "tvdb://1"}}},
+		imdb: "tt0113277",
+		tmdb: "949",
+	},
+	{
+		name: "case legacy imdb agent",
+		item: Item{GUID: "com.plexapp.agents.imdb://tt0113277?lang=en"},
+		imdb: "tt0113277",
+	},
+	{
+		name: "case legacy themoviedb agent",
+		item: Item{GUID: "com.plexapp.agents.themoviedb://949?lang=en"},
+		tmdb: "949",
+	},
+	{
+		name: "case unmatched",
+		item: Item{GUID: "local://34"},
+	},
+}
+
+func TestItem_ID(t *testing.T) {
+	for _, tt := range idCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.item.ID("imdb"); got != tt.imdb {
+				t.Errorf("ID(imdb) = %v, want %v", got, tt.imdb)
+			}
+			if got := tt.item.ID("tmdb"); got != tt.tmdb {
+				t.Errorf("ID(tmdb) = %v, want %v", got, tt.tmdb)
+			}
+		})
+	}
+}
+
+var matchedCases = []struct {
+	name    string
+	item    Item
+	matched bool
+}{
+	{
+		name:    "case same tmdb id",
+		item:    Item{GUIDs: []GUID{{ID: "imdb://tt0113277"}, {ID: "tmdb://949"}}},
+		matched: true,
+	},
+	{
+		name: "case other tmdb id",
+		item: Item{GUIDs: []GUID{{ID: "imdb://tt0113277"}, {ID: "tmdb://10"}}},
+	},
+	{
+		name:    "case legacy imdb agent",
+		item:    Item{GUID: "com.plexapp.agents.imdb://tt0113277?lang=en"},
+		matched: true,
+	},
+	{
+		name: "case unmatched",
+		item: Item{GUID: "local://34"},
+	},
+}
+
+func TestItem_Matched(t *testing.T) {
+	for _, tt := range matchedCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.item.Matched("949", "tt0113277"); got != tt.matched {
+				t.Errorf("Matched() = %v, want %v", got, tt.matched)
+			}
+		})
+	}
+	if !(Item{GUID: "plex://movie/5d776829"}).Pending() || (Item{GUIDs: []GUID{{ID: "tmdb://949"}}}).Pending() {
+		t.Error("Pending() doesn't tell items without TMDb and IMDb ids")
+	}
+}
+
+const (
+	matches = "/library/metadata/34/matches?manual=1&title=tmdb-949"
+	match   = "/library/metadata/34/match?guid=plex%3A%2F%2Fmovie%2F5d776829&name=Heat&year=1995"
+)
+
+var fixMatchCases = []struct {
+	name         string
+	responses    map[string]*http.Response
+	errorMessage string
+}{
+	{
+		name: "case matched",
+		responses: map[string]*http.Response{
+			matches: generalSet(200, `{"MediaContainer":{"SearchResult":[{"guid":"plex://movie/5d776829","name":"Heat","year":1995,"score":100}]}}`),
+			match:   generalSet(200, ""),
+		},
+	},
+	{
+		name:         "case no match",
+		responses:    map[string]*http.Response{matches: generalSet(200, `{"MediaContainer":{"size":0}}`)},
+		errorMessage: "plex: no match for TMDb id 949",
+	},
+	{
+		name: "case match refused",
+		responses: map[string]*http.Response{
+			matches: generalSet(200, `{"MediaContainer":{"SearchResult":[{"guid":"plex://movie/5d776829","name":"Heat","year":1995}]}}`),
+			match:   generalSet(403, ""),
+		},
+		errorMessage: "plex: /library/metadata/34/match: HTTP response 403",
+	},
+	{
+		name:         "case best match is another movie",
+		responses:    map[string]*http.Response{matches: generalSet(200, `{"MediaContainer":{"SearchResult":[{"guid":"plex://movie/5d776830","name":"Heat","year":1986}]}}`)},
+		errorMessage: "plex: best match for TMDb id 949 is Heat (1986), not Heat (1995)",
+	},
+	{
+		name:         "case legacy agent match of another id",
+		responses:    map[string]*http.Response{matches: generalSet(200, `{"MediaContainer":{"SearchResult":[{"guid":"com.plexapp.agents.themoviedb://10?lang=en","name":"Heat","year":1995}]}}`)},
+		errorMessage: "plex: best match for TMDb id 949",
+	},
+}
+
+func TestPlex_Metadata(t *testing.T) {
+	stub := &httpClientStub{responses: map[string]*http.Response{
+		"/library/metadata/34?includeGuids=1": generalSet(200, `{"MediaContainer":{"Metadata":[{"ratingKey":"34","title":"Heat","Guid":[{"id":"tmdb://949"}]}]}}`),
+	}}
+	plex := New("http://127.0.0.1:32400", "secret")
+	plex.Client = stub
+	item, err := plex.Metadata(context.Background(), "34")
+	if err != nil || item.ID("tmdb") != "949" {
+		t.Errorf("Metadata() = %+v, %v, want the item matched to TMDb 949", item, err)
+	}
+	if _, err := plex.Metadata(context.Background(), "56"); err == nil {
+		t.Error("Metadata() expected an error for a missing item")
+	}
+}
+
+func TestPlex_FixMatch(t *testing.T) {
+	for _, tt := range fixMatchCases {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &httpClientStub{responses: tt.responses}
+			plex := New("http://127.0.0.1:32400", "secret")
+			plex.Client = stub
+			got, err := plex.FixMatch(context.Background(), "34", metadata.Movie{Title: "Heat", Year: "1995", TMDbID: "949"})
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in FixMatch() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Fatalf("FixMatch() expected error %v", tt.errorMessage)
+			}
+			want := &Match{GUID: "plex://movie/5d776829", Name: "Heat", Year: 1995}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("FixMatch() = %+v, want %+v", got, want)
+			}
+			if method := stub.requests[1].Method; method != "PUT" {
+				t.Errorf("FixMatch() matched with %s, want PUT", method)
+			}
+		})
+	}
+}
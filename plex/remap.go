@@ -0,0 +1,45 @@
+package plex
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Remap translates the folders Plex sees into the ones this program
+// sees, keyed by the folder in Plex. It is needed when Plex runs in a
+// container mounting the libraries elsewhere.
+type Remap map[string]string
+
+// Local translates path seen by Plex into the local one
+func (remap Remap) Local(path string) string {
+	return translate(remap, path)
+}
+
+// Plex translates local path into the one seen by Plex
+func (remap Remap) Plex(path string) string {
+	reverse := map[string]string{}
+	for plex, local := range remap {
+		reverse[local] = plex
+	}
+	return translate(reverse, path)
+}
+
+// translate replaces the longest prefix of path found in prefixes,
+// prefixes only match whole folders
+func translate(prefixes map[string]string, path string) string {
+	var from []string
+	for prefix := range prefixes {
+		from = append(from, prefix)
+	}
+	sort.Slice(from, func(i, j int) bool {
+		return len(from[i]) > len(from[j])
+	})
+	for _, prefix := range from {
+		clean := filepath.Clean(prefix)
+		if path == clean || strings.HasPrefix(path, strings.TrimRight(clean, string(filepath.Separator))+string(filepath.Separator)) {
+			return filepath.Join(prefixes[prefix], strings.TrimPrefix(path, clean))
+		}
+	}
+	return path
+}
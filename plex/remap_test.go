@@ -0,0 +1,52 @@
+package plex
+
+import "testing"
+
+var remapCases = []struct {
+	name  string
+	plex  string
+	local string
+}{
+	{
+		name:  "case remapped folder",
+		plex:  "/data/movies/Heat (1995)",
+		local: "/mnt/media/movies/Heat (1995)",
+	},
+	{
+		name:  "case remapped root",
+		plex:  "/data/movies",
+		local: "/mnt/media/movies",
+	},
+	{
+		name:  "case longest prefix wins",
+		plex:  "/data/movies/4k/Heat (1995)",
+		local: "/mnt/uhd/Heat (1995)",
+	},
+	{
+		name:  "case partial folder name",
+		plex:  "/data/movies-old/Heat (1995)",
+		local: "/data/movies-old/Heat (1995)",
+	},
+	{
+		name:  "case not remapped",
+		plex:  "/srv/tv/Dark",
+		local: "/srv/tv/Dark",
+	},
+}
+
+func TestRemap(t *testing.T) {
+	remap := Remap{
+		"/data/movies":    "/mnt/media/movies",
+		"/data/movies/4k": "/mnt/uhd/",
+	}
+	for _, tt := range remapCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remap.Local(tt.plex); got != tt.local {
+				t.Errorf("Local(%v) = %v, want %v", tt.plex, got, tt.local)
+			}
+			if got := remap.Plex(tt.local); got != tt.plex {
+				t.Errorf("Plex(%v) = %v, want %v", tt.local, got, tt.plex)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package plexhook receives the webhooks Plex Media Server sends when
+// it adds an item to a library, for setups where the media folders
+// can't be watched. Webhooks are multipart requests holding the event
+// as json in payload field along with a thumbnail, the thumbnail is
+// ignored since Slack can only show images it can download.
+package plexhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/plex"
+)
+
+const (
+	// EventNew is the event sent when an item is added to a library
+	EventNew = "library.new"
+
+	// maxBody is the largest webhook accepted, thumbnails included
+	maxBody = 16 << 20
+	// maxMemory is how much of a webhook is kept in memory, the rest
+	// of it goes to temporary files
+	maxMemory = 1 << 20
+)
+
+// Store keeps the events already received, store.Store implements it
+type Store interface {
+	Get(bucket string, key string, value interface{}) (bool, error)
+	Put(bucket string, key string, value interface{}) error
+	Prune(bucket string, expired func(key string, value json.RawMessage) bool) (int, error)
+}
+
+// Server describes the server sending an event, UUID is its machine
+// identifier
+type Server struct {
+	Title string `json:"title"`
+	UUID  string `json:"uuid"`
+}
+
+// Event is the payload of a webhook
+type Event struct {
+	Event    string    `json:"event"`
+	Server   Server    `json:"Server"`
+	Metadata plex.Item `json:"Metadata"`
+}
+
+// Announce is called with every new movie received
+type Announce func(event Event)
+
+// Handler is the http.Handler receiving webhooks, it only accepts the
+// ones sent to an address ending with Secret or having it as token
+// query parameter. Every movie is announced once, the events received
+// are kept in bucket of store.
+type Handler struct {
+	secret   string
+	store    Store
+	bucket   string
+	announce Announce
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+// New creates a Handler accepting the webhooks sent with secret
+func New(secret string, store Store, bucket string, announce Announce) *Handler {
+	return &Handler{
+		secret:   secret,
+		store:    store,
+		bucket:   bucket,
+		announce: announce,
+		now:      time.Now,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !handler.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		http.Error(w, "invalid webhook: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	var event Event
+	if err := json.Unmarshal([]byte(r.FormValue("payload")), &event); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if event.Event != EventNew || event.Metadata.Type != "movie" {
+		return
+	}
+	first, err := handler.first(event)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if first {
+		handler.announce(event)
+	}
+}
+
+// Prune forgets the events received before before, it returns how
+// many were forgotten. Plex doesn't send the event of an item again,
+// an item is forgotten long after it is announced.
+func (handler *Handler) Prune(before time.Time) (int, error) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	return handler.store.Prune(handler.bucket, func(key string, value json.RawMessage) bool {
+		var received time.Time
+		if err := json.Unmarshal(value, &received); err != nil {
+			return true
+		}
+		return received.Before(before)
+	})
+}
+
+// authorized tells whether r was sent with the secret
+func (handler *Handler) authorized(r *http.Request) bool {
+	for _, given := range []string{path.Base(r.URL.Path), r.URL.Query().Get("token")} {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(handler.secret)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// first records event and tells whether it is the first time the item
+// of event was received
+func (handler *Handler) first(event Event) (bool, error) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	key := event.Server.UUID + "/" + event.Metadata.RatingKey
+	var received time.Time
+	found, err := handler.store.Get(handler.bucket, key, &received)
+	if err != nil || found {
+		return false, err
+	}
+	return true, handler.store.Put(handler.bucket, key, handler.now())
+}
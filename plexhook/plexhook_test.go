@@ -0,0 +1,128 @@
+package plexhook
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+const (
+	newMovie = `{"event":"library.new","Server":{"title":"office","uuid":"abc123"},"Metadata":{"ratingKey":"34","type":"movie","title":"Heat","year":1995,"librarySectionID":1,"librarySectionTitle":"Movies","guid":"plex://movie/5d776829","Guid":[{"id":"imdb://tt0113277"},{"id":"tmdb://949"}]}}`
+	newShow  = `{"event":"library.new","Server":{"uuid":"abc123"},"Metadata":{"ratingKey":"56","type":"show","title":"Dark"}}`
+	played   = `{"event":"media.play","Server":{"uuid":"abc123"},"Metadata":{"ratingKey":"34","type":"movie","title":"Heat"}}`
+)
+
+// webhook builds a webhook request the way Plex sends it
+func webhook(target string, payload string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("payload", payload)
+	thumb, _ := writer.CreateFormFile("thumb", "thumb.jpg")
+	thumb.Write([]byte("jpeg"))
+	writer.Close()
+	r := httptest.NewRequest("POST", target, &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+var handlerCases = []struct {
+	name      string
+	request   *http.Request
+	status    int
+	announced []string
+}{
+	{
+		name:      "case new movie with secret in path",
+		request:   webhook("/plex/s3cret", newMovie),
+		status:    200,
+		announced: []string{"Heat"},
+	},
+	{
+		name:      "case new movie with secret as token",
+		request:   webhook("/plex?token=s3cret", newMovie),
+		status:    200,
+		announced: []string{"Heat"},
+	},
+	{
+		name:    "case wrong secret",
+		request: webhook("/plex/guess", newMovie),
+		status:  401,
+	},
+	{
+		name:    "case other event",
+		request: webhook("/plex/s3cret", played),
+		status:  200,
+	},
+	{
+		name:    "case new show",
+		request: webhook("/plex/s3cret", newShow),
+		status:  200,
+	},
+	{
+		name:    "case invalid payload",
+		request: webhook("/plex/s3cret", "{"),
+		status:  400,
+	},
+	{
+		name:    "case not a webhook",
+		request: httptest.NewRequest("GET", "/plex/s3cret", nil),
+		status:  405,
+	},
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	for _, tt := range handlerCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var announced []string
+			handler := New("s3cret", store.Memory(), "plexhook", func(event Event) {
+				announced = append(announced, event.Metadata.Title)
+			})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, tt.request)
+			if w.Code != tt.status {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, tt.status)
+			}
+			if len(announced) != len(tt.announced) || (len(announced) > 0 && announced[0] != tt.announced[0]) {
+				t.Errorf("ServeHTTP() announced %v, want %v", announced, tt.announced)
+			}
+		})
+	}
+}
+
+func TestHandler_Duplicates(t *testing.T) {
+	count := 0
+	handler := New("s3cret", store.Memory(), "plexhook", func(event Event) {
+		count++
+	})
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), webhook("/plex/s3cret", newMovie))
+	}
+	if count != 1 {
+		t.Errorf("ServeHTTP() announced the movie %d times, want once", count)
+	}
+}
+
+func TestHandler_Prune(t *testing.T) {
+	now := time.Date(2019, 5, 1, 20, 15, 0, 0, time.UTC)
+	count := 0
+	handler := New("s3cret", store.Memory(), "plexhook", func(event Event) {
+		count++
+	})
+	handler.now = func() time.Time { return now }
+	handler.ServeHTTP(httptest.NewRecorder(), webhook("/plex/s3cret", newMovie))
+	if removed, err := handler.Prune(now); removed != 0 || err != nil {
+		t.Errorf("Prune() = %v, %v, want nothing forgotten", removed, err)
+	}
+	if removed, err := handler.Prune(now.Add(time.Second)); removed != 1 || err != nil {
+		t.Errorf("Prune() = %v, %v, want 1", removed, err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), webhook("/plex/s3cret", newMovie))
+	if count != 2 {
+		t.Errorf("ServeHTTP() announced the movie %d times, want it again once forgotten", count)
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/plex"
+	"github.com/rimaulana/plexgoslack/recent"
+	"github.com/rimaulana/plexgoslack/scanner"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+const (
+	// indexPoll is how often Plex is asked whether it indexed a movie
+	indexPoll = time.Second * 10
+	// stderrLines is how many lines of the stderr of a failed scan are
+	// alerted
+	stderrLines = 10
+	// defaultServer is the name of the server of plex_server section
+	defaultServer = ""
+	// waitingBucket is the bucket of state keeping the announcements
+	// waiting for Plex to index their movie
+	waitingBucket = "indexing"
+)
+
+// waiting keeps the announcements waiting for Plex to index their
+// movie, the ones still waiting on shutdown are resumed on start
+var waiting *store.Store
+
+// indexWait is an announcement waiting for Plex to index its movie
+type indexWait struct {
+	Server       string              `json:"server"`
+	Section      int                 `json:"section"`
+	Folder       string              `json:"folder"`
+	Announcement notify.Announcement `json:"announcement"`
+}
+
+// servers are the Plex servers holding the libraries, keyed by name,
+// the server of plex_server section is always there
+var servers map[string]*server
+
+// server is a Plex Media Server along with the way its libraries are
+// scanned and announced
+type server struct {
+	name string
+	cfg  config.PlexServerCfg
+	// api is nil when the server has no url and token
+	api *plex.Plex
+	// remap translates the folders seen by the server into local ones
+	remap plex.Remap
+	// webURL is the page announcements link to
+	webURL string
+	scans  *scanner.Scheduler
+	// poller is nil unless poll is set
+	poller *recent.Poller
+	// machineID caches the identifier of the server
+	machineID struct {
+		sync.Mutex
+		value string
+	}
+}
+
+// newServers returns the servers of plex_server and plex_servers
+// sections. Scans are kept in state along with where the pollers
+// stopped.
+func newServers(ctx context.Context, cfg *config.Config, state *store.Store) (map[string]*server, error) {
+	all := map[string]*server{}
+	webURL := cfg.PlexURL
+	if len(cfg.PlexServer.PlexURL) > 0 {
+		webURL = cfg.PlexServer.PlexURL
+	}
+	srv, err := newServer(ctx, cfg, defaultServer, cfg.PlexServer, cfg.Scanner, webURL, state)
+	if err != nil {
+		return nil, err
+	}
+	all[defaultServer] = srv
+	for name, named := range cfg.PlexServers {
+		if len(named.URL) == 0 || len(named.Token) == 0 {
+			return nil, fmt.Errorf("plex_servers.%s: url and token are required", name)
+		}
+		if named.Timeout.Duration <= 0 {
+			named.Timeout = cfg.PlexServer.Timeout
+		}
+		if named.IndexTimeout.Duration <= 0 {
+			named.IndexTimeout = cfg.PlexServer.IndexTimeout
+		}
+		webURL := named.PlexURL
+		if len(webURL) == 0 {
+			webURL = strings.TrimRight(named.URL, "/") + "/"
+		}
+		srv, err := newServer(ctx, cfg, name, named, scannerSettings(named.Scanner, cfg.Scanner), webURL, state)
+		if err != nil {
+			return nil, err
+		}
+		all[name] = srv
+	}
+	return all, nil
+}
+
+// newServer returns the server named name set in cfg, scanning its
+// libraries as scan sets
+func newServer(ctx context.Context, root *config.Config, name string, cfg config.PlexServerCfg, scan config.ScannerCfg, webURL string, state *store.Store) (*server, error) {
+	srv := &server{
+		name:   name,
+		cfg:    cfg,
+		remap:  plex.Remap(cfg.Remap),
+		webURL: webURL,
+	}
+	var err error
+	if srv.api, err = newPlexAPI(root, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", srv.section(), err)
+	}
+	backend, err := srv.scanner(scan)
+	if err != nil {
+		return nil, fmt.Errorf("%s: scanner: %s", srv.section(), err)
+	}
+	srv.scans = srv.scheduler(root, scan, backend, state)
+	if srv.poller, err = srv.newPoller(ctx, state); err != nil {
+		return nil, fmt.Errorf("%s: %s", srv.section(), err)
+	}
+	return srv, nil
+}
+
+// scannerSettings returns the scanner settings of a named server, the
+// ones it leaves out are taken from global but for the backend
+func scannerSettings(named config.ScannerCfg, global config.ScannerCfg) config.ScannerCfg {
+	if named.Quiet.Duration <= 0 {
+		named.Quiet = global.Quiet
+	}
+	if named.Timeout.Duration <= 0 {
+		named.Timeout = global.Timeout
+	}
+	if named.History <= 0 {
+		named.History = global.History
+	}
+	if len(named.Command) == 0 {
+		named.Command, named.Env, named.User, named.Dir = global.Command, global.Env, global.User, global.Dir
+	}
+	return named
+}
+
+// section returns the section of config file setting the server
+func (srv *server) section() string {
+	if srv.name == defaultServer {
+		return "plex_server"
+	}
+	return "plex_servers." + srv.name
+}
+
+// newPlexAPI connects to the API of Plex Media Server set in cfg, it
+// returns nil when url or token is not set
+func newPlexAPI(root *config.Config, cfg config.PlexServerCfg) (*plex.Plex, error) {
+	if len(cfg.URL) == 0 || len(cfg.Token) == 0 {
+		return nil, nil
+	}
+	client, err := newTransport(root, cfg.Proxy, cfg.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	options := []plex.Option{plex.WithTransport(client)}
+	if cfg.Timeout.Duration > 0 {
+		options = append(options, plex.WithTimeout(cfg.Timeout.Duration))
+	}
+	return plex.New(cfg.URL, cfg.Token, options...), nil
+}
+
+// scanner returns the scanner of the backend set in cfg, scanning
+// through the API of the server when it is not set
+func (srv *server) scanner(cfg config.ScannerCfg) (scanner.Scanner, error) {
+	switch cfg.Backend {
+	case "":
+		if srv.api != nil {
+			return srv.api, nil
+		}
+		return newCLI(cfg)
+	case scanner.BackendAPI:
+		if srv.api == nil {
+			return nil, fmt.Errorf("api backend needs url and token in %s section", srv.section())
+		}
+		return srv.api, nil
+	case scanner.BackendCLI:
+		return newCLI(cfg)
+	}
+	return nil, fmt.Errorf("unknown backend %q, want api or cli", cfg.Backend)
+}
+
+// newCLI returns the scanner running the command of cfg
+func newCLI(cfg config.ScannerCfg) (scanner.Scanner, error) {
+	return scanner.NewCLI(scanner.Command{
+		Args: cfg.Command,
+		Env:  cfg.Env,
+		User: cfg.User,
+		Dir:  cfg.Dir,
+	})
+}
+
+// scheduler returns the scheduler of the scans of backend, the scans
+// are kept in state and the failed ones are alerted to ops
+func (srv *server) scheduler(root *config.Config, cfg config.ScannerCfg, backend scanner.Scanner, state *store.Store) *scanner.Scheduler {
+	options := []scanner.Option{
+		scanner.WithTimeout(cfg.Timeout.Duration),
+		scanner.WithHistory(state, scansBucket(srv.name), cfg.History),
+		scanner.WithFailure(srv.alertScan),
+	}
+	for _, lib := range root.Plex {
+		if lib.Server == srv.name && lib.ScanTimeout.Duration > 0 {
+			options = append(options, scanner.WithSectionTimeout(lib.Section, lib.ScanTimeout.Duration))
+		}
+	}
+	return scanner.NewScheduler(backend, cfg.Quiet.Duration, options...)
+}
+
+// scansBucket returns the bucket of state file keeping the scans of
+// the server named name
+func scansBucket(name string) string {
+	return path.Join("scans", name)
+}
+
+// alertScan alerts ops that the scan of record failed, along with
+// the end of what the scanner printed on stderr
+func (srv *server) alertScan(record scanner.Record) {
+	text := fmt.Sprintf("Scanning section %d of %s %s failed after %s: %s", record.Section, srv.title(), record.Folder,
+		record.Duration.Round(time.Second), record.Error)
+	if tail := record.StderrTail(stderrLines); len(tail) > 0 {
+		text += "\n```" + tail + "```"
+	}
+	Alert(path.Join("scan", srv.name, strconv.Itoa(record.Section)), text)
+}
+
+// title returns how the server is called in alerts
+func (srv *server) title() string {
+	return notify.Announcement{Server: srv.name}.PlexName()
+}
+
+// library is a folder of a Plex library watched for movies
+type library struct {
+	name   string
+	cfg    config.PlexLibCfg
+	server *server
+}
+
+// watchedLibraries returns the libraries of plex section along with
+// the movie libraries discovered on the servers where discover is
+// set. Discovered libraries are named after their title in Plex, a
+// folder is watched for every location of the library. They are
+// skipped when plex section already has their section number, and so
+// are the folders already watched.
+func watchedLibraries(ctx context.Context, cfg *config.Config) ([]library, error) {
+	var watched []library
+	configured := map[string]map[int]bool{}
+	roots := map[string]bool{}
+	for name, lib := range cfg.Plex {
+		srv, ok := servers[lib.Server]
+		if !ok {
+			return nil, fmt.Errorf("plex.%s: unknown server %q, it is not in plex_servers section", name, lib.Server)
+		}
+		watched = append(watched, library{name: name, cfg: lib, server: srv})
+		roots[filepath.Clean(lib.Root)] = true
+		if configured[lib.Server] == nil {
+			configured[lib.Server] = map[int]bool{}
+		}
+		configured[lib.Server][lib.Section] = true
+	}
+	for _, srv := range servers {
+		if !srv.cfg.Discover {
+			continue
+		}
+		if srv.api == nil {
+			return nil, fmt.Errorf("%s: discover needs url and token", srv.section())
+		}
+		sections, err := srv.api.Sections(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("discovering the libraries of %s: %s", srv.title(), err)
+		}
+		for _, section := range sections {
+			number, err := strconv.Atoi(section.Key)
+			if err != nil || section.Type != "movie" || configured[srv.name][number] || !discovered(srv.cfg, section.Title) {
+				continue
+			}
+			for _, location := range section.Locations {
+				root := srv.remap.Local(location.Path)
+				if roots[filepath.Clean(root)] {
+					log.Println("info: skipping", root, "of", section.Title, "in", srv.title()+", it is already watched")
+					continue
+				}
+				roots[filepath.Clean(root)] = true
+				log.Println("info: discovered library", section.Title, "of", srv.title(), "in", root)
+				watched = append(watched, library{
+					name:   section.Title,
+					cfg:    config.PlexLibCfg{Root: root, Section: number, Server: srv.name},
+					server: srv,
+				})
+			}
+		}
+	}
+	return watched, nil
+}
+
+// discovered tells whether the library named title passes the include
+// and exclude filters of cfg, names are not case sensitive
+func discovered(cfg config.PlexServerCfg, title string) bool {
+	listed := func(names []string) bool {
+		for _, name := range names {
+			if strings.EqualFold(name, title) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(cfg.Include) > 0 && !listed(cfg.Include) {
+		return false
+	}
+	return !listed(cfg.Exclude)
+}
+
+// newPoller returns the poller announcing the movies recently added
+// to the server when poll is set, it returns nil otherwise. Where it
+// stopped is kept in state.
+func (srv *server) newPoller(ctx context.Context, state *store.Store) (*recent.Poller, error) {
+	if srv.cfg.Poll.Duration <= 0 {
+		return nil, nil
+	}
+	if srv.api == nil {
+		return nil, fmt.Errorf("poll needs url and token")
+	}
+	return recent.New(srv.api, state, "recent", srv.cfg.URL, func(item plex.Item) {
+		if !discovered(srv.cfg, item.SectionTitle) {
+			return
+		}
+		track(func() {
+			id, err := srv.identifier(ctx)
+			if err != nil {
+				log.Println("warning: looking up Plex machine identifier:", err)
+			}
+			AnnounceItem(ctx, srv, id, item)
+		})
+	}), nil
+}
+
+// identifier returns the machine identifier of the server, asking the
+// server only until it answers once
+func (srv *server) identifier(ctx context.Context) (string, error) {
+	srv.machineID.Lock()
+	defer srv.machineID.Unlock()
+	if len(srv.machineID.value) > 0 {
+		return srv.machineID.value, nil
+	}
+	if srv.api == nil {
+		return "", fmt.Errorf("%s has no url and token", srv.section())
+	}
+	id, err := srv.api.MachineIdentifier(ctx)
+	if err != nil {
+		return "", err
+	}
+	srv.machineID.value = id
+	return id, nil
+}
+
+// serverOf returns the server whose machine identifier is id, the
+// server of plex_server section when none of them has it
+func serverOf(ctx context.Context, id string) *server {
+	for _, srv := range servers {
+		if srv.api == nil {
+			continue
+		}
+		if known, err := srv.identifier(ctx); err == nil && known == id {
+			return srv
+		}
+	}
+	return servers[defaultServer]
+}
+
+// webURL returns the page the announcements of the server named name
+// link to
+func webURL(name string) string {
+	if srv, ok := servers[name]; ok {
+		return srv.webURL
+	}
+	return conf.PlexURL
+}
+
+// AnnounceIndexed waits for srv to index the movie of announcement
+// stored in folder of section so that the announcement links straight
+// to it, then posts the announcement. The index timeout starts once
+// scanned is closed, at once when it is nil. When the server doesn't
+// index the movie in time, it is posted with the link to the web app
+// or, when confirm is set for the server, ops destinations are
+// alerted instead. On shutdown the announcement is kept in state and
+// resumed on next start.
+func AnnounceIndexed(ctx context.Context, srv *server, section int, folder string, scanned <-chan struct{}, announcement notify.Announcement) {
+	key := announcement.Key()
+	if waiting != nil {
+		if err := waiting.Put(waitingBucket, key, indexWait{srv.name, section, folder, announcement}); err != nil {
+			log.Println("error:", err)
+		}
+	}
+	if scanned != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-scanned:
+		}
+	}
+	timeout := srv.cfg.IndexTimeout.Duration
+	wait, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	item := indexed(wait, srv, section, folder, announcement.Movie)
+	if ctx.Err() != nil {
+		return
+	}
+	if waiting != nil {
+		if err := waiting.Delete(waitingBucket, key); err != nil {
+			log.Println("error:", err)
+		}
+	}
+	switch {
+	case item != nil:
+		if id, err := srv.identifier(wait); err != nil {
+			log.Println("warning: looking up Plex machine identifier:", err)
+		} else {
+			announcement.PlexServer, announcement.PlexKey = id, item.RatingKey
+		}
+	case srv.cfg.Confirm:
+		Alert(path.Join("index", srv.name, folder), fmt.Sprintf("%s didn't index %s in %s within %s, it was not announced. Check that Plex can read %s",
+			srv.title(), notify.Title(announcement.Movie), announcement.Library, timeout, folder))
+		return
+	default:
+		log.Println("warning: Plex didn't index", announcement.Movie.Title, "in time, linking to the web app")
+	}
+	PostToSlack(announcement)
+	if item != nil {
+		checkMatch(ctx, srv, *item, announcement)
+	}
+}
+
+// resumeIndexed resumes the announcements still waiting for Plex to
+// index their movie when the program stopped
+func resumeIndexed(ctx context.Context) {
+	for _, key := range waiting.Keys(waitingBucket) {
+		var pending indexWait
+		if _, err := waiting.Get(waitingBucket, key, &pending); err != nil {
+			log.Println("error:", err)
+			continue
+		}
+		srv, ok := servers[pending.Server]
+		if !ok || srv.api == nil {
+			waiting.Delete(waitingBucket, key)
+			PostToSlack(pending.Announcement)
+			continue
+		}
+		log.Println("info: waiting again for", srv.title(), "to index", pending.Announcement.Movie.Title)
+		track(func() {
+			AnnounceIndexed(ctx, srv, pending.Section, pending.Folder, nil, pending.Announcement)
+		})
+	}
+}
+
+// checkMatch tells ops destinations when srv matched item to another
+// movie than the one of announcement, and with fix_match set for the
+// server asks it to match the item to the right one. Items the agent
+// didn't match yet are checked once it did, within the index timeout.
+func checkMatch(ctx context.Context, srv *server, item plex.Item, announcement notify.Announcement) {
+	movie := announcement.Movie
+	if len(movie.TMDbID) == 0 {
+		return
+	}
+	wait, cancel := context.WithTimeout(ctx, srv.cfg.IndexTimeout.Duration)
+	defer cancel()
+	for item.Pending() {
+		select {
+		case <-wait.Done():
+			log.Println("info:", srv.title(), "didn't match", movie.Title, "yet, its match is not checked")
+			return
+		case <-time.After(indexPoll):
+		}
+		latest, err := srv.api.Metadata(wait, item.RatingKey)
+		if err != nil {
+			if wait.Err() == nil {
+				log.Println("warning: looking up", movie.Title, "in", srv.title()+":", err)
+			}
+			continue
+		}
+		item = *latest
+	}
+	if item.Matched(movie.TMDbID, movie.IMDbID) {
+		return
+	}
+	key := path.Join("match", srv.name, item.RatingKey)
+	wrong := fmt.Sprintf("%s matched %s in %s to %s (%d)", srv.title(), notify.Title(movie), announcement.Library, item.Title, item.Year)
+	if !srv.cfg.FixMatch {
+		Alert(key, fmt.Sprintf("%s instead of TMDb %s, fix the match in Plex or set fix_match", wrong, movie.TMDbID))
+		return
+	}
+	match, err := srv.api.FixMatch(ctx, item.RatingKey, movie)
+	if err != nil {
+		Alert(key, fmt.Sprintf("%s instead of TMDb %s, fixing the match failed: %s", wrong, movie.TMDbID, err))
+		return
+	}
+	Alert(key, fmt.Sprintf("%s, matched it to %s (%d) of TMDb %s instead", wrong, match.Name, match.Year, movie.TMDbID))
+}
+
+// indexed asks srv for movie every indexPoll until it is found, it
+// returns nil when ctx is done first
+func indexed(ctx context.Context, srv *server, section int, folder string, movie metadata.Movie) *plex.Item {
+	for {
+		item, err := srv.api.Find(ctx, section, folder, movie.Title, movie.Year)
+		if err != nil && ctx.Err() == nil {
+			log.Println("warning: looking up", movie.Title, "in", srv.title()+":", err)
+		}
+		if item != nil {
+			return item
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(indexPoll):
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/digest"
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/plex"
+	"github.com/rimaulana/plexgoslack/scanner"
+)
+
+// plexStub answers with the body registered for the path and query of
+// every request, 404 when there is none
+type plexStub struct {
+	mutex     sync.Mutex
+	responses map[string]string
+	requests  []string
+}
+
+func (stub *plexStub) Do(req *http.Request) (*http.Response, error) {
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	stub.requests = append(stub.requests, req.URL.RequestURI())
+	body, ok := stub.responses[req.URL.RequestURI()]
+	status := 200
+	if !ok {
+		status = 404
+	}
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+}
+
+// stubServer returns a server whose API answers with responses
+func stubServer(name string, cfg config.PlexServerCfg, responses map[string]string) *server {
+	api := plex.New("http://plex:32400", "token")
+	api.Client = &plexStub{responses: responses}
+	return &server{name: name, cfg: cfg, api: api, webURL: "https://app.plex.tv/desktop/", scans: scanner.NewScheduler(&scannerStub{}, 0)}
+}
+
+const (
+	findHeat  = "/library/sections/3/all?includeGuids=1&title=Heat&type=1"
+	yearHeat  = "/library/sections/3/all?includeGuids=1&type=1&year=1995"
+	identity  = "/identity"
+	heatItems = `{"MediaContainer":{"Metadata":[{"ratingKey":"34","title":"Heat","year":1995,"Media":[{"Part":[{"file":"/movies/Heat (1995)/Heat.mkv"}]}]}]}}`
+	noItems   = `{"MediaContainer":{"size":0}}`
+)
+
+var announceCases = []struct {
+	name      string
+	confirm   bool
+	responses map[string]string
+	queued    []string
+	link      string
+}{
+	{
+		name:      "case indexed movie links to its page",
+		responses: map[string]string{findHeat: heatItems, identity: `{"MediaContainer":{"machineIdentifier":"abc"}}`},
+		queued:    []string{"general new movies/Heat (1995)"},
+		link:      "details?key=%2Flibrary%2Fmetadata%2F34",
+	},
+	{
+		name:      "case movie not indexed in time links to the web app",
+		responses: map[string]string{findHeat: noItems, yearHeat: noItems},
+		queued:    []string{"general new movies/Heat (1995)"},
+		link:      "desktop/web/index.html",
+	},
+	{
+		name:      "case movie not indexed in time with confirm alerts ops",
+		confirm:   true,
+		responses: map[string]string{findHeat: noItems, yearHeat: noItems},
+		queued:    []string{"admins alert alert/index/movies/Heat (1995)"},
+	},
+}
+
+func TestAnnounceIndexed(t *testing.T) {
+	for _, tt := range announceCases {
+		t.Run(tt.name, func(t *testing.T) {
+			state := setup(t, &config.Config{
+				Destinations: map[string]config.DestinationCfg{"general": {Webhook: hook}, "admins": {Webhook: hook}},
+				Routing:      config.RoutingCfg{Default: []string{"general"}, Ops: []string{"admins"}},
+			})
+			waiting = state
+			srv := stubServer(defaultServer, config.PlexServerCfg{Confirm: tt.confirm, IndexTimeout: config.Duration{Duration: 50 * time.Millisecond}}, tt.responses)
+			servers[defaultServer] = srv
+			scanned := make(chan struct{})
+			close(scanned)
+			announcement := notify.Announcement{Kind: notify.KindNew, Library: "movies", Folder: "Heat (1995)", Movie: metadata.Movie{Title: "Heat", Year: "1995"}}
+
+			AnnounceIndexed(context.Background(), srv, 3, "/movies/Heat (1995)", scanned, announcement)
+			if got := queued(); !reflect.DeepEqual(got, tt.queued) {
+				t.Errorf("AnnounceIndexed() queued %q, want %q", got, tt.queued)
+			}
+			if keys := state.Keys(waitingBucket); len(keys) != 0 {
+				t.Errorf("AnnounceIndexed() kept %v waiting", keys)
+			}
+			if len(tt.link) > 0 {
+				payload, _ := json.Marshal(deliveries.Pending()[0].Message.Payload)
+				if !strings.Contains(string(payload), tt.link) {
+					t.Errorf("AnnounceIndexed() posted %s, want a link to %s", payload, tt.link)
+				}
+			}
+		})
+	}
+}
+
+func TestAnnounceIndexed_Shutdown(t *testing.T) {
+	state := setup(t, &config.Config{
+		Destinations: map[string]config.DestinationCfg{"general": {Webhook: hook}},
+		Routing:      config.RoutingCfg{Default: []string{"general"}},
+	})
+	waiting = state
+	srv := stubServer(defaultServer, config.PlexServerCfg{IndexTimeout: config.Duration{Duration: time.Minute}}, nil)
+	servers[defaultServer] = srv
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	announcement := notify.Announcement{Kind: notify.KindNew, Library: "movies", Folder: "Heat (1995)", Movie: metadata.Movie{Title: "Heat", Year: "1995"}}
+
+	// the scan never finishes before shutdown
+	AnnounceIndexed(ctx, srv, 3, "/movies/Heat (1995)", make(chan struct{}), announcement)
+	if got := queued(); len(got) != 0 {
+		t.Errorf("AnnounceIndexed() queued %q on shutdown", got)
+	}
+	var pending indexWait
+	if found, _ := state.Get(waitingBucket, announcement.Key(), &pending); !found || pending.Section != 3 || pending.Folder != "/movies/Heat (1995)" {
+		t.Errorf("AnnounceIndexed() kept %+v waiting, want the announcement resumed on start", pending)
+	}
+}
+
+func TestResumeIndexed(t *testing.T) {
+	state := setup(t, &config.Config{
+		Destinations: map[string]config.DestinationCfg{"general": {Webhook: hook}},
+		Routing:      config.RoutingCfg{Default: []string{"general"}},
+	})
+	waiting = state
+	// the server was removed from config file meanwhile
+	announcement := notify.Announcement{Kind: notify.KindNew, Server: "office", Library: "movies", Folder: "Heat (1995)", Movie: metadata.Movie{Title: "Heat", Year: "1995"}}
+	state.Put(waitingBucket, announcement.Key(), indexWait{Server: "office", Section: 3, Folder: "/movies/Heat (1995)", Announcement: announcement})
+
+	resumeIndexed(context.Background())
+	if got, want := queued(), []string{"general new office/movies/Heat (1995)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resumeIndexed() queued %q, want %q", got, want)
+	}
+	if keys := state.Keys(waitingBucket); len(keys) != 0 {
+		t.Errorf("resumeIndexed() kept %v waiting", keys)
+	}
+}
+
+func TestWebURL(t *testing.T) {
+	setup(t, &config.Config{PlexURL: "https://app.plex.tv/desktop/"})
+	servers["office"] = &server{name: "office", webURL: "http://10.0.0.5:32400/"}
+	tests := []struct {
+		server string
+		url    string
+	}{
+		{defaultServer, "https://app.plex.tv/desktop/"},
+		{"office", "http://10.0.0.5:32400/"},
+		{"removed", "https://app.plex.tv/desktop/"},
+	}
+	for _, tt := range tests {
+		if got := webURL(tt.server); got != tt.url {
+			t.Errorf("webURL(%q) = %q, want %q", tt.server, got, tt.url)
+		}
+	}
+}
+
+func TestDigestAnnouncement(t *testing.T) {
+	setup(t, &config.Config{PlexURL: "https://app.plex.tv/desktop/"})
+	servers["office"] = &server{name: "office", webURL: "http://10.0.0.5:32400/"}
+	tests := []struct {
+		name    string
+		servers []string
+		server  string
+		url     string
+	}{
+		{"case single server", []string{"office", "office"}, "office", "http://10.0.0.5:32400/"},
+		{"case several servers", []string{"office", defaultServer}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []digest.Item
+			for _, name := range tt.servers {
+				items = append(items, digest.Item{Server: name, Movie: metadata.Movie{Title: "Heat"}})
+			}
+			announcement := digestAnnouncement(items)
+			if announcement.Server != tt.server || announcement.PlexURL != tt.url || len(announcement.Movies) != len(items) {
+				t.Errorf("digestAnnouncement() = %+v, want server %q linking to %q", announcement, tt.server, tt.url)
+			}
+		})
+	}
+}
+
+// sections are the libraries of the stubbed servers, Home Videos is
+// not a movie library and Kids Movies is in two folders
+const sections = `{"MediaContainer":{"Directory":[
+	{"key":"3","type":"movie","title":"Movies","Location":[{"path":"/data/movies"}]},
+	{"key":"4","type":"movie","title":"Kids Movies","Location":[{"path":"/data/kids"},{"path":"/mnt/cartoons"}]},
+	{"key":"5","type":"show","title":"Shows","Location":[{"path":"/data/shows"}]},
+	{"key":"6","type":"movie","title":"Home Videos","Location":[{"path":"/data/home"}]}
+]}}`
+
+var watchedCases = []struct {
+	name         string
+	plex         map[string]config.PlexLibCfg
+	server       config.PlexServerCfg
+	noAPI        bool
+	watched      []string
+	errorMessage string
+}{
+	{
+		name:    "case libraries of plex section only",
+		plex:    map[string]config.PlexLibCfg{"films": {Root: "/srv/movies", Section: 3}},
+		watched: []string{"films 3 /srv/movies"},
+	},
+	{
+		name:   "case discovered movie libraries with remapped and unmapped paths",
+		server: config.PlexServerCfg{Discover: true, Remap: map[string]string{"/data": "/srv"}},
+		watched: []string{
+			"Home Videos 6 /srv/home",
+			"Kids Movies 4 /mnt/cartoons",
+			"Kids Movies 4 /srv/kids",
+			"Movies 3 /srv/movies",
+		},
+	},
+	{
+		name:   "case section of plex section is not discovered again",
+		plex:   map[string]config.PlexLibCfg{"films": {Root: "/srv/films", Section: 3}},
+		server: config.PlexServerCfg{Discover: true, Remap: map[string]string{"/data": "/srv"}, Exclude: []string{"home videos"}},
+		watched: []string{
+			"Kids Movies 4 /mnt/cartoons",
+			"Kids Movies 4 /srv/kids",
+			"films 3 /srv/films",
+		},
+	},
+	{
+		name:   "case root already watched by another library",
+		plex:   map[string]config.PlexLibCfg{"cartoons": {Root: "/mnt/cartoons/", Section: 9}},
+		server: config.PlexServerCfg{Discover: true, Remap: map[string]string{"/data": "/srv"}, Include: []string{"Kids Movies"}},
+		watched: []string{
+			"Kids Movies 4 /srv/kids",
+			"cartoons 9 /mnt/cartoons/",
+		},
+	},
+	{
+		name:         "case unknown server",
+		plex:         map[string]config.PlexLibCfg{"films": {Root: "/srv/movies", Section: 3, Server: "office"}},
+		errorMessage: `plex.films: unknown server "office"`,
+	},
+	{
+		name:         "case discover without url and token",
+		server:       config.PlexServerCfg{Discover: true},
+		noAPI:        true,
+		errorMessage: "plex_server: discover needs url and token",
+	},
+}
+
+func TestWatchedLibraries(t *testing.T) {
+	for _, tt := range watchedCases {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Plex: tt.plex}
+			setup(t, cfg)
+			srv := stubServer(defaultServer, tt.server, map[string]string{"/library/sections": sections})
+			srv.remap = plex.Remap(tt.server.Remap)
+			if tt.noAPI {
+				srv.api = nil
+			}
+			servers[defaultServer] = srv
+
+			libraries, err := watchedLibraries(context.Background(), cfg)
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in watchedLibraries() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Fatalf("watchedLibraries() expected error %v", tt.errorMessage)
+			}
+			var watched []string
+			for _, lib := range libraries {
+				if lib.server != srv {
+					t.Errorf("watchedLibraries() library %s is not watched for %s", lib.name, srv.name)
+				}
+				watched = append(watched, fmt.Sprintf("%s %d %s", lib.name, lib.cfg.Section, lib.cfg.Root))
+			}
+			sort.Strings(watched)
+			if !reflect.DeepEqual(watched, tt.watched) {
+				t.Errorf("watchedLibraries() = %q, want %q", watched, tt.watched)
+			}
+		})
+	}
+}
+
+func TestDiscovered(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.PlexServerCfg
+		title   string
+		watched bool
+	}{
+		{"case no filter", config.PlexServerCfg{}, "Movies", true},
+		{"case included", config.PlexServerCfg{Include: []string{"movies", "Kids Movies"}}, "Movies", true},
+		{"case not included", config.PlexServerCfg{Include: []string{"Kids Movies"}}, "Movies", false},
+		{"case excluded", config.PlexServerCfg{Exclude: []string{"HOME VIDEOS"}}, "Home Videos", false},
+		{"case included and excluded", config.PlexServerCfg{Include: []string{"Movies"}, Exclude: []string{"Movies"}}, "Movies", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := discovered(tt.cfg, tt.title); got != tt.watched {
+				t.Errorf("discovered(%+v, %q) = %v, want %v", tt.cfg, tt.title, got, tt.watched)
+			}
+		})
+	}
+}
+
+func TestScannerSettings(t *testing.T) {
+	global := config.ScannerCfg{
+		Backend: "cli",
+		Quiet:   config.Duration{Duration: 10 * time.Second},
+		Command: []string{"Plex Media Scanner", "--scan"},
+		Env:     []string{"LD_LIBRARY_PATH=/usr/lib/plexmediaserver"},
+		User:    "plex",
+		Timeout: config.Duration{Duration: 30 * time.Minute},
+		History: 20,
+	}
+	tests := []struct {
+		name     string
+		named    config.ScannerCfg
+		settings config.ScannerCfg
+	}{
+		{
+			name:  "case everything taken from global but the backend",
+			named: config.ScannerCfg{Backend: "api"},
+			settings: config.ScannerCfg{Backend: "api", Quiet: global.Quiet, Command: global.Command, Env: global.Env,
+				User: "plex", Timeout: global.Timeout, History: 20},
+		},
+		{
+			name: "case own settings kept",
+			named: config.ScannerCfg{Backend: "cli", Quiet: config.Duration{Duration: time.Minute}, Command: []string{"docker", "exec", "plex"},
+				Timeout: config.Duration{Duration: time.Hour}, History: 5},
+			settings: config.ScannerCfg{Backend: "cli", Quiet: config.Duration{Duration: time.Minute}, Command: []string{"docker", "exec", "plex"},
+				Timeout: config.Duration{Duration: time.Hour}, History: 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scannerSettings(tt.named, global); !reflect.DeepEqual(got, tt.settings) {
+				t.Errorf("scannerSettings() = %+v, want %+v", got, tt.settings)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/plex"
+	"github.com/rimaulana/plexgoslack/plexhook"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+const (
+	// webhookHeaderTimeout and webhookReadTimeout are the time given
+	// to read the headers and the whole of a webhook, slow or stalled
+	// clients don't hold connections open
+	webhookHeaderTimeout = time.Second * 10
+	webhookReadTimeout   = time.Second * 30
+)
+
+// webhooks receives the webhooks of Plex, it is nil when plex_webhook
+// section doesn't set listen
+var webhooks *plexhook.Handler
+
+// newWebhookServer returns the server receiving the webhooks of Plex
+// set in plex_webhook section, it returns nil when listen is not set.
+// The webhooks already received are kept in state.
+func newWebhookServer(ctx context.Context, cfg *config.Config, state *store.Store) (*http.Server, error) {
+	hook := cfg.PlexWebhook
+	if len(hook.Listen) == 0 {
+		return nil, nil
+	}
+	if len(hook.Secret) == 0 {
+		return nil, fmt.Errorf("plex_webhook: secret is required")
+	}
+	webhooks = plexhook.New(hook.Secret, state, "plexhook", func(event plexhook.Event) {
+		track(func() {
+			AnnounceItem(ctx, serverOf(ctx, event.Server.UUID), event.Server.UUID, event.Metadata)
+		})
+	})
+	path := "/" + strings.Trim(hook.Path, "/")
+	mux := http.NewServeMux()
+	mux.Handle(path, webhooks)
+	mux.Handle(path+"/", webhooks)
+	return &http.Server{
+		Addr:              hook.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: webhookHeaderTimeout,
+		ReadTimeout:       webhookReadTimeout,
+	}, nil
+}
+
+// AnnounceItem announces the movie srv added as item, id is the
+// machine identifier of the server. The metadata providers fetch
+// the movie Plex matched using its TMDb and IMDb ids, the information
+// of Plex fills in what they don't know or is used alone when none
+// of them finds it.
+func AnnounceItem(ctx context.Context, srv *server, id string, item plex.Item) {
+	matched := item.Movie()
+	log.Println("info:", srv.title(), "added", item.Title, "to", item.SectionTitle)
+	lookup, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	movie, err := provider.Lookup(lookup, metadata.Query{
+		Title:  matched.Title,
+		Year:   matched.Year,
+		TMDbID: matched.TMDbID,
+		IMDbID: matched.IMDbID,
+	})
+	if err != nil {
+		log.Println("warning:", err)
+		movie = &matched
+	} else {
+		movie.Merge(&matched)
+	}
+	detected := time.Now()
+	if item.AddedAt > 0 {
+		detected = time.Unix(item.AddedAt, 0)
+	}
+	// the folder names the movie as the watcher of its library does
+	folder := ""
+	if files := item.Files(); len(files) > 0 {
+		folder = filepath.Base(filepath.Dir(files[0]))
+	}
+	PostToSlack(notify.Announcement{
+		Kind:       notify.KindNew,
+		Server:     srv.name,
+		Library:    libraryName(srv, item.SectionID, item.SectionTitle),
+		Folder:     folder,
+		Movie:      *movie,
+		PlexServer: id,
+		PlexKey:    item.RatingKey,
+		Detected:   detected,
+	})
+}
+
+// libraryName returns the name of the library of section of srv in
+// plex section of config file, or title when it is not there
+func libraryName(srv *server, section int, title string) string {
+	for name, lib := range conf.Plex {
+		if lib.Server == srv.name && lib.Section == section {
+			return name
+		}
+	}
+	return title
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/plex"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+func TestNewWebhookServer(t *testing.T) {
+	tests := []struct {
+		name         string
+		webhook      config.PlexWebhookCfg
+		addr         string
+		errorMessage string
+	}{
+		{name: "case listen not set", webhook: config.PlexWebhookCfg{Secret: "s3cret"}},
+		{name: "case secret not set", webhook: config.PlexWebhookCfg{Listen: ":8080"}, errorMessage: "secret is required"},
+		{name: "case listening", webhook: config.PlexWebhookCfg{Listen: ":8080", Secret: "s3cret", Path: "/plex/"}, addr: ":8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := newWebhookServer(context.Background(), &config.Config{PlexWebhook: tt.webhook}, store.Memory())
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in newWebhookServer() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Fatalf("newWebhookServer() expected error %v", tt.errorMessage)
+			}
+			if len(tt.addr) == 0 {
+				if srv != nil {
+					t.Errorf("newWebhookServer() = %v, want nil", srv)
+				}
+				return
+			}
+			if srv == nil || srv.Addr != tt.addr || srv.ReadHeaderTimeout != webhookHeaderTimeout {
+				t.Errorf("newWebhookServer() = %+v, want a server listening on %s", srv, tt.addr)
+			}
+		})
+	}
+}
+
+func TestLibraryName(t *testing.T) {
+	setup(t, &config.Config{Plex: map[string]config.PlexLibCfg{
+		"movies": {Section: 3},
+		"office": {Server: "office", Section: 3},
+	}})
+	office := &server{name: "office"}
+	tests := []struct {
+		srv     *server
+		section int
+		name    string
+	}{
+		{servers[defaultServer], 3, "movies"},
+		{office, 3, "office"},
+		{office, 4, "Documentaries"},
+	}
+	for _, tt := range tests {
+		if got := libraryName(tt.srv, tt.section, "Documentaries"); got != tt.name {
+			t.Errorf("libraryName(%s, %d) = %q, want %q", tt.srv.name, tt.section, got, tt.name)
+		}
+	}
+}
+
+func TestAnnounceItem(t *testing.T) {
+	setup(t, &config.Config{
+		Destinations: map[string]config.DestinationCfg{"general": {Webhook: hook}},
+		Routing:      config.RoutingCfg{Default: []string{"general"}},
+		Plex:         map[string]config.PlexLibCfg{"movies": {Section: 3}},
+		PlexURL:      "https://app.plex.tv/desktop/",
+	})
+	provider = &providerStub{genres: []string{"Crime"}}
+	item := plex.Item{
+		RatingKey:    "34",
+		Title:        "Heat",
+		Year:         1995,
+		SectionID:    3,
+		SectionTitle: "Movies",
+		GUIDs:        []plex.GUID{{ID: "tmdb://949"}},
+		Media:        []plex.Media{{Parts: []plex.Part{{File: "/movies/Heat (1995)/Heat.mkv"}}}},
+	}
+
+	AnnounceItem(context.Background(), servers[defaultServer], "abc", item)
+	if got, want := queued(), []string{"general new movies/Heat (1995)"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("AnnounceItem() queued %q, want %q", got, want)
+	}
+	payload, _ := json.Marshal(deliveries.Pending()[0].Message.Payload)
+	if !strings.Contains(string(payload), "%2Flibrary%2Fmetadata%2F34") {
+		t.Errorf("AnnounceItem() posted %s, want a link to the movie", payload)
+	}
+}
@@ -0,0 +1,120 @@
+// Package recent polls Plex Media Server for the movies recently added
+// to its libraries, so that the program can run on another machine
+// than Plex with nothing more than a token. Where the polling stopped
+// is kept in a persistent store so that a restart neither misses nor
+// repeats a movie.
+package recent
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/plex"
+)
+
+// Source lists the items recently added to Plex, plex.Plex implements it
+type Source interface {
+	RecentlyAdded(ctx context.Context) ([]plex.Item, error)
+}
+
+// Store keeps where the polling stopped, store.Store implements it
+type Store interface {
+	Get(bucket string, key string, value interface{}) (bool, error)
+	Put(bucket string, key string, value interface{}) error
+}
+
+// Announce is called with every movie added since the previous poll
+type Announce func(item plex.Item)
+
+// mark tells where the polling stopped: the time the latest item seen
+// was added, and the items added at that time since more of them can
+// show up in the same second
+type mark struct {
+	AddedAt int64
+	Keys    []string
+}
+
+// Poller announces the movies added to Plex
+type Poller struct {
+	source   Source
+	store    Store
+	bucket   string
+	key      string
+	announce Announce
+}
+
+// New creates a Poller for source, keeping where it stopped under key
+// in bucket of store
+func New(source Source, store Store, bucket string, key string, announce Announce) *Poller {
+	return &Poller{
+		source:   source,
+		store:    store,
+		bucket:   bucket,
+		key:      key,
+		announce: announce,
+	}
+}
+
+// Run polls every interval until ctx is cancelled
+func (poller *Poller) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := poller.Poll(ctx); err != nil && ctx.Err() == nil {
+			log.Println("error: polling Plex for recently added movies:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll announces the movies added since the previous poll in the
+// order they were added. The first poll only remembers the latest
+// movie, the ones added before the program first ran are not
+// announced.
+func (poller *Poller) Poll(ctx context.Context) error {
+	items, err := poller.source.RecentlyAdded(ctx)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].AddedAt < items[j].AddedAt
+	})
+	var last mark
+	found, err := poller.store.Get(poller.bucket, poller.key, &last)
+	if err != nil {
+		return err
+	}
+	next := last
+	for _, item := range items {
+		if item.AddedAt < last.AddedAt || (item.AddedAt == last.AddedAt && contains(last.Keys, item.RatingKey)) {
+			continue
+		}
+		if found && item.Type == "movie" {
+			poller.announce(item)
+		}
+		if item.AddedAt > next.AddedAt {
+			next = mark{AddedAt: item.AddedAt}
+		}
+		next.Keys = append(next.Keys, item.RatingKey)
+	}
+	if found && next.AddedAt == last.AddedAt && len(next.Keys) == len(last.Keys) {
+		return nil
+	}
+	return poller.store.Put(poller.bucket, poller.key, next)
+}
+
+// contains tells whether keys has key
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package recent
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/plex"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+type sourceStub struct {
+	items []plex.Item
+	err   error
+}
+
+func (source *sourceStub) RecentlyAdded(ctx context.Context) ([]plex.Item, error) {
+	return append([]plex.Item(nil), source.items...), source.err
+}
+
+func item(key string, kind string, addedAt int64) plex.Item {
+	return plex.Item{RatingKey: key, Type: kind, Title: "movie " + key, AddedAt: addedAt}
+}
+
+func TestPoller_Poll(t *testing.T) {
+	source := &sourceStub{}
+	var announced []string
+	poller := New(source, store.Memory(), "recent", "plex", func(item plex.Item) {
+		announced = append(announced, item.RatingKey)
+	})
+	polls := []struct {
+		name      string
+		items     []plex.Item
+		err       error
+		announced []string
+	}{
+		{
+			name:  "case first poll announces nothing",
+			items: []plex.Item{item("2", "movie", 200), item("1", "movie", 100)},
+		},
+		{
+			name:  "case nothing added",
+			items: []plex.Item{item("2", "movie", 200), item("1", "movie", 100)},
+		},
+		{
+			name:      "case added in order, episodes skipped",
+			items:     []plex.Item{item("5", "movie", 300), item("4", "episode", 250), item("3", "movie", 200), item("2", "movie", 200)},
+			announced: []string{"3", "5"},
+		},
+		{
+			name:      "case added in the same second",
+			items:     []plex.Item{item("6", "movie", 300), item("5", "movie", 300), item("3", "movie", 200)},
+			announced: []string{"6"},
+		},
+		{
+			name: "case plex unreachable",
+			err:  fmt.Errorf("connection refused"),
+		},
+		{
+			name:      "case movie removed from the list",
+			items:     []plex.Item{item("7", "movie", 400), item("6", "movie", 300)},
+			announced: []string{"7"},
+		},
+	}
+	for _, tt := range polls {
+		announced = nil
+		source.items, source.err = tt.items, tt.err
+		err := poller.Poll(context.Background())
+		if err != tt.err {
+			t.Errorf("%s: Poll() error = %v, want %v", tt.name, err, tt.err)
+		}
+		if !reflect.DeepEqual(announced, tt.announced) {
+			t.Errorf("%s: Poll() announced %v, want %v", tt.name, announced, tt.announced)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package route picks the destinations an announcement is sent to,
+// using rules matching the server, library, event type and movie
+// information.
+package route
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/notify"
+)
+
+// Store keeps the destinations every movie was announced to,
+// store.Store implements it
+type Store interface {
+	Get(bucket string, key string, value interface{}) (bool, error)
+	Put(bucket string, key string, value interface{}) error
+	Delete(bucket string, key string) error
+	Prune(bucket string, expired func(key string, value json.RawMessage) bool) (int, error)
+}
+
+// posted is where a movie was announced
+type posted struct {
+	Destinations []string  `json:"destinations"`
+	Posted       time.Time `json:"posted"`
+}
+
+// Rule sends announcements to its Destinations when they match every
+// condition set in the rule. A condition holding several values
+// matches when any of them matches, an empty condition always matches.
+type Rule struct {
+	Servers        []string
+	Libraries      []string
+	Events         []string
+	Genres         []string
+	Certifications []string
+	Resolutions    []string
+	Title          *regexp.Regexp
+	Destinations   []string
+}
+
+// Router holds the routing rules along with the default destinations
+// used for announcements no rule matches. When State is set, the
+// destinations of new movies are kept in its Bucket so that their
+// removal and upgrade go to the same places.
+type Router struct {
+	Rules   []Rule
+	Default []string
+	State   Store
+	Bucket  string
+}
+
+// New creates new instance of Router
+func New(rules []Rule, defaults []string) *Router {
+	return &Router{
+		Rules:   rules,
+		Default: defaults,
+	}
+}
+
+// Route returns the destinations of every rule matching announcement,
+// each destination once and in the order the rules are listed. The
+// default destinations are returned when no rule matches. A removed
+// or upgraded movie is only known by its folder or has new files, it
+// goes to the destinations it was announced to instead, along with
+// the ones of the rules naming its event.
+func (router *Router) Route(announcement notify.Announcement) ([]string, error) {
+	if router.State == nil || announcement.Kind != notify.KindNew && announcement.Kind != notify.KindRemoved && announcement.Kind != notify.KindUpgraded {
+		return router.match(announcement), nil
+	}
+	key := announcement.Key()
+	if announcement.Kind == notify.KindNew {
+		destinations := router.match(announcement)
+		return destinations, router.State.Put(router.Bucket, key, posted{destinations, time.Now()})
+	}
+	var before posted
+	found, err := router.State.Get(router.Bucket, key, &before)
+	if err != nil || !found {
+		return router.match(announcement), err
+	}
+	destinations := append([]string(nil), before.Destinations...)
+	for _, rule := range router.Rules {
+		if len(rule.Events) > 0 && rule.Match(announcement) {
+			destinations = appendNew(destinations, rule.Destinations...)
+		}
+	}
+	if announcement.Kind == notify.KindRemoved {
+		return destinations, router.State.Delete(router.Bucket, key)
+	}
+	return destinations, nil
+}
+
+// Prune forgets where the movies announced before before were
+// announced, it returns how many were forgotten
+func (router *Router) Prune(before time.Time) (int, error) {
+	if router.State == nil {
+		return 0, nil
+	}
+	return router.State.Prune(router.Bucket, func(key string, value json.RawMessage) bool {
+		var p posted
+		if err := json.Unmarshal(value, &p); err != nil {
+			return true
+		}
+		return p.Posted.Before(before)
+	})
+}
+
+// match returns the destinations of the rules matching announcement,
+// the default ones when none matches
+func (router *Router) match(announcement notify.Announcement) []string {
+	var destinations []string
+	for _, rule := range router.Rules {
+		if rule.Match(announcement) {
+			destinations = appendNew(destinations, rule.Destinations...)
+		}
+	}
+	if len(destinations) == 0 {
+		return router.Default
+	}
+	return destinations
+}
+
+// appendNew appends the names not in destinations yet
+func appendNew(destinations []string, names ...string) []string {
+	for _, name := range names {
+		found := false
+		for _, d := range destinations {
+			found = found || d == name
+		}
+		if !found {
+			destinations = append(destinations, name)
+		}
+	}
+	return destinations
+}
+
+// Match tells whether announcement matches every condition of rule
+func (rule Rule) Match(announcement notify.Announcement) bool {
+	movie := announcement.Movie
+	return matchAny(rule.Servers, announcement.Server) &&
+		matchAny(rule.Libraries, announcement.Library) &&
+		matchAny(rule.Events, string(announcement.Kind)) &&
+		matchAny(rule.Genres, movie.Genres...) &&
+		matchAny(rule.Certifications, movie.Certification) &&
+		matchAny(rule.Resolutions, movie.Resolution) &&
+		(rule.Title == nil || rule.Title.MatchString(movie.Title))
+}
+
+// matchAny tells whether one of values is one of the accepted values,
+// ignoring case. Everything is accepted when accepted is empty.
+func matchAny(accepted []string, values ...string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, a := range accepted {
+		for _, v := range values {
+			if strings.EqualFold(a, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package route
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+var router = New([]Rule{
+	{Libraries: []string{"tutorials"}, Destinations: []string{"learning"}},
+	{Resolutions: []string{"2160p"}, Destinations: []string{"home-theater"}},
+	{Genres: []string{"animation"}, Certifications: []string{"G", "PG"}, Destinations: []string{"kids", "general"}},
+	{Title: regexp.MustCompile("(?i)^star wars"), Events: []string{"new"}, Destinations: []string{"general", "star-wars"}},
+	{Servers: []string{"office"}, Destinations: []string{"office"}},
+}, []string{"general"})
+
+var cases = []struct {
+	name         string
+	announcement notify.Announcement
+	destinations []string
+}{
+	{
+		name:         "case routed by library",
+		announcement: notify.Announcement{Kind: notify.KindNew, Library: "tutorials", Movie: metadata.Movie{Title: "Go in Action"}},
+		destinations: []string{"learning"},
+	},
+	{
+		name:         "case routed by server",
+		announcement: notify.Announcement{Kind: notify.KindNew, Server: "office", Library: "movies", Movie: metadata.Movie{Title: "Heat"}},
+		destinations: []string{"office"},
+	},
+	{
+		name:         "case routed by resolution",
+		announcement: notify.Announcement{Kind: notify.KindNew, Library: "movies", Movie: metadata.Movie{Title: "Dune", Resolution: "2160p"}},
+		destinations: []string{"home-theater"},
+	},
+	{
+		name:         "case routed by genre and certification to several destinations",
+		announcement: notify.Announcement{Kind: notify.KindNew, Library: "movies", Movie: metadata.Movie{Title: "Coco", Genres: []string{"Animation", "Family"}, Certification: "PG"}},
+		destinations: []string{"kids", "general"},
+	},
+	{
+		name:         "case genre matches but certification doesn't",
+		announcement: notify.Announcement{Kind: notify.KindNew, Library: "movies", Movie: metadata.Movie{Title: "Akira", Genres: []string{"Animation"}, Certification: "R"}},
+		destinations: []string{"general"},
+	},
+	{
+		name:         "case several rules match",
+		announcement: notify.Announcement{Kind: notify.KindNew, Library: "movies", Movie: metadata.Movie{Title: "Star Wars", Resolution: "2160p"}},
+		destinations: []string{"home-theater", "general", "star-wars"},
+	},
+	{
+		name:         "case event type doesn't match",
+		announcement: notify.Announcement{Kind: notify.KindRemoved, Library: "movies", Movie: metadata.Movie{Title: "Star Wars"}},
+		destinations: []string{"general"},
+	},
+}
+
+func TestRouter_Route(t *testing.T) {
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := router.Route(tt.announcement); !reflect.DeepEqual(got, tt.destinations) {
+				t.Errorf("Route() = %v, want %v", got, tt.destinations)
+			}
+		})
+	}
+}
+
+func TestRouter_RouteRecorded(t *testing.T) {
+	router := New([]Rule{
+		{Genres: []string{"animation"}, Destinations: []string{"kids"}},
+		{Events: []string{"removed"}, Destinations: []string{"archive"}},
+	}, []string{"general"})
+	router.State, router.Bucket = store.Memory(), "routes"
+
+	coco := metadata.Movie{Title: "Coco", Year: "2017", Genres: []string{"Animation"}}
+	announced := notify.Announcement{Kind: notify.KindNew, Library: "movies", Folder: "Coco (2017)", Movie: coco}
+	// removed movies are only known by their folder
+	removed := notify.Announcement{Kind: notify.KindRemoved, Library: "movies", Folder: "Coco (2017)", Movie: metadata.Movie{Title: "Coco", Year: "2017"}}
+	upgraded := notify.Announcement{Kind: notify.KindUpgraded, Library: "movies", Folder: "Coco (2017)", Movie: metadata.Movie{Title: "Coco", Year: "2017", Resolution: "2160p"}}
+
+	tests := []struct {
+		name         string
+		announcement notify.Announcement
+		destinations []string
+	}{
+		{"case removal never announced", removed, []string{"archive"}},
+		{"case new", announced, []string{"kids"}},
+		{"case upgrade goes where the movie was announced", upgraded, []string{"kids"}},
+		{"case removal goes where the movie was announced", removed, []string{"kids", "archive"}},
+		{"case removal forgets where the movie was announced", removed, []string{"archive"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := router.Route(tt.announcement)
+			if err != nil {
+				t.Fatalf("Route() unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.destinations) {
+				t.Errorf("Route() = %v, want %v", got, tt.destinations)
+			}
+		})
+	}
+}
+
+func TestRouter_Prune(t *testing.T) {
+	router := New(nil, []string{"general"})
+	router.State, router.Bucket = store.Memory(), "routes"
+	router.State.Put("routes", "movies/old", posted{[]string{"kids"}, time.Now().Add(-48 * time.Hour)})
+	router.State.Put("routes", "movies/new", posted{[]string{"kids"}, time.Now()})
+
+	removed, err := router.Prune(time.Now().Add(-24 * time.Hour))
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune() = %d, %v, want 1, nil", removed, err)
+	}
+	if found, _ := router.State.Get("routes", "movies/new", &posted{}); !found {
+		t.Error("Prune() forgot the movie announced today")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/digest"
+	"github.com/rimaulana/plexgoslack/notify"
+	"github.com/rimaulana/plexgoslack/outbox"
+	"github.com/rimaulana/plexgoslack/route"
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+// loadDestinations returns the destinations of config file along with
+// one destination for each of the webhooks listed in slack section,
+// named webhook1, webhook2 and so on
+func loadDestinations(cfg *config.Config) (map[string]config.DestinationCfg, []string) {
+	loaded := map[string]config.DestinationCfg{}
+	for name, destination := range cfg.Destinations {
+		loaded[name] = destination
+	}
+	var webhooks []string
+	for i, hook := range cfg.Slack.Webhook {
+		name := fmt.Sprintf("webhook%d", i+1)
+		loaded[name] = config.DestinationCfg{Webhook: hook}
+		webhooks = append(webhooks, name)
+	}
+	return loaded, webhooks
+}
+
+// newRouter compiles the routes of config file. Announcements no route
+// matches go to the default destinations of routing section, or to
+// every webhook listed in slack section when there is none.
+func newRouter(cfg *config.Config, destinations map[string]config.DestinationCfg, webhooks []string) (*route.Router, error) {
+	defaults := cfg.Routing.Default
+	if len(defaults) == 0 {
+		defaults = webhooks
+	}
+	if err := checkDestinations(destinations, defaults); err != nil {
+		return nil, fmt.Errorf("default route: %s", err)
+	}
+	if err := checkDestinations(destinations, cfg.Routing.Ops); err != nil {
+		return nil, fmt.Errorf("ops: %s", err)
+	}
+	var rules []route.Rule
+	for i, r := range cfg.Routes {
+		if err := checkDestinations(destinations, r.Destinations); err != nil {
+			return nil, fmt.Errorf("route %d: %s", i+1, err)
+		}
+		rule := route.Rule{
+			Servers:        r.Servers,
+			Libraries:      r.Libraries,
+			Events:         r.Events,
+			Genres:         r.Genres,
+			Certifications: r.Certifications,
+			Resolutions:    r.Resolutions,
+			Destinations:   r.Destinations,
+		}
+		if len(r.Title) > 0 {
+			title, err := regexp.Compile(r.Title)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: invalid title: %s", i+1, err)
+			}
+			rule.Title = title
+		}
+		rules = append(rules, rule)
+	}
+	return route.New(rules, defaults), nil
+}
+
+// checkDestinations makes sure every name is a configured destination
+func checkDestinations(destinations map[string]config.DestinationCfg, names []string) error {
+	for _, name := range names {
+		if _, ok := destinations[name]; !ok {
+			return fmt.Errorf("unknown destination %q", name)
+		}
+	}
+	return nil
+}
+
+// newSenders returns what delivers messages to every destination, a
+// webhook or a channel posted to with the bot token of slack section
+func newSenders(cfg *config.Config, destinations map[string]config.DestinationCfg, state notify.State) (map[string]notify.Destination, error) {
+	senders := map[string]notify.Destination{}
+	client, err := newTransport(cfg, cfg.Slack.Proxy, cfg.Slack.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("slack: %s", err)
+	}
+	var api *notify.API
+	for name, destination := range destinations {
+		switch {
+		case len(destination.Webhook) > 0 && len(destination.Channel) > 0:
+			return nil, fmt.Errorf("destination %q: set either webhook or channel", name)
+		case len(destination.Webhook) > 0:
+			senders[name] = notify.NewWebhook(destination.Webhook, client)
+		case len(destination.Channel) > 0:
+			if len(cfg.Slack.BotToken) == 0 {
+				return nil, fmt.Errorf("destination %q: channel needs bot_token in slack section", name)
+			}
+			switch destination.OnRemove {
+			case "", notify.RemoveStrike, notify.RemoveDelete, notify.RemovePost:
+			default:
+				return nil, fmt.Errorf("destination %q: unknown on_remove %q", name, destination.OnRemove)
+			}
+			if api == nil {
+				api = notify.NewAPI(cfg.Slack.BotToken, cfg.Slack.APIURL, client)
+			}
+			senders[name] = &notify.Channel{
+				API:      api,
+				Channel:  destination.Channel,
+				OnRemove: destination.OnRemove,
+				State:    state,
+			}
+		default:
+			return nil, fmt.Errorf("destination %q: missing webhook or channel", name)
+		}
+	}
+	return senders, nil
+}
+
+// newOutbox returns the outbox delivering messages to destinations,
+// keeping them in state
+func newOutbox(cfg *config.Config, destinations map[string]config.DestinationCfg, state *store.Store) (*outbox.Outbox, error) {
+	senders, err := newSenders(cfg, destinations, state)
+	if err != nil {
+		return nil, err
+	}
+	options := []outbox.Option{
+		outbox.WithBackoff(cfg.Outbox.MinBackoff.Duration, cfg.Outbox.MaxBackoff.Duration),
+		outbox.WithExpiry(cfg.Outbox.Expire.Duration),
+		outbox.WithRate(outbox.Rate{PerSecond: cfg.Slack.Rate, Burst: cfg.Slack.Burst}),
+	}
+	for name, destination := range destinations {
+		if destination.Rate > 0 {
+			options = append(options, outbox.WithDestinationRate(name, outbox.Rate{PerSecond: destination.Rate, Burst: destination.Burst}))
+		}
+	}
+	return outbox.New(state, senders, options...), nil
+}
+
+// newSchedules returns the digest schedule of every destination
+// posting digests
+func newSchedules(destinations map[string]config.DestinationCfg) (map[string]digest.Window, error) {
+	schedules := map[string]digest.Window{}
+	for name, destination := range destinations {
+		if len(destination.Digest) == 0 {
+			continue
+		}
+		schedule, err := digest.ParseSchedule(destination.Digest, destination.DigestAt, destination.DigestDay)
+		if err != nil {
+			return nil, fmt.Errorf("destination %q: %s", name, err)
+		}
+		schedule.Location, err = timeZone(destination)
+		if err != nil {
+			return nil, fmt.Errorf("destination %q: %s", name, err)
+		}
+		schedules[name] = schedule
+	}
+	return schedules, nil
+}
+
+// newQuietHours returns the quiet hours of every destination having
+// them, along with the ones merging the messages held meanwhile
+func newQuietHours(destinations map[string]config.DestinationCfg) (map[string]digest.QuietHours, map[string]digest.Window, error) {
+	quiet := map[string]digest.QuietHours{}
+	merged := map[string]digest.Window{}
+	for name, destination := range destinations {
+		if len(destination.QuietHours) == 0 {
+			continue
+		}
+		location, err := timeZone(destination)
+		if err != nil {
+			return nil, nil, fmt.Errorf("destination %q: %s", name, err)
+		}
+		hours, err := digest.ParseQuietHours(destination.QuietHours, location)
+		if err != nil {
+			return nil, nil, fmt.Errorf("destination %q: %s", name, err)
+		}
+		quiet[name] = hours
+		if destination.QuietMerge {
+			merged[name] = hours
+		}
+	}
+	return quiet, merged, nil
+}
+
+// timeZone returns the time zone of destination, local time when it
+// doesn't set any
+func timeZone(destination config.DestinationCfg) (*time.Location, error) {
+	if len(destination.TimeZone) == 0 {
+		return time.Local, nil
+	}
+	return time.LoadLocation(destination.TimeZone)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+)
+
+// hook is the webhook of the destinations of tests
+const hook = "https://hooks.slack.com/services/T/B/x"
+
+func TestLoadDestinations(t *testing.T) {
+	cfg := &config.Config{
+		Slack:        config.SlackCfg{Webhook: []string{"https://hooks.slack.com/1", "https://hooks.slack.com/2"}},
+		Destinations: map[string]config.DestinationCfg{"kids": {Channel: "#kids"}},
+	}
+	loaded, webhooks := loadDestinations(cfg)
+	if want := []string{"webhook1", "webhook2"}; !reflect.DeepEqual(webhooks, want) {
+		t.Errorf("loadDestinations() webhooks = %v, want %v", webhooks, want)
+	}
+	var names []string
+	for name := range loaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"kids", "webhook1", "webhook2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("loadDestinations() = %v, want %v", names, want)
+	}
+	if loaded["webhook2"].Webhook != "https://hooks.slack.com/2" {
+		t.Errorf("loadDestinations() webhook2 = %+v, want the second webhook", loaded["webhook2"])
+	}
+}
+
+var routerCases = []struct {
+	name         string
+	routing      config.RoutingCfg
+	routes       []config.RouteCfg
+	webhooks     []string
+	coco         []string
+	heat         []string
+	errorMessage string
+}{
+	{
+		name:    "case routed by genre, the others to default",
+		routing: config.RoutingCfg{Default: []string{"general"}},
+		routes:  []config.RouteCfg{{Genres: []string{"animation"}, Destinations: []string{"kids"}}},
+		coco:    []string{"kids"},
+		heat:    []string{"general"},
+	},
+	{
+		name:    "case routed by title",
+		routing: config.RoutingCfg{Default: []string{"general"}},
+		routes:  []config.RouteCfg{{Title: "(?i)^heat$", Destinations: []string{"kids", "general"}}},
+		coco:    []string{"general"},
+		heat:    []string{"kids", "general"},
+	},
+	{
+		name:     "case webhooks of slack section are the default",
+		webhooks: []string{"webhook1"},
+		coco:     []string{"webhook1"},
+		heat:     []string{"webhook1"},
+	},
+	{
+		name:         "case unknown default destination",
+		routing:      config.RoutingCfg{Default: []string{"movies"}},
+		errorMessage: `default route: unknown destination "movies"`,
+	},
+	{
+		name:         "case unknown ops destination",
+		routing:      config.RoutingCfg{Default: []string{"general"}, Ops: []string{"admins"}},
+		errorMessage: `ops: unknown destination "admins"`,
+	},
+	{
+		name:         "case unknown route destination",
+		routes:       []config.RouteCfg{{Genres: []string{"animation"}, Destinations: []string{"children"}}},
+		errorMessage: `route 1: unknown destination "children"`,
+	},
+	{
+		name:         "case invalid title",
+		routes:       []config.RouteCfg{{Title: "(heat", Destinations: []string{"general"}}},
+		errorMessage: "route 1: invalid title",
+	},
+}
+
+func TestNewRouter(t *testing.T) {
+	destinations := map[string]config.DestinationCfg{"general": {Webhook: hook}, "kids": {Webhook: hook}, "webhook1": {Webhook: hook}}
+	coco := notify.Announcement{Kind: notify.KindNew, Library: "movies", Movie: metadata.Movie{Title: "Coco", Genres: []string{"Animation"}}}
+	heat := notify.Announcement{Kind: notify.KindNew, Library: "movies", Movie: metadata.Movie{Title: "Heat", Genres: []string{"Crime"}}}
+	for _, tt := range routerCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newRouter(&config.Config{Routing: tt.routing, Routes: tt.routes}, destinations, tt.webhooks)
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in newRouter() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Fatalf("newRouter() expected error %v", tt.errorMessage)
+			}
+			if got, _ := r.Route(coco); !reflect.DeepEqual(got, tt.coco) {
+				t.Errorf("Route(coco) = %v, want %v", got, tt.coco)
+			}
+			if got, _ := r.Route(heat); !reflect.DeepEqual(got, tt.heat) {
+				t.Errorf("Route(heat) = %v, want %v", got, tt.heat)
+			}
+		})
+	}
+}
+
+func TestNewSenders(t *testing.T) {
+	tests := []struct {
+		name         string
+		destination  config.DestinationCfg
+		botToken     string
+		errorMessage string
+	}{
+		{name: "case webhook", destination: config.DestinationCfg{Webhook: hook}},
+		{name: "case channel", destination: config.DestinationCfg{Channel: "#movies", OnRemove: notify.RemovePost}, botToken: "xoxb-test"},
+		{name: "case webhook and channel", destination: config.DestinationCfg{Webhook: hook, Channel: "#movies"}, errorMessage: "set either webhook or channel"},
+		{name: "case channel without bot token", destination: config.DestinationCfg{Channel: "#movies"}, errorMessage: "channel needs bot_token"},
+		{name: "case unknown on_remove", destination: config.DestinationCfg{Channel: "#movies", OnRemove: "hide"}, botToken: "xoxb-test", errorMessage: `unknown on_remove "hide"`},
+		{name: "case neither webhook nor channel", errorMessage: "missing webhook or channel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Slack: config.SlackCfg{BotToken: tt.botToken}}
+			senders, err := newSenders(cfg, map[string]config.DestinationCfg{"movies": tt.destination}, nil)
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in newSenders() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 || senders["movies"] == nil {
+				t.Errorf("newSenders() = %v, want error %v", senders, tt.errorMessage)
+			}
+		})
+	}
+}
+
+func TestNewQuietHours(t *testing.T) {
+	destinations := map[string]config.DestinationCfg{
+		"general": {Webhook: hook, QuietHours: "22:00-07:00", QuietMerge: true, TimeZone: "Europe/Paris"},
+		"kids":    {Webhook: hook, QuietHours: "20:00-08:00"},
+		"ops":     {Webhook: hook},
+	}
+	quiet, merged, err := newQuietHours(destinations)
+	if err != nil {
+		t.Fatalf("newQuietHours() unexpected error %v", err)
+	}
+	if len(quiet) != 2 || quiet["general"].Location.String() != "Europe/Paris" {
+		t.Errorf("newQuietHours() = %v, want the quiet hours of general and kids", quiet)
+	}
+	if _, ok := merged["general"]; !ok || len(merged) != 1 {
+		t.Errorf("newQuietHours() merged = %v, want general", merged)
+	}
+
+	for _, invalid := range []config.DestinationCfg{
+		{Webhook: hook, QuietHours: "22:00"},
+		{Webhook: hook, QuietHours: "22:00-07:00", TimeZone: "Mars/Olympus"},
+	} {
+		if _, _, err := newQuietHours(map[string]config.DestinationCfg{"general": invalid}); err == nil || !strings.Contains(err.Error(), `destination "general"`) {
+			t.Errorf("newQuietHours(%+v) error = %v, want an error naming the destination", invalid, err)
+		}
+	}
+}
+
+func TestNewSchedules(t *testing.T) {
+	schedules, err := newSchedules(map[string]config.DestinationCfg{
+		"general": {Webhook: hook, Digest: "daily", DigestAt: "20:00"},
+		"ops":     {Webhook: hook},
+	})
+	if err != nil {
+		t.Fatalf("newSchedules() unexpected error %v", err)
+	}
+	if _, ok := schedules["general"]; !ok || len(schedules) != 1 {
+		t.Errorf("newSchedules() = %v, want the schedule of general", schedules)
+	}
+	if _, err := newSchedules(map[string]config.DestinationCfg{"general": {Webhook: hook, Digest: "fortnightly"}}); err == nil {
+		t.Error("newSchedules() expected an error for an unknown schedule")
+	}
+}
@@ -0,0 +1,65 @@
+package scanner
+
+import (
+	"strings"
+	"time"
+)
+
+// historyKey is the key the history is kept under in its bucket
+const historyKey = "history"
+
+// Store keeps the history of the scans, store.Store implements it
+type Store interface {
+	Get(bucket string, key string, value interface{}) (bool, error)
+	Put(bucket string, key string, value interface{}) error
+}
+
+// Record describes a scan that ran. Output is only known for Runner
+// scanners, ExitCode is -1 otherwise. Error is empty when the scan
+// succeeded.
+type Record struct {
+	Section  int           `json:"section"`
+	Folder   string        `json:"folder"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exit_code"`
+	Stdout   string        `json:"stdout,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Failed tells whether the scan failed
+func (record Record) Failed() bool {
+	return len(record.Error) > 0
+}
+
+// StderrTail returns the last lines of what the scan printed on
+// stderr
+func (record Record) StderrTail(lines int) string {
+	all := strings.Split(strings.TrimRight(record.Stderr, "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n")
+}
+
+// History returns the scans kept in bucket of store, the latest first
+func History(store Store, bucket string) ([]Record, error) {
+	var records []Record
+	_, err := store.Get(bucket, historyKey, &records)
+	return records, err
+}
+
+// remember adds record to the history of bucket in store, keeping the
+// latest size records
+func remember(store Store, bucket string, size int, record Record) error {
+	records, err := History(store, bucket)
+	if err != nil {
+		return err
+	}
+	records = append([]Record{record}, records...)
+	if len(records) > size {
+		records = records[:size]
+	}
+	return store.Put(bucket, historyKey, records)
+}
@@ -0,0 +1,50 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+func TestHistory(t *testing.T) {
+	state := store.Memory()
+	for section := 1; section <= 3; section++ {
+		if err := remember(state, "scans", 2, Record{Section: section}); err != nil {
+			t.Fatalf("remember() error = %v", err)
+		}
+	}
+	records, err := History(state, "scans")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(records) != 2 || records[0].Section != 3 || records[1].Section != 2 {
+		t.Errorf("History() = %+v, want the scans of sections 3 and 2", records)
+	}
+}
+
+var stderrTailCases = []struct {
+	name   string
+	stderr string
+	want   string
+}{
+	{
+		name:   "case short",
+		stderr: "error: no such section\n",
+		want:   "error: no such section",
+	},
+	{
+		name:   "case long",
+		stderr: "one\ntwo\nthree\nfour\n",
+		want:   "three\nfour",
+	},
+}
+
+func TestRecord_StderrTail(t *testing.T) {
+	for _, tt := range stderrTailCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Record{Stderr: tt.stderr}).StderrTail(2); got != tt.want {
+				t.Errorf("StderrTail() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
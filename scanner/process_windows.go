@@ -0,0 +1,21 @@
+package scanner
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// procAttr can't switch users on windows, run the program as the
+// user instead
+func procAttr(username string) (*syscall.SysProcAttr, error) {
+	if len(username) > 0 {
+		return nil, fmt.Errorf("running as %s is not supported on windows", username)
+	}
+	return nil, nil
+}
+
+// kill kills the process of cmd
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
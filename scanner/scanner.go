@@ -0,0 +1,219 @@
+// Package scanner asks Plex Media Server to scan the folders of its
+// libraries so that it indexes the movies added to them, either
+// through its HTTP API or by running a command such as the Plex Media
+// Scanner command line tool.
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/template"
+)
+
+const (
+	// BackendAPI scans through the HTTP API of Plex Media Server
+	BackendAPI = "api"
+	// BackendCLI scans by running a command, Plex Media Scanner by
+	// default
+	BackendCLI = "cli"
+)
+
+// maxOutput is how much of the end of the output of a command is kept
+const maxOutput = 4 << 10
+
+// Scanner asks Plex to scan a library section, only folder when it
+// is not empty
+type Scanner interface {
+	Scan(ctx context.Context, section int, folder string) error
+}
+
+// Runner is a Scanner running a command, it tells what the command
+// printed and how it exited. CLI implements it.
+type Runner interface {
+	Run(ctx context.Context, section int, folder string) (Output, error)
+}
+
+// Output is what a command printed, the end of it when it printed
+// more than maxOutput, and its exit code, -1 when it didn't exit by
+// itself
+type Output struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// tail is an io.Writer keeping the last max bytes written to it
+type tail struct {
+	max  int
+	data []byte
+	cut  bool
+}
+
+// Write implements io.Writer
+func (t *tail) Write(p []byte) (int, error) {
+	t.data = append(t.data, p...)
+	if len(t.data) > t.max {
+		t.data = t.data[:copy(t.data, t.data[len(t.data)-t.max:])]
+		t.cut = true
+	}
+	return len(p), nil
+}
+
+// String returns what was kept, starting with an ellipsis when the
+// beginning was dropped
+func (t *tail) String() string {
+	if t.cut {
+		return "..." + string(t.data)
+	}
+	return string(t.data)
+}
+
+// DefaultArgs runs Plex Media Scanner as plex user on the Plex host,
+// with LD_LIBRARY_PATH pointing to the folder of Plex Media Server
+var DefaultArgs = []string{
+	"sudo", "-u", "plex", "-E", "-H", `{{env "LD_LIBRARY_PATH"}}/Plex Media Scanner`,
+	"--scan", "--refresh", "--section", "{{.Section}}",
+}
+
+// Command describes how the scanner is run. Every argument of Args is
+// a template given the Section number and the Path of the folder to
+// scan, empty when the whole section is scanned, env function returns
+// an environment variable. Arguments rendered empty are dropped. Env
+// is added to the environment of the program, the command runs as
+// User in Dir when they are set.
+type Command struct {
+	Args []string
+	Env  []string
+	User string
+	Dir  string
+}
+
+// CLI scans by running a command such as Plex Media Scanner, either on
+// the Plex host or in its container
+type CLI struct {
+	args []*template.Template
+	env  []string
+	dir  string
+	attr *syscall.SysProcAttr
+	// run runs cmd until it exits or ctx is done, it is replaced with
+	// a stub during testing
+	run func(ctx context.Context, cmd *exec.Cmd) error
+}
+
+// target is what the arguments of a command are rendered with
+type target struct {
+	Section int
+	Path    string
+}
+
+// funcs are the functions available to the arguments of a command
+var funcs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+// NewCLI creates a CLI scanner running command, DefaultArgs are used
+// when command has no Args
+func NewCLI(command Command) (*CLI, error) {
+	args := command.Args
+	if len(args) == 0 {
+		args = DefaultArgs
+	}
+	cli := &CLI{
+		env: command.Env,
+		dir: command.Dir,
+		run: run,
+	}
+	for i, arg := range args {
+		tmpl, err := template.New(fmt.Sprintf("arg%d", i)).Funcs(funcs).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("scanner command: %s", err)
+		}
+		cli.args = append(cli.args, tmpl)
+	}
+	attr, err := procAttr(command.User)
+	if err != nil {
+		return nil, fmt.Errorf("scanner user: %s", err)
+	}
+	cli.attr = attr
+	return cli, nil
+}
+
+// Scan implements Scanner by running the command for folder of section
+func (cli *CLI) Scan(ctx context.Context, section int, folder string) error {
+	_, err := cli.Run(ctx, section, folder)
+	return err
+}
+
+// Run implements Runner by running the command for folder of section,
+// the command is killed along with the programs it started when ctx
+// is done
+func (cli *CLI) Run(ctx context.Context, section int, folder string) (Output, error) {
+	output := Output{ExitCode: -1}
+	args, err := cli.render(target{Section: section, Path: folder})
+	if err != nil {
+		return output, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	if len(cli.env) > 0 {
+		cmd.Env = append(os.Environ(), cli.env...)
+	}
+	cmd.Dir = cli.dir
+	cmd.SysProcAttr = cli.attr
+	stdout, stderr := &tail{max: maxOutput}, &tail{max: maxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err = cli.run(ctx, cmd)
+	if cmd.ProcessState != nil {
+		output.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	output.Stdout, output.Stderr = stdout.String(), stderr.String()
+	if err != nil && ctx.Err() != nil {
+		// the command was killed
+		err = ctx.Err()
+	}
+	if err != nil {
+		return output, fmt.Errorf("%s: %s %s", filepath.Base(args[0]), err, strings.TrimSpace(output.Stderr))
+	}
+	return output, nil
+}
+
+// run starts cmd and waits for it, the process group of cmd is killed
+// when ctx is done before it exits
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			kill(cmd)
+		case <-exited:
+		}
+	}()
+	return cmd.Wait()
+}
+
+// render renders the arguments of the command for t
+func (cli *CLI) render(t target) ([]string, error) {
+	var args []string
+	for _, tmpl := range cli.args {
+		var arg bytes.Buffer
+		if err := tmpl.Execute(&arg, t); err != nil {
+			return nil, fmt.Errorf("scanner command: %s", err)
+		}
+		if arg.Len() > 0 {
+			args = append(args, arg.String())
+		}
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("scanner command is empty")
+	}
+	return args, nil
+}
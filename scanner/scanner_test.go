@@ -0,0 +1,164 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var cliCases = []struct {
+	name         string
+	command      Command
+	folder       string
+	output       string
+	err          error
+	args         []string
+	env          string
+	dir          string
+	errorMessage string
+}{
+	{
+		name:   "case default command",
+		folder: "/movies/Heat (1995)",
+		args:   []string{"sudo", "-u", "plex", "-E", "-H", "/usr/lib/plexmediaserver/Plex Media Scanner", "--scan", "--refresh", "--section", "3"},
+	},
+	{
+		name: "case docker command of a folder",
+		command: Command{
+			Args: []string{"docker", "exec", "plex", "Plex Media Scanner", "--section", "{{.Section}}", "{{if .Path}}--directory{{end}}", "{{.Path}}"},
+			Env:  []string{"LANG=C.UTF-8"},
+			Dir:  "/tmp",
+		},
+		folder: "/movies/Heat \"Director's Cut\" (1995)",
+		args:   []string{"docker", "exec", "plex", "Plex Media Scanner", "--section", "3", "--directory", "/movies/Heat \"Director's Cut\" (1995)"},
+		env:    "LANG=C.UTF-8",
+		dir:    "/tmp",
+	},
+	{
+		name: "case docker command of the whole section",
+		command: Command{
+			Args: []string{"docker", "exec", "plex", "Plex Media Scanner", "--section", "{{.Section}}", "{{if .Path}}--directory{{end}}", "{{.Path}}"},
+		},
+		args: []string{"docker", "exec", "plex", "Plex Media Scanner", "--section", "3"},
+	},
+	{
+		name:         "case unknown field",
+		command:      Command{Args: []string{"scan", "{{.Library}}"}},
+		errorMessage: "scanner command",
+	},
+	{
+		name:         "case empty command",
+		command:      Command{Args: []string{"{{.Path}}"}},
+		errorMessage: "scanner command is empty",
+	},
+	{
+		name:         "case scan failed",
+		output:       "sudo: unknown user: plex",
+		err:          fmt.Errorf("exit status 1"),
+		errorMessage: "sudo: exit status 1 sudo: unknown user: plex",
+	},
+}
+
+func TestCLI_Scan(t *testing.T) {
+	os.Setenv("LD_LIBRARY_PATH", "/usr/lib/plexmediaserver")
+	for _, tt := range cliCases {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, err := NewCLI(tt.command)
+			if err != nil {
+				t.Fatalf("NewCLI() error = %v", err)
+			}
+			var ran *exec.Cmd
+			cli.run = func(ctx context.Context, cmd *exec.Cmd) error {
+				ran = cmd
+				cmd.Stderr.Write([]byte(tt.output))
+				return tt.err
+			}
+			err = cli.Scan(context.Background(), 3, tt.folder)
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in Scan() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Fatalf("Scan() expected error %v", tt.errorMessage)
+			}
+			if !reflect.DeepEqual(ran.Args, tt.args) {
+				t.Errorf("Scan() ran %q, want %q", ran.Args, tt.args)
+			}
+			if len(tt.env) > 0 && (len(ran.Env) == 0 || ran.Env[len(ran.Env)-1] != tt.env) {
+				t.Errorf("Scan() environment lacks %q", tt.env)
+			}
+			if ran.Dir != tt.dir {
+				t.Errorf("Scan() ran in %q, want %q", ran.Dir, tt.dir)
+			}
+		})
+	}
+}
+
+func TestNewCLI(t *testing.T) {
+	if _, err := NewCLI(Command{Args: []string{"scan", "{{.Section"}}); err == nil {
+		t.Error("NewCLI() accepted an invalid template")
+	}
+	if _, err := NewCLI(Command{User: "no-such-user-here"}); err == nil {
+		t.Error("NewCLI() accepted an unknown user")
+	}
+}
+
+func TestCLI_Run(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a unix shell")
+	}
+	cli, err := NewCLI(Command{Args: []string{"sh", "-c", "echo scanning {{.Section}}; echo {{.Path}} is missing >&2; exit 3"}})
+	if err != nil {
+		t.Fatalf("NewCLI() error = %v", err)
+	}
+	output, err := cli.Run(context.Background(), 3, "/movies/Heat")
+	if err == nil || !strings.Contains(err.Error(), "sh: exit status 3 /movies/Heat is missing") {
+		t.Errorf("Error in Run() = %v", err)
+	}
+	want := Output{ExitCode: 3, Stdout: "scanning 3\n", Stderr: "/movies/Heat is missing\n"}
+	if output != want {
+		t.Errorf("Run() = %+v, want %+v", output, want)
+	}
+}
+
+func TestCLI_RunKilled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a unix shell")
+	}
+	// the shell starts a scanner that would hang on to the output
+	cli, err := NewCLI(Command{Args: []string{"sh", "-c", "sleep 30; echo done"}})
+	if err != nil {
+		t.Fatalf("NewCLI() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	output, err := cli.Run(ctx, 3, "")
+	if err != context.DeadlineExceeded && (err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error())) {
+		t.Errorf("Error in Run() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if output.ExitCode != -1 {
+		t.Errorf("Run() exit code = %d, want -1", output.ExitCode)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("Run() returned after %s, the scanner was not killed", elapsed)
+	}
+}
+
+func TestTail_Write(t *testing.T) {
+	out := &tail{max: 8}
+	for _, p := range []string{"Plex ", "Media ", "Scanner"} {
+		out.Write([]byte(p))
+	}
+	if got, want := out.String(), "... Scanner"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,251 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTimeout is how long a scan runs before it is cancelled
+	defaultTimeout = 30 * time.Minute
+	// defaultHistory is how many scans the history keeps
+	defaultHistory = 20
+)
+
+// Scheduler merges the scan requests of every section until none came
+// for a quiet period, then asks its Scanner for a single scan. It never
+// scans a section twice at once, the requests coming while a section
+// is scanned are merged into a single follow-up scan.
+type Scheduler struct {
+	scanner  Scanner
+	quiet    time.Duration
+	settings *settings
+	now      func() time.Time
+	mutex    sync.Mutex
+	queued   map[int]*queue
+	// history serializes the updates of the history
+	history sync.Mutex
+}
+
+// settings holds the optional configuration applied by Option
+type settings struct {
+	timeout  time.Duration
+	timeouts map[int]time.Duration
+	store    Store
+	bucket   string
+	size     int
+	failed   func(record Record)
+}
+
+// Option configures optional behaviour of a Scheduler created by
+// NewScheduler.
+type Option func(*settings)
+
+// WithTimeout sets how long the scan of a section that has no timeout
+// of its own runs before it is cancelled.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *settings) {
+		s.timeout = timeout
+	}
+}
+
+// WithSectionTimeout sets how long the scan of section runs before it
+// is cancelled.
+func WithSectionTimeout(section int, timeout time.Duration) Option {
+	return func(s *settings) {
+		s.timeouts[section] = timeout
+	}
+}
+
+// WithHistory keeps the latest size scans in bucket of store.
+func WithHistory(store Store, bucket string, size int) Option {
+	return func(s *settings) {
+		s.store = store
+		s.bucket = bucket
+		if size > 0 {
+			s.size = size
+		}
+	}
+}
+
+// WithFailure calls failed with every scan that failed.
+func WithFailure(failed func(record Record)) Option {
+	return func(s *settings) {
+		s.failed = failed
+	}
+}
+
+// queue holds the scan requests of a section
+type queue struct {
+	// folders are the folders requested, whole is set when the whole
+	// section was requested
+	folders map[string]bool
+	whole   bool
+	// timer ends the quiet period started by the request numbered
+	// generation, due is set when it ended while the section was
+	// scanned
+	timer      *time.Timer
+	generation int
+	due        bool
+	running    bool
+	// done are closed once the scan of the requests finished
+	done []chan struct{}
+}
+
+// take empties q and returns the folder to scan, empty for the whole
+// section, along with the channels closed once it is scanned. It
+// returns false when nothing was requested.
+func (q *queue) take() (string, []chan struct{}, bool) {
+	if !q.whole && len(q.folders) == 0 {
+		return "", nil, false
+	}
+	folder := ""
+	if !q.whole && len(q.folders) == 1 {
+		for f := range q.folders {
+			folder = f
+		}
+	}
+	done := q.done
+	q.folders, q.whole, q.done = map[string]bool{}, false, nil
+	return folder, done, true
+}
+
+// NewScheduler creates a Scheduler scanning with scanner once requests
+// stopped coming for quiet
+func NewScheduler(scanner Scanner, quiet time.Duration, options ...Option) *Scheduler {
+	s := &settings{
+		timeout:  defaultTimeout,
+		timeouts: map[int]time.Duration{},
+		size:     defaultHistory,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return &Scheduler{
+		scanner:  scanner,
+		quiet:    quiet,
+		settings: s,
+		now:      time.Now,
+		queued:   map[int]*queue{},
+	}
+}
+
+// Request asks for a scan of folder of section, the whole section when
+// folder is empty. The scan runs once no request came for the quiet
+// period, a single folder is scanned alone while several folders make
+// the whole section scanned. The channel returned is closed once the
+// scan covering the request finished, whether it succeeded or not.
+func (scheduler *Scheduler) Request(ctx context.Context, section int, folder string) <-chan struct{} {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	q, ok := scheduler.queued[section]
+	if !ok {
+		q = &queue{folders: map[string]bool{}}
+		scheduler.queued[section] = q
+	}
+	if len(folder) == 0 {
+		q.whole = true
+	} else {
+		q.folders[folder] = true
+	}
+	done := make(chan struct{})
+	q.done = append(q.done, done)
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	q.generation++
+	generation := q.generation
+	q.timer = time.AfterFunc(scheduler.quiet, func() {
+		scheduler.quietEnded(ctx, section, generation)
+	})
+	return done
+}
+
+// quietEnded scans section when the quiet period started by request
+// generation ended, unless a later request started another one. When
+// the section is already scanned the scan follows the running one.
+func (scheduler *Scheduler) quietEnded(ctx context.Context, section int, generation int) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	q := scheduler.queued[section]
+	if generation != q.generation {
+		return
+	}
+	q.timer = nil
+	if q.running {
+		q.due = true
+		return
+	}
+	q.running = true
+	for {
+		if folder, done, ok := q.take(); ok {
+			scheduler.mutex.Unlock()
+			scheduler.scan(ctx, section, folder)
+			for _, c := range done {
+				close(c)
+			}
+			scheduler.mutex.Lock()
+		}
+		if !q.due {
+			break
+		}
+		q.due = false
+	}
+	q.running = false
+}
+
+// scan scans folder of section, cancelling the scan once the timeout
+// of section passed, and records how it went
+func (scheduler *Scheduler) scan(ctx context.Context, section int, folder string) {
+	if ctx.Err() != nil {
+		return
+	}
+	timeout, ok := scheduler.settings.timeouts[section]
+	if !ok {
+		timeout = scheduler.settings.timeout
+	}
+	scanning, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	log.Printf("info: scanning section %d %s\n", section, folder)
+	record := Record{Section: section, Folder: folder, Started: scheduler.now(), ExitCode: -1}
+	var err error
+	if runner, ok := scheduler.scanner.(Runner); ok {
+		var output Output
+		output, err = runner.Run(scanning, section, folder)
+		record.ExitCode, record.Stdout, record.Stderr = output.ExitCode, output.Stdout, output.Stderr
+	} else {
+		err = scheduler.scanner.Scan(scanning, section, folder)
+	}
+	record.Duration = scheduler.now().Sub(record.Started)
+	if err != nil && scanning.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s: %s", timeout, err)
+	}
+	if err != nil {
+		record.Error = err.Error()
+		log.Printf("error: scanning section %d: %s\n", section, err)
+	}
+	if ctx.Err() != nil {
+		// the program is stopping, the scan was interrupted rather
+		// than failed
+		return
+	}
+	scheduler.remember(record)
+	if record.Failed() && scheduler.settings.failed != nil {
+		scheduler.settings.failed(record)
+	}
+}
+
+// remember adds record to the history when it is kept
+func (scheduler *Scheduler) remember(record Record) {
+	if scheduler.settings.store == nil {
+		return
+	}
+	scheduler.history.Lock()
+	defer scheduler.history.Unlock()
+	if err := remember(scheduler.settings.store, scheduler.settings.bucket, scheduler.settings.size, record); err != nil {
+		log.Println("error:", err)
+	}
+}
@@ -0,0 +1,225 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/store"
+)
+
+const quiet = 20 * time.Millisecond
+
+// scannerStub records the scans it runs, every scan waits for release
+// when it is set
+type scannerStub struct {
+	mutex   sync.Mutex
+	scans   []string
+	running int
+	overlap bool
+	release chan struct{}
+}
+
+func (stub *scannerStub) Scan(ctx context.Context, section int, folder string) error {
+	stub.mutex.Lock()
+	stub.scans = append(stub.scans, fmt.Sprintf("%d %s", section, folder))
+	stub.running++
+	stub.overlap = stub.overlap || stub.running > 1
+	stub.mutex.Unlock()
+	if stub.release != nil {
+		<-stub.release
+	}
+	stub.mutex.Lock()
+	stub.running--
+	stub.mutex.Unlock()
+	return nil
+}
+
+func (stub *scannerStub) recorded() []string {
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	return append([]string(nil), stub.scans...)
+}
+
+type request struct {
+	section int
+	folder  string
+}
+
+var schedulerCases = []struct {
+	name     string
+	requests []request
+	scans    []string
+}{
+	{
+		name:     "case single folder",
+		requests: []request{{1, "/movies/a"}, {1, "/movies/a"}},
+		scans:    []string{"1 /movies/a"},
+	},
+	{
+		name:     "case several folders scan the whole section",
+		requests: []request{{1, "/movies/a"}, {1, "/movies/b"}},
+		scans:    []string{"1 "},
+	},
+	{
+		name:     "case whole section requested",
+		requests: []request{{1, "/movies/a"}, {1, ""}},
+		scans:    []string{"1 "},
+	},
+}
+
+func TestScheduler_Request(t *testing.T) {
+	for _, tt := range schedulerCases {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &scannerStub{}
+			scheduler := NewScheduler(stub, quiet)
+			for _, request := range tt.requests {
+				scheduler.Request(context.Background(), request.section, request.folder)
+			}
+			time.Sleep(5 * quiet)
+			if got := stub.recorded(); !reflect.DeepEqual(got, tt.scans) {
+				t.Errorf("Request() scanned %q, want %q", got, tt.scans)
+			}
+		})
+	}
+}
+
+func TestScheduler_Done(t *testing.T) {
+	stub := &scannerStub{release: make(chan struct{})}
+	scheduler := NewScheduler(stub, quiet)
+	first := scheduler.Request(context.Background(), 1, "/movies/a")
+	time.Sleep(3 * quiet)
+	second := scheduler.Request(context.Background(), 1, "/movies/b")
+	select {
+	case <-first:
+		t.Fatal("Request() done before the scan finished")
+	case <-time.After(3 * quiet):
+	}
+	stub.release <- struct{}{}
+	select {
+	case <-first:
+	case <-time.After(time.Second):
+		t.Fatal("Request() not done once the scan finished")
+	}
+	select {
+	case <-second:
+		t.Fatal("Request() done before the follow-up scan finished")
+	case <-time.After(3 * quiet):
+	}
+	stub.release <- struct{}{}
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+		t.Fatal("Request() not done once the follow-up scan finished")
+	}
+}
+
+func TestScheduler_Sections(t *testing.T) {
+	stub := &scannerStub{}
+	scheduler := NewScheduler(stub, quiet)
+	scheduler.Request(context.Background(), 1, "/movies/a")
+	scheduler.Request(context.Background(), 2, "/shows/b")
+	time.Sleep(5 * quiet)
+	got := stub.recorded()
+	if len(got) != 2 {
+		t.Errorf("Request() scanned %q, want a scan of each section", got)
+	}
+}
+
+func TestScheduler_FollowUp(t *testing.T) {
+	stub := &scannerStub{release: make(chan struct{})}
+	scheduler := NewScheduler(stub, quiet)
+	scheduler.Request(context.Background(), 1, "/movies/a")
+	time.Sleep(3 * quiet)
+	// the first scan is running, the following requests are merged
+	// into a single scan running after it
+	scheduler.Request(context.Background(), 1, "/movies/b")
+	time.Sleep(3 * quiet)
+	scheduler.Request(context.Background(), 1, "/movies/c")
+	time.Sleep(3 * quiet)
+	if got := stub.recorded(); !reflect.DeepEqual(got, []string{"1 /movies/a"}) {
+		t.Fatalf("Request() scanned %q while the first scan was running", got)
+	}
+	stub.release <- struct{}{}
+	stub.release <- struct{}{}
+	time.Sleep(3 * quiet)
+	want := []string{"1 /movies/a", "1 "}
+	if got := stub.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Request() scanned %q, want %q", got, want)
+	}
+	if stub.overlap {
+		t.Error("Request() scanned the section twice at once")
+	}
+}
+
+// runnerStub fails every scan the way a command does, it hangs until
+// the scan is cancelled when hang is set
+type runnerStub struct {
+	hang bool
+}
+
+func (stub *runnerStub) Scan(ctx context.Context, section int, folder string) error {
+	_, err := stub.Run(ctx, section, folder)
+	return err
+}
+
+func (stub *runnerStub) Run(ctx context.Context, section int, folder string) (Output, error) {
+	if stub.hang {
+		<-ctx.Done()
+		return Output{ExitCode: -1}, ctx.Err()
+	}
+	return Output{ExitCode: 1, Stderr: "no such section\n"}, fmt.Errorf("exit status 1")
+}
+
+var failureCases = []struct {
+	name     string
+	scanner  Scanner
+	exitCode int
+	err      string
+}{
+	{
+		name:     "case command failed",
+		scanner:  &runnerStub{},
+		exitCode: 1,
+		err:      "exit status 1",
+	},
+	{
+		name:     "case section timed out",
+		scanner:  &runnerStub{hang: true},
+		exitCode: -1,
+		err:      "timed out after 20ms: context deadline exceeded",
+	},
+}
+
+func TestScheduler_Failure(t *testing.T) {
+	for _, tt := range failureCases {
+		t.Run(tt.name, func(t *testing.T) {
+			state := store.Memory()
+			failed := make(chan Record, 1)
+			scheduler := NewScheduler(tt.scanner, quiet,
+				WithTimeout(time.Minute),
+				WithSectionTimeout(1, quiet),
+				WithHistory(state, "scans", 10),
+				WithFailure(func(record Record) {
+					failed <- record
+				}))
+			scheduler.Request(context.Background(), 1, "/movies/a")
+			var record Record
+			select {
+			case record = <-failed:
+			case <-time.After(time.Second):
+				t.Fatal("Request() reported no failure")
+			}
+			if record.Section != 1 || record.Folder != "/movies/a" || record.ExitCode != tt.exitCode || record.Error != tt.err {
+				t.Errorf("Request() failed with %+v, want exit code %d and error %q", record, tt.exitCode, tt.err)
+			}
+			records, _ := History(state, "scans")
+			if len(records) != 1 || records[0].Error != tt.err {
+				t.Errorf("History() = %+v, want the failed scan", records)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// lockRetry is the wait before trying again to take a lock held
+	// by another process
+	lockRetry = 10 * time.Millisecond
+	// staleLock is the age of a lock file left by a process that
+	// died, it is taken over
+	staleLock = time.Minute
+)
+
+// lock creates the file at path, waiting for other processes holding
+// it, and returns the function removing it. Windows has no flock, the
+// lock is the file itself.
+func lock(path string) (func(), error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(lockRetry)
+	}
+}
@@ -0,0 +1,223 @@
+// Package store implements a small persistent key value store kept in
+// a single JSON file. Values are grouped in buckets and every change
+// is written to disk before it returns, so that state such as posted
+// messages and pending deliveries survives restarts. Changes written
+// to the file by another process, such as a command replaying failed
+// deliveries, are read again before the store is used, and the file
+// is locked from reading it to writing it so that they are not lost.
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store represent the state file, it is safe for concurrent use
+type Store struct {
+	// Path is the location of the state file
+	Path string
+
+	mutex   sync.Mutex
+	buckets map[string]map[string]json.RawMessage
+	// file is the state file as it was last read or written, it is
+	// replaced by a new file on every write
+	file os.FileInfo
+}
+
+// Open reads the state file at path, a missing file is an empty store
+func Open(path string) (*Store, error) {
+	store := &Store{
+		Path:    path,
+		buckets: map[string]map[string]json.RawMessage{},
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Memory creates a store that is never written to disk
+func Memory() *Store {
+	return &Store{
+		buckets: map[string]map[string]json.RawMessage{},
+	}
+}
+
+// Get decodes the value of key in bucket into value, it returns false
+// when there is no such key
+func (store *Store) Get(bucket string, key string, value interface{}) (bool, error) {
+	store.mutex.Lock()
+	store.reload()
+	raw, ok := store.buckets[bucket][key]
+	store.mutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, value)
+}
+
+// Put sets the value of key in bucket
+func (store *Store) Put(bucket string, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.update(func() bool {
+		if store.buckets[bucket] == nil {
+			store.buckets[bucket] = map[string]json.RawMessage{}
+		}
+		store.buckets[bucket][key] = raw
+		return true
+	})
+}
+
+// Delete removes key from bucket
+func (store *Store) Delete(bucket string, key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.update(func() bool {
+		if _, ok := store.buckets[bucket][key]; !ok {
+			return false
+		}
+		store.remove(bucket, key)
+		return true
+	})
+}
+
+// Prune removes the keys of bucket whose value expired tells, it
+// returns how many were removed
+func (store *Store) Prune(bucket string, expired func(key string, value json.RawMessage) bool) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	removed := 0
+	err := store.update(func() bool {
+		for key, value := range store.buckets[bucket] {
+			if expired(key, value) {
+				store.remove(bucket, key)
+				removed++
+			}
+		}
+		return removed > 0
+	})
+	return removed, err
+}
+
+// remove removes key from bucket, and bucket once it is empty
+func (store *Store) remove(bucket string, key string) {
+	delete(store.buckets[bucket], key)
+	if len(store.buckets[bucket]) == 0 {
+		delete(store.buckets, bucket)
+	}
+}
+
+// Keys returns the sorted keys of bucket
+func (store *Store) Keys(bucket string) []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.reload()
+	keys := make([]string, 0, len(store.buckets[bucket]))
+	for key := range store.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// update applies change to the state read again from the file and
+// saves it when change tells it changed anything. The state file is
+// locked meanwhile so that another process doesn't write it between
+// reading and saving it.
+func (store *Store) update(change func() bool) error {
+	if len(store.Path) == 0 {
+		change()
+		return nil
+	}
+	unlock, err := lock(store.Path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// the file is always read again, a file written meanwhile may
+	// reuse the inode of the one read last
+	store.file = nil
+	store.reload()
+	if !change() {
+		return nil
+	}
+	return store.save()
+}
+
+// load reads the state file, a missing file is an empty store
+func (store *Store) load() error {
+	info, err := os.Stat(store.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	raw, err := ioutil.ReadFile(store.Path)
+	if err != nil {
+		return err
+	}
+	buckets := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &buckets); err != nil {
+		return err
+	}
+	store.buckets = buckets
+	store.file = info
+	return nil
+}
+
+// reload reads the state file again when it was written by another
+// process since it was last read, the state in memory is kept when
+// it can't be read
+func (store *Store) reload() {
+	if len(store.Path) == 0 {
+		return
+	}
+	info, err := os.Stat(store.Path)
+	if err != nil || store.file != nil && os.SameFile(info, store.file) &&
+		info.ModTime().Equal(store.file.ModTime()) && info.Size() == store.file.Size() {
+		return
+	}
+	store.load()
+}
+
+// save writes the state file to a temporary file that replaces
+// the state file once it is complete
+func (store *Store) save() error {
+	if len(store.Path) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(store.buckets)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(store.Path), filepath.Base(store.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), store.Path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(store.Path); err == nil {
+		store.file = info
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type item struct {
+	Channel string
+	TS      string
+}
+
+func TestStore_Persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() unexpected error %v", err)
+	}
+	if err := s.Put("messages", "general/movies/b", item{"C1", "2"}); err != nil {
+		t.Fatalf("Put() unexpected error %v", err)
+	}
+	s.Put("messages", "general/movies/a", item{"C1", "1"})
+	s.Put("outbox", "x", "pending")
+	s.Delete("outbox", "x")
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() unexpected error %v", err)
+	}
+	var got item
+	if ok, err := reopened.Get("messages", "general/movies/b", &got); !ok || err != nil {
+		t.Fatalf("Get() = %v, %v, want true", ok, err)
+	}
+	if want := (item{"C1", "2"}); got != want {
+		t.Errorf("Get() = %v, want %v", got, want)
+	}
+	if keys, want := reopened.Keys("messages"), []string{"general/movies/a", "general/movies/b"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+	if keys := reopened.Keys("outbox"); len(keys) != 0 {
+		t.Errorf("Keys() = %v, want none", keys)
+	}
+	if ok, _ := reopened.Get("messages", "missing", &got); ok {
+		t.Error("Get() found a missing key")
+	}
+}
+
+func TestStore_Corrupted(t *testing.T) {
+	file, err := ioutil.TempFile("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("{not json")
+	file.Close()
+	if _, err := Open(file.Name()); err == nil {
+		t.Error("Open() expected an error for corrupted file")
+	}
+}
+
+func TestStore_ChangedByAnotherProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	daemon, _ := Open(path)
+	daemon.Put("dead", "general/movies/a", "failed")
+	command, _ := Open(path)
+	if err := command.Delete("dead", "general/movies/a"); err != nil {
+		t.Fatalf("Delete() unexpected error %v", err)
+	}
+	if keys := daemon.Keys("dead"); len(keys) != 0 {
+		t.Errorf("Keys() = %v, want the change of the other store", keys)
+	}
+}
+
+func TestStore_ConcurrentProcesses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	// every store stands for a process writing the same file
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		s, _ := Open(path)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := s.Put("outbox", fmt.Sprintf("%d/%d", i, j), "pending"); err != nil {
+					t.Errorf("Put() unexpected error %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	reopened, _ := Open(path)
+	if keys := reopened.Keys("outbox"); len(keys) != 80 {
+		t.Errorf("Keys() = %d keys, want 80", len(keys))
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	s := Memory()
+	s.Put("messages", "general/movies/a", item{"C1", "1"})
+	s.Put("messages", "general/movies/b", item{"C1", "2"})
+	removed, err := s.Prune("messages", func(key string, value json.RawMessage) bool {
+		var got item
+		json.Unmarshal(value, &got)
+		return got.TS == "1"
+	})
+	if removed != 1 || err != nil {
+		t.Errorf("Prune() = %v, %v, want 1", removed, err)
+	}
+	if keys, want := s.Keys("messages"), []string{"general/movies/b"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/notify"
+)
+
+// loadTemplates parses the global message templates and the ones of
+// every library, the global ones are kept under empty library name
+func loadTemplates(cfg *config.Config) (map[string]map[notify.Kind]*notify.Template, error) {
+	loaded := map[string]map[notify.Kind]*notify.Template{}
+	parsed, err := parseTemplates(cfg.Templates)
+	if err != nil {
+		return nil, err
+	}
+	loaded[""] = parsed
+	for name, lib := range cfg.Plex {
+		parsed, err := parseTemplates(lib.Templates)
+		if err != nil {
+			return nil, fmt.Errorf("library %s: %s", name, err)
+		}
+		loaded[name] = parsed
+	}
+	return loaded, nil
+}
+
+// parseTemplates parses templates keyed by event type
+func parseTemplates(cfgs map[string]config.TemplateCfg) (map[notify.Kind]*notify.Template, error) {
+	parsed := map[notify.Kind]*notify.Template{}
+	for kind, cfg := range cfgs {
+		if !validKind(notify.Kind(kind)) {
+			return nil, fmt.Errorf("unknown event type %q for template", kind)
+		}
+		tmpl, err := parseTemplate(kind, cfg)
+		if err != nil {
+			return nil, err
+		}
+		parsed[notify.Kind(kind)] = tmpl
+	}
+	return parsed, nil
+}
+
+// parseTemplate parses the template written inline or kept in a file
+func parseTemplate(name string, cfg config.TemplateCfg) (*notify.Template, error) {
+	if len(cfg.File) > 0 {
+		return notify.ParseTemplateFile(cfg.File)
+	}
+	return notify.ParseTemplate(name, cfg.Text)
+}
+
+// templateFor returns the template of library for kind, falling back
+// to the global template. It is nil when neither exists.
+func templateFor(library string, kind notify.Kind) *notify.Template {
+	if tmpl, ok := templates[library][kind]; ok {
+		return tmpl
+	}
+	return templates[""][kind]
+}
+
+// validKind tells whether kind is a known event type
+func validKind(kind notify.Kind) bool {
+	for _, k := range notify.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rimaulana/plexgoslack/config"
+	"github.com/rimaulana/plexgoslack/metadata"
+	"github.com/rimaulana/plexgoslack/notify"
+)
+
+var templateCases = []struct {
+	name         string
+	cfg          config.Config
+	library      string
+	kind         notify.Kind
+	text         string
+	errorMessage string
+}{
+	{
+		name:    "case global template",
+		cfg:     config.Config{Templates: map[string]config.TemplateCfg{"new": {Text: "{{.Movie.Title}} landed"}}},
+		library: "movies",
+		kind:    notify.KindNew,
+		text:    "Heat landed",
+	},
+	{
+		name: "case library template overrides global one",
+		cfg: config.Config{
+			Templates: map[string]config.TemplateCfg{"new": {Text: "{{.Movie.Title}} landed"}},
+			Plex:      map[string]config.PlexLibCfg{"movies": {Templates: map[string]config.TemplateCfg{"new": {Text: "{{.Movie.Title}} in {{.Library}}"}}}},
+		},
+		library: "movies",
+		kind:    notify.KindNew,
+		text:    "Heat in movies",
+	},
+	{
+		name: "case other libraries use global template",
+		cfg: config.Config{
+			Templates: map[string]config.TemplateCfg{"new": {Text: "{{.Movie.Title}} landed"}},
+			Plex:      map[string]config.PlexLibCfg{"movies": {Templates: map[string]config.TemplateCfg{"new": {Text: "{{.Movie.Title}} in {{.Library}}"}}}},
+		},
+		library: "tutorials",
+		kind:    notify.KindNew,
+		text:    "Heat landed",
+	},
+	{
+		name:    "case no template",
+		cfg:     config.Config{Templates: map[string]config.TemplateCfg{"new": {Text: "{{.Movie.Title}} landed"}}},
+		library: "movies",
+		kind:    notify.KindRemoved,
+	},
+	{
+		name:         "case unknown event type",
+		cfg:          config.Config{Templates: map[string]config.TemplateCfg{"deleted": {Text: "gone"}}},
+		errorMessage: `unknown event type "deleted"`,
+	},
+	{
+		name:         "case invalid library template",
+		cfg:          config.Config{Plex: map[string]config.PlexLibCfg{"movies": {Templates: map[string]config.TemplateCfg{"new": {Text: "{{.Movie.Title"}}}}},
+		errorMessage: "library movies:",
+	},
+}
+
+func TestLoadTemplates(t *testing.T) {
+	for _, tt := range templateCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			templates, err = loadTemplates(&tt.cfg)
+			if err != nil {
+				if len(tt.errorMessage) == 0 || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Errorf("Error in loadTemplates() = %v, want %v", err, tt.errorMessage)
+				}
+				return
+			}
+			if len(tt.errorMessage) > 0 {
+				t.Fatalf("loadTemplates() expected error %v", tt.errorMessage)
+			}
+			tmpl := templateFor(tt.library, tt.kind)
+			if tmpl == nil {
+				if len(tt.text) > 0 {
+					t.Errorf("templateFor(%q, %s) = nil, want a template", tt.library, tt.kind)
+				}
+				return
+			}
+			text, _, err := tmpl.Execute(notify.Announcement{Kind: tt.kind, Library: tt.library, Movie: metadata.Movie{Title: "Heat"}})
+			if err != nil || text != tt.text {
+				t.Errorf("templateFor(%q, %s) rendered %q, %v, want %q", tt.library, tt.kind, text, err, tt.text)
+			}
+		})
+	}
+}
+
+func TestLoadTemplates_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "removed.tmpl")
+	ioutil.WriteFile(file, []byte("{{.Movie.Title}} is gone"), 0644)
+
+	templates, err = loadTemplates(&config.Config{Templates: map[string]config.TemplateCfg{"removed": {File: file}}})
+	if err != nil {
+		t.Fatalf("loadTemplates() unexpected error %v", err)
+	}
+	text, _, err := templateFor("", notify.KindRemoved).Execute(notify.Announcement{Kind: notify.KindRemoved, Movie: metadata.Movie{Title: "Heat"}})
+	if err != nil || text != "Heat is gone" {
+		t.Errorf("template file rendered %q, %v, want %q", text, err, "Heat is gone")
+	}
+	if _, err := loadTemplates(&config.Config{Templates: map[string]config.TemplateCfg{"removed": {File: filepath.Join(dir, "missing.tmpl")}}}); err == nil {
+		t.Error("loadTemplates() expected an error for a missing template file")
+	}
+}
@@ -3,19 +3,33 @@
 package tmdb
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
 )
 
 const (
 	// baseURL provides the endpoint for The Movie DB API
-	baseURL = "http://api.themoviedb.org/3"
+	baseURL = "https://api.themoviedb.org/3"
 	// posterBaseURL provides the root endpoint for poster image of the movie
 	posterBaseURL = "https://image.tmdb.org/t/p/w92"
+	// defaultTimeout is the timeout applied to every request when no
+	// WithTimeout option is given
+	defaultTimeout = time.Second * 5
+	// redacted replaces the API key whenever it shows up in an error
+	redacted = "REDACTED"
+	// defaultCountry is the country whose certification is used when
+	// no WithCountry option is given
+	defaultCountry = "US"
 )
 
 // httpClient interface implements httpClient.Do function and intended to
@@ -31,24 +45,178 @@ type TMDb struct {
 	APIKey string
 	// Client is an instance of httpClient interface
 	Client httpClient
+
+	baseURL     string
+	userAgent   string
+	accessToken string
+	country     string
+}
+
+// settings holds the values collected from Option functions before
+// the http client of a TMDb instance is built.
+type settings struct {
+	baseURL     string
+	userAgent   string
+	accessToken string
+	country     string
+	timeout     time.Duration
+	proxy       *url.URL
+	transport   http.RoundTripper
+}
+
+// Option configures optional behaviour of a TMDb instance created by New.
+type Option func(*settings)
+
+// WithBaseURL points the client to a different API endpoint, for example
+// a local mock or a mirror of The Movie DB API.
+func WithBaseURL(URL string) Option {
+	return func(s *settings) {
+		s.baseURL = strings.TrimRight(URL, "/")
+	}
+}
+
+// WithTimeout overrides the default timeout of every request sent to
+// The Movie DB API.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *settings) {
+		s.timeout = timeout
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent along with every request.
+func WithUserAgent(userAgent string) Option {
+	return func(s *settings) {
+		s.userAgent = userAgent
+	}
+}
+
+// WithProxy routes every request through the given HTTP proxy. A copy
+// of the transport given with WithTransport is used so that the proxy
+// doesn't leak to its other users, the proxy is ignored when that
+// transport is not an *http.Transport.
+func WithProxy(proxy *url.URL) Option {
+	return func(s *settings) {
+		s.proxy = proxy
+	}
+}
+
+// WithTransport replaces the transport of the underlying http client.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(s *settings) {
+		s.transport = transport
+	}
+}
+
+// WithCountry sets the ISO 3166-1 code of the country whose
+// certification, such as PG-13, is given to movies
+func WithCountry(country string) Option {
+	return func(s *settings) {
+		s.country = strings.ToUpper(country)
+	}
+}
+
+// WithAccessToken makes the client authenticate using a v4 read access
+// token sent as a bearer token instead of the API key in the query string.
+func WithAccessToken(token string) Option {
+	return func(s *settings) {
+		s.accessToken = token
+	}
 }
 
 //New required tmdb API key and will return a new instance of tmdb API connection
-// with all of its default setting.
-func New(APIKey string) *TMDb {
+// with all of its default setting, modified by the given options.
+func New(APIKey string, options ...Option) *TMDb {
+	s := &settings{
+		baseURL: baseURL,
+		country: defaultCountry,
+		timeout: defaultTimeout,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	transport := s.transport
+	if s.proxy != nil {
+		switch t := transport.(type) {
+		case nil:
+			transport = &http.Transport{
+				Proxy:               http.ProxyURL(s.proxy),
+				TLSHandshakeTimeout: 10 * time.Second,
+			}
+		case *http.Transport:
+			t = t.Clone()
+			t.Proxy = http.ProxyURL(s.proxy)
+			transport = t
+		}
+	}
 	return &TMDb{
 		APIKey: APIKey,
 		Client: &http.Client{
-			Timeout: time.Second * 5,
+			Timeout:   s.timeout,
+			Transport: transport,
 		},
+		baseURL:     s.baseURL,
+		userAgent:   s.userAgent,
+		accessToken: s.accessToken,
+		country:     s.country,
 	}
 }
 
 //Result represent the information from a movie we want to
 // extract from tmdb movie data.
 type result struct {
-	PosterPath string `json:"poster_path"`
-	Overview   string `json:"overview"`
+	ID          int     `json:"id"`
+	Title       string  `json:"title"`
+	ReleaseDate string  `json:"release_date"`
+	PosterPath  string  `json:"poster_path"`
+	Overview    string  `json:"overview"`
+	VoteAverage float64 `json:"vote_average"`
+}
+
+// genre represent an entry of genres array in movie details
+type genre struct {
+	Name string `json:"name"`
+}
+
+// details represent the result of fetching a single movie
+// by its id, it has more information than a search result.
+type details struct {
+	result
+	IMDbID string  `json:"imdb_id"`
+	Genres []genre `json:"genres"`
+}
+
+// video represent an entry of the results of movie videos
+type video struct {
+	Key      string `json:"key"`
+	Site     string `json:"site"`
+	Type     string `json:"type"`
+	Official bool   `json:"official"`
+}
+
+// videosResult represent the result of fetching movie videos
+type videosResult struct {
+	Results []video `json:"results"`
+}
+
+// externalIDs represent the ids of a movie on other websites
+type externalIDs struct {
+	IMDbID string `json:"imdb_id"`
+}
+
+// releaseDates represent the releases of a movie in every country
+type releaseDates struct {
+	Results []struct {
+		Country      string `json:"iso_3166_1"`
+		ReleaseDates []struct {
+			Certification string `json:"certification"`
+		} `json:"release_dates"`
+	} `json:"results"`
+}
+
+// findResult represent the result of looking up an external
+// id such as IMDb id in tmdb API.
+type findResult struct {
+	MovieResults []result `json:"movie_results"`
 }
 
 // searchResult represent the result from search query
@@ -59,62 +227,271 @@ type searchResult struct {
 	Results      []result `json:"results"`
 }
 
-//MovieInfo represent the structure of the information
-// we want to get from the movie we are searching for.
-type MovieInfo struct {
-	Title     string
-	Year      string
-	Thumbnail string
-	Synopsis  string
-}
+// MovieInfo is the movie information returned by GetInfo, it is kept
+// for the code written before metadata.Movie replaced it
+type MovieInfo = metadata.Movie
 
 //GetInfo require the title and the year of searched movie
 // and will send API request to TMDB API endpoint and return
-// an instance of MovieInfo as result or an error is something
+// an instance of metadata.Movie as result or an error is something
 // wrong happened.
-func (tmdb *TMDb) GetInfo(title string, year string) (*MovieInfo, error) {
+func (tmdb *TMDb) GetInfo(title string, year string) (*metadata.Movie, error) {
+	return tmdb.GetInfoContext(context.Background(), title, year)
+}
+
+// Name identifies TMDb as metadata provider
+func (tmdb *TMDb) Name() string {
+	return "tmdb"
+}
+
+// Lookup implements metadata.Provider by fetching the movie
+// described by query from TMDb. The movie is fetched exactly
+// when query has its TMDb or IMDb id, otherwise it is searched
+// by its title and year.
+func (tmdb *TMDb) Lookup(ctx context.Context, query metadata.Query) (*metadata.Movie, error) {
+	id := query.TMDbID
+	if len(id) == 0 && len(query.IMDbID) > 0 {
+		found, err := tmdb.findByIMDbID(ctx, query.IMDbID)
+		if err != nil {
+			return nil, err
+		}
+		id = strconv.Itoa(found.ID)
+	}
+	var movie *metadata.Movie
+	var err error
+	if len(id) > 0 {
+		movie, err = tmdb.GetMovieContext(ctx, id)
+	} else {
+		movie, err = tmdb.GetInfoContext(ctx, query.Title, query.Year)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tmdb.enrich(ctx, movie)
+	return movie, nil
+}
+
+// enrich completes movie with its YouTube trailer, IMDb id and
+// certification. They are nice to have, failing to get them doesn't
+// fail the lookup.
+func (tmdb *TMDb) enrich(ctx context.Context, movie *metadata.Movie) {
+	if len(movie.TMDbID) == 0 {
+		return
+	}
+	if trailer, err := tmdb.TrailerContext(ctx, movie.TMDbID); err == nil {
+		movie.Trailer = trailer
+	}
+	if len(movie.IMDbID) == 0 {
+		var ids externalIDs
+		if err := tmdb.get(ctx, "/movie/"+url.PathEscape(movie.TMDbID)+"/external_ids", url.Values{}, &ids); err == nil {
+			movie.IMDbID = ids.IMDbID
+		}
+	}
+	if certification, err := tmdb.CertificationContext(ctx, movie.TMDbID); err == nil {
+		movie.Certification = certification
+	}
+}
+
+// CertificationContext returns the certification of the movie with the
+// given TMDb id in the country set with WithCountry
+func (tmdb *TMDb) CertificationContext(ctx context.Context, id string) (string, error) {
+	var releases releaseDates
+	if err := tmdb.get(ctx, "/movie/"+url.PathEscape(id)+"/release_dates", url.Values{}, &releases); err != nil {
+		return "", err
+	}
+	country := tmdb.country
+	if len(country) == 0 {
+		country = defaultCountry
+	}
+	for _, res := range releases.Results {
+		if res.Country != country {
+			continue
+		}
+		for _, release := range res.ReleaseDates {
+			if len(release.Certification) > 0 {
+				return release.Certification, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Couldn't find %s certification of %s in TMDb", country, id)
+}
+
+// TrailerContext returns the YouTube URL of the trailer of the movie
+// with the given TMDb id, official trailers are preferred.
+func (tmdb *TMDb) TrailerContext(ctx context.Context, id string) (string, error) {
+	var videos videosResult
+	if err := tmdb.get(ctx, "/movie/"+url.PathEscape(id)+"/videos", url.Values{}, &videos); err != nil {
+		return "", err
+	}
+	var trailer *video
+	for i, v := range videos.Results {
+		if v.Site != "YouTube" || v.Type != "Trailer" {
+			continue
+		}
+		if trailer == nil || (v.Official && !trailer.Official) {
+			trailer = &videos.Results[i]
+		}
+	}
+	if trailer == nil {
+		return "", fmt.Errorf("Couldn't find trailer of %s in TMDb", id)
+	}
+	return fmt.Sprintf("https://www.youtube.com/watch?v=%s", url.QueryEscape(trailer.Key)), nil
+}
+
+// GetMovieContext fetches the movie with the given TMDb id
+func (tmdb *TMDb) GetMovieContext(ctx context.Context, id string) (*metadata.Movie, error) {
+	var movie details
+	if err := tmdb.get(ctx, "/movie/"+url.PathEscape(id), url.Values{}, &movie); err != nil {
+		return nil, err
+	}
+	info := movie.info()
+	info.IMDbID = movie.IMDbID
+	for _, g := range movie.Genres {
+		info.Genres = append(info.Genres, g.Name)
+	}
+	return info, nil
+}
+
+// findByIMDbID returns the movie tmdb associates with the given IMDb id
+func (tmdb *TMDb) findByIMDbID(ctx context.Context, id string) (*result, error) {
+	query := url.Values{}
+	query.Set("external_source", "imdb_id")
+	var found findResult
+	if err := tmdb.get(ctx, "/find/"+url.PathEscape(id), query, &found); err != nil {
+		return nil, err
+	}
+	if len(found.MovieResults) == 0 {
+		return nil, fmt.Errorf("Couldn't find IMDb id %s in TMDb", id)
+	}
+	return &found.MovieResults[0], nil
+}
+
+// GetInfoContext is the same as GetInfo, but the request sent to TMDB API
+// endpoint is cancelled as soon as ctx is done.
+func (tmdb *TMDb) GetInfoContext(ctx context.Context, title string, year string) (*metadata.Movie, error) {
 	// call searchMovie to send search request to tmdb API endpoint
-	result, err := tmdb.searchMovie(title, year)
+	result, err := tmdb.searchMovie(ctx, title, year)
 	if err != nil {
 		return nil, err
 	}
 	// if the total result 0, return error
-	if result.TotalResults == 0 {
+	if result.TotalResults == 0 || len(result.Results) == 0 {
 		return nil, fmt.Errorf("Couldn't find %s (%s) in TMDb", title, year)
 	}
 	// format the return value when a match is found
-	return &MovieInfo{
-		Title:     title,
-		Year:      year,
-		Thumbnail: fmt.Sprintf("%s%s", posterBaseURL, result.Results[0].PosterPath),
-		Synopsis:  result.Results[0].Overview,
-	}, nil
+	movie := result.Results[0].info()
+	movie.Title = title
+	movie.Year = year
+	return movie, nil
+}
+
+// info converts result into metadata.Movie
+func (res *result) info() *metadata.Movie {
+	movie := &metadata.Movie{
+		Title:    res.Title,
+		Synopsis: res.Overview,
+	}
+	// movies without poster are shown without image
+	if len(res.PosterPath) > 0 {
+		movie.Thumbnail = posterBaseURL + res.PosterPath
+	}
+	if len(res.ReleaseDate) >= 4 {
+		movie.Year = res.ReleaseDate[:4]
+	}
+	if res.ID > 0 {
+		movie.TMDbID = strconv.Itoa(res.ID)
+	}
+	if res.VoteAverage > 0 {
+		movie.Ratings = []metadata.Rating{{
+			Source: "TMDb",
+			Value:  fmt.Sprintf("%.1f/10", res.VoteAverage),
+		}}
+	}
+	return movie
 }
 
 //SearchMovie will send get request to tmdb API search endpoint and will return
 // an instance of searchResult.
-func (tmdb *TMDb) searchMovie(title string, year string) (*searchResult, error) {
-	// format the url for tmdb get request
-	var URL = fmt.Sprintf("%s/search/movie?api_key=%s&query=%s&year=%s", baseURL, tmdb.APIKey, url.QueryEscape(title), year)
+func (tmdb *TMDb) searchMovie(ctx context.Context, title string, year string) (*searchResult, error) {
+	query := url.Values{}
+	query.Set("query", title)
+	query.Set("year", year)
+	var resp searchResult
+	if err := tmdb.get(ctx, "/search/movie", query, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// get sends get request to the given path of tmdb API endpoint and decodes
+// the json body of the response into target.
+func (tmdb *TMDb) get(ctx context.Context, path string, query url.Values, target interface{}) error {
+	base := tmdb.baseURL
+	if len(base) == 0 {
+		base = baseURL
+	}
+	// the API key only goes into the query string when there is no
+	// access token to authenticate with
+	if len(tmdb.accessToken) == 0 {
+		query.Set("api_key", tmdb.APIKey)
+	}
 	// create new instance of http request
-	request, _ := http.NewRequest("GET", URL, nil)
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s%s?%s", base, path, query.Encode()), nil)
+	if err != nil {
+		return tmdb.redact(err)
+	}
+	request = request.WithContext(ctx)
+	if len(tmdb.accessToken) > 0 {
+		request.Header.Set("Authorization", "Bearer "+tmdb.accessToken)
+	}
+	if len(tmdb.userAgent) > 0 {
+		request.Header.Set("User-Agent", tmdb.userAgent)
+	}
 	// send get request to url defined
 	res, err := tmdb.Client.Do(request)
 	if err != nil {
-		return nil, err
+		return tmdb.redact(err)
 	}
+	defer res.Body.Close()
 	// if response status code is not 200 the return error
 	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP response %d", res.StatusCode)
+		return fmt.Errorf("HTTP response %d", res.StatusCode)
 	}
 	// read body data from response
-	body, _ := ioutil.ReadAll(res.Body)
-	// prepare target for json unmarshaling of body data
-	var resp searchResult
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return tmdb.redact(err)
+	}
 	// extract data from body
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, err
+	return json.Unmarshal(body, target)
+}
+
+// redact removes the API key from the message of err, since errors coming
+// from the http client quote the full request URL. The *url.Error of the
+// http client keeps its cause so that timeouts can still be told apart.
+func (tmdb *TMDb) redact(err error) error {
+	if err == nil || len(tmdb.APIKey) == 0 || !strings.Contains(err.Error(), tmdb.APIKey) {
+		return err
 	}
-	// return data
-	return &resp, nil
+	if e, ok := err.(*url.Error); ok {
+		return &url.Error{Op: e.Op, URL: strings.Replace(e.URL, tmdb.APIKey, redacted, -1), Err: tmdb.redact(e.Err)}
+	}
+	return &redactedError{message: strings.Replace(err.Error(), tmdb.APIKey, redacted, -1), err: tmdb.redact(errors.Unwrap(err))}
+}
+
+// redactedError is an error whose message had the API key removed, it
+// wraps the error the original one wrapped, redacted as well
+type redactedError struct {
+	message string
+	err     error
+}
+
+// Error implements error
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+// Unwrap returns the redacted error the original one wrapped
+func (e *redactedError) Unwrap() error {
+	return e.err
 }
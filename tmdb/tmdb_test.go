@@ -2,18 +2,24 @@ package tmdb
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/rimaulana/plexgoslack/metadata"
 )
 
 var (
 	jsonSuccess = "{\"total_results\":1,\"results\":[{\"poster_path\":\"/poster/path\",\"overview\":\"test overview\",\"title\":\"test title\"}],\"page\":1}"
 	jsonEmpty   = "{\"total_results\":0,\"results\":[],\"page\":1}"
-	resultInfo  = &MovieInfo{
+	resultInfo  = &metadata.Movie{
 		Title:     "test title",
 		Year:      "2018",
 		Thumbnail: fmt.Sprintf("%s/poster/path", posterBaseURL),
@@ -31,15 +37,20 @@ func generalSet(statusCode int, body string) *http.Response {
 type httpClientStub struct {
 	res *http.Response
 	err error
+	req *http.Request
 }
 
-func (cl *httpClientStub) Do(*http.Request) (*http.Response, error) {
+func (cl *httpClientStub) Do(req *http.Request) (*http.Response, error) {
+	cl.req = req
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
 	return cl.res, cl.err
 }
 
 var cases = []struct {
 	name         string
-	result       *MovieInfo
+	result       *metadata.Movie
 	clientStub   *httpClientStub
 	errorMessage string
 }{
@@ -110,3 +121,196 @@ func TestTmdb_GetInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestTmdb_Options(t *testing.T) {
+	proxy, _ := url.Parse("http://proxy.local:3128")
+	transport := &http.Transport{}
+	db := New("1234567890",
+		WithBaseURL("http://localhost:8080/3/"),
+		WithTimeout(time.Second*30),
+		WithUserAgent("plexgoslack-test"),
+		WithAccessToken("v4token"),
+		WithTransport(transport),
+		WithProxy(proxy),
+	)
+	client := db.Client.(*http.Client)
+	if client.Timeout != time.Second*30 {
+		t.Errorf("Timeout = %v, want %v", client.Timeout, time.Second*30)
+	}
+	used, ok := client.Transport.(*http.Transport)
+	if !ok || used == transport {
+		t.Fatalf("Transport = %v, want a copy of %v", client.Transport, transport)
+	}
+	if got, _ := used.Proxy(&http.Request{}); got.String() != proxy.String() {
+		t.Errorf("Proxy = %v, want %v", got, proxy)
+	}
+	if transport.Proxy != nil {
+		t.Error("WithProxy() changed the proxy of the given transport")
+	}
+
+	stub := &httpClientStub{res: generalSet(200, jsonSuccess)}
+	db.Client = stub
+	if _, err := db.GetInfo(resultInfo.Title, resultInfo.Year); err != nil {
+		t.Fatalf("GetInfo() unexpected error %v", err)
+	}
+	if got, want := stub.req.URL.String(), "http://localhost:8080/3/search/movie?query=test+title&year=2018"; got != want {
+		t.Errorf("URL = %v, want %v", got, want)
+	}
+	if got, want := stub.req.Header.Get("Authorization"), "Bearer v4token"; got != want {
+		t.Errorf("Authorization = %v, want %v", got, want)
+	}
+	if got, want := stub.req.Header.Get("User-Agent"), "plexgoslack-test"; got != want {
+		t.Errorf("User-Agent = %v, want %v", got, want)
+	}
+}
+
+func TestTmdb_RedactAPIKey(t *testing.T) {
+	db := New("1234567890")
+	db.Client = &httpClientStub{
+		err: &url.Error{Op: "Get", URL: "http://api.themoviedb.org/3/search/movie?api_key=1234567890", Err: fmt.Errorf("Timeout reached")},
+	}
+	_, err := db.GetInfo(resultInfo.Title, resultInfo.Year)
+	if err == nil {
+		t.Fatal("GetInfo() expected an error")
+	}
+	if strings.Contains(err.Error(), "1234567890") {
+		t.Errorf("Error in GetInfo() = %v, API key is not redacted", err)
+	}
+	if _, ok := err.(*url.Error); !ok {
+		t.Errorf("Error in GetInfo() = %T, want the *url.Error of the http client", err)
+	}
+}
+
+func TestTmdb_RedactWrapped(t *testing.T) {
+	db := New("1234567890")
+	cause := fmt.Errorf("dial api_key=1234567890: connection refused")
+	err := db.redact(fmt.Errorf("request api_key=1234567890: %w", cause))
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if strings.Contains(e.Error(), "1234567890") {
+			t.Errorf("redact() wraps %q, API key is not redacted", e)
+		}
+	}
+	if errors.Unwrap(err) == nil {
+		t.Error("redact() dropped the wrapped error")
+	}
+}
+
+func TestTmdb_GetInfoContext(t *testing.T) {
+	db := New("1234567890")
+	db.Client = &httpClientStub{res: generalSet(200, jsonSuccess)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.GetInfoContext(ctx, resultInfo.Title, resultInfo.Year); err == nil {
+		t.Error("GetInfoContext() expected an error from a cancelled context")
+	}
+}
+
+func TestTmdb_Lookup(t *testing.T) {
+	db := New("1234567890")
+	db.Client = &httpClientStub{
+		res: generalSet(200, "{\"total_results\":1,\"results\":[{\"poster_path\":\"/poster/path\",\"overview\":\"test overview\",\"vote_average\":7.3}]}"),
+	}
+	var provider metadata.Provider = db
+	info, err := provider.Lookup(context.Background(), metadata.Query{Title: resultInfo.Title, Year: resultInfo.Year})
+	if err != nil {
+		t.Fatalf("Lookup() unexpected error %v", err)
+	}
+	want := []metadata.Rating{{Source: "TMDb", Value: "7.3/10"}}
+	if !reflect.DeepEqual(info.Ratings, want) {
+		t.Errorf("Lookup() ratings = %v, want %v", info.Ratings, want)
+	}
+
+	db.Client = &httpClientStub{res: generalSet(200, "{\"total_results\":1,\"results\":[{\"poster_path\":null,\"overview\":\"test overview\"}]}")}
+	info, err = provider.Lookup(context.Background(), metadata.Query{Title: resultInfo.Title, Year: resultInfo.Year})
+	if err != nil || len(info.Thumbnail) > 0 {
+		t.Errorf("Lookup() = %+v, %v, want no thumbnail for a movie without poster", info, err)
+	}
+}
+
+type routeStub struct {
+	bodies   map[string]string
+	requests []string
+}
+
+func (cl *routeStub) Do(req *http.Request) (*http.Response, error) {
+	cl.requests = append(cl.requests, req.URL.Path)
+	body, ok := cl.bodies[req.URL.Path]
+	if !ok {
+		return generalSet(404, ""), nil
+	}
+	return generalSet(200, body), nil
+}
+
+func TestTmdb_LookupByID(t *testing.T) {
+	movieJSON := "{\"id\":42,\"title\":\"Test Title\",\"release_date\":\"2018-05-04\",\"poster_path\":\"/poster/path\",\"overview\":\"test overview\",\"imdb_id\":\"tt0000042\",\"genres\":[{\"id\":18,\"name\":\"Drama\"}]}"
+	videosJSON := "{\"results\":[{\"key\":\"teaser\",\"site\":\"YouTube\",\"type\":\"Teaser\",\"official\":true},{\"key\":\"fan\",\"site\":\"YouTube\",\"type\":\"Trailer\",\"official\":false},{\"key\":\"official\",\"site\":\"YouTube\",\"type\":\"Trailer\",\"official\":true}]}"
+	want := &metadata.Movie{
+		Title:         "Test Title",
+		Year:          "2018",
+		Thumbnail:     fmt.Sprintf("%s/poster/path", posterBaseURL),
+		Synopsis:      "test overview",
+		Genres:        []string{"Drama"},
+		Certification: "PG-13",
+		TMDbID:        "42",
+		IMDbID:        "tt0000042",
+		Trailer:       "https://www.youtube.com/watch?v=official",
+	}
+	lookups := []struct {
+		name     string
+		query    metadata.Query
+		requests []string
+	}{
+		{
+			name:     "case exact fetch by tmdb id",
+			query:    metadata.Query{Title: "test title", Year: "2018", TMDbID: "42"},
+			requests: []string{"/3/movie/42", "/3/movie/42/videos", "/3/movie/42/release_dates"},
+		},
+		{
+			name:     "case exact fetch by imdb id",
+			query:    metadata.Query{Title: "test title", Year: "2018", IMDbID: "tt0000042"},
+			requests: []string{"/3/find/tt0000042", "/3/movie/42", "/3/movie/42/videos", "/3/movie/42/release_dates"},
+		},
+	}
+	for _, tt := range lookups {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &routeStub{bodies: map[string]string{
+				"/3/movie/42":               movieJSON,
+				"/3/find/tt0000042":         "{\"movie_results\":[{\"id\":42}]}",
+				"/3/movie/42/videos":        videosJSON,
+				"/3/movie/42/release_dates": "{\"results\":[{\"iso_3166_1\":\"DE\",\"release_dates\":[{\"certification\":\"12\"}]},{\"iso_3166_1\":\"US\",\"release_dates\":[{\"certification\":\"\"},{\"certification\":\"PG-13\"}]}]}",
+			}}
+			db := New("1234567890")
+			db.Client = stub
+			info, err := db.Lookup(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("Lookup() unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(info, want) {
+				t.Errorf("Lookup() = %v, want %v", info, want)
+			}
+			if !reflect.DeepEqual(stub.requests, tt.requests) {
+				t.Errorf("Lookup() requested %v, want %v", stub.requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestTmdb_LookupSearchWithLinks(t *testing.T) {
+	stub := &routeStub{bodies: map[string]string{
+		"/3/search/movie":          "{\"total_results\":1,\"results\":[{\"id\":42,\"poster_path\":\"/poster/path\",\"overview\":\"test overview\"}]}",
+		"/3/movie/42/external_ids": "{\"imdb_id\":\"tt0000042\"}",
+	}}
+	db := New("1234567890")
+	db.Client = stub
+	info, err := db.Lookup(context.Background(), metadata.Query{Title: resultInfo.Title, Year: resultInfo.Year})
+	if err != nil {
+		t.Fatalf("Lookup() unexpected error %v", err)
+	}
+	if info.IMDbID != "tt0000042" || len(info.Trailer) > 0 {
+		t.Errorf("Lookup() = %v, want IMDb id tt0000042 and no trailer", info)
+	}
+	want := []string{"/3/search/movie", "/3/movie/42/videos", "/3/movie/42/external_ids", "/3/movie/42/release_dates"}
+	if !reflect.DeepEqual(stub.requests, want) {
+		t.Errorf("Lookup() requested %v, want %v", stub.requests, want)
+	}
+}
@@ -0,0 +1,113 @@
+// Package transport builds the transports of the HTTP clients talking
+// to TMDb, OMDb and Slack, so that every one of them goes through the
+// configured proxy and trusts the configured certificate authorities.
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// Direct is the proxy setting connecting without proxy, even when
+	// one is set globally or in the environment
+	Direct = "direct"
+)
+
+// Settings describes how outbound connections are made
+type Settings struct {
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy, the proxy of
+	// HTTP_PROXY and HTTPS_PROXY environment variables is used when
+	// it is empty
+	Proxy string
+	// CABundle is the path of a PEM file holding certificate
+	// authorities trusted along with the ones of the system
+	CABundle string
+}
+
+// Merge returns settings with the values set in override replacing
+// its own
+func (settings Settings) Merge(override Settings) Settings {
+	if len(override.Proxy) > 0 {
+		settings.Proxy = override.Proxy
+	}
+	if len(override.CABundle) > 0 {
+		settings.CABundle = override.CABundle
+	}
+	return settings
+}
+
+// New creates a transport applying settings, it otherwise behaves
+// like http.DefaultTransport
+func New(settings Settings) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	switch settings.Proxy {
+	case "":
+	case Direct:
+		transport.Proxy = nil
+	default:
+		proxy, err := ParseProxy(settings.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if len(settings.CABundle) > 0 {
+		pool, err := certPool(settings.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return transport, nil
+}
+
+// ParseProxy parses the URL of an HTTP, HTTPS or SOCKS5 proxy
+func ParseProxy(raw string) (*url.URL, error) {
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %s", raw, err)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https or socks5", raw)
+	}
+	if len(proxy.Host) == 0 {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", raw)
+	}
+	return proxy, nil
+}
+
+// certPool returns the certificate authorities of the system along
+// with the ones found in the PEM file at path
+func certPool(path string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CA bundle: %s", err)
+	}
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("CA bundle: no certificate found in %s", path)
+	}
+	return pool, nil
+}